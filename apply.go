@@ -0,0 +1,60 @@
+package rbac
+
+// Apply applies the changeset `cs` to the RBAC instance.
+// Roles are added first, then role permissions are updated,
+// parents are unbound and bound, and finally roles are removed.
+// Apply is not atomic: it stops at the first error and returns the
+// changes applied until then, which stay applied. Applying the
+// remaining changes requires a new Diff.
+func (rbac *RBAC) Apply(cs *Changeset) (*Changeset, error) {
+	applied := &Changeset{}
+	for _, rc := range cs.AddedRoles {
+		role := &RBACRole{Name: rc.Name, Source: rc.Source}
+		for _, pid := range rc.AddedPermissions {
+			role.AddPermission(&RBACPermission{Name: pid})
+		}
+		if err := rbac.Add(role); err != nil {
+			return applied, err
+		}
+		applied.AddedRoles = append(applied.AddedRoles, rc)
+	}
+	for _, rc := range cs.ChangedRoles {
+		r, _, err := rbac.Get(rc.ID)
+		if err != nil {
+			return applied, err
+		}
+		role, ok := r.(*RBACRole)
+		if !ok {
+			return applied, ErrRoleNotExist
+		}
+		for _, pid := range rc.AddedPermissions {
+			role.AddPermission(&RBACPermission{Name: pid})
+		}
+		for _, pid := range rc.RemovedPermissions {
+			role.RemovePermission(pid)
+		}
+		if err := rbac.Set(role); err != nil {
+			return applied, err
+		}
+		applied.ChangedRoles = append(applied.ChangedRoles, rc)
+	}
+	for _, e := range cs.RemovedParents {
+		if err := rbac.RemoveParent(e.Child, e.Parent); err != nil {
+			return applied, err
+		}
+		applied.RemovedParents = append(applied.RemovedParents, e)
+	}
+	for _, e := range cs.AddedParents {
+		if err := rbac.SetParent(e.Child, e.Parent); err != nil {
+			return applied, err
+		}
+		applied.AddedParents = append(applied.AddedParents, e)
+	}
+	for _, id := range cs.RemovedRoles {
+		if err := rbac.Remove(id); err != nil {
+			return applied, err
+		}
+		applied.RemovedRoles = append(applied.RemovedRoles, id)
+	}
+	return applied, nil
+}
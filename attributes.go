@@ -0,0 +1,152 @@
+package rbac
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Attributes holds the attributes of a request, usually below the keys
+// `subject`, `resource` and `environment`. Nested attributes are
+// addressed by dotted paths like `resource.owner`.
+type Attributes map[string]interface{}
+
+// Lookup returns the attribute at the dotted `path`.
+func (a Attributes) Lookup(path string) (interface{}, bool) {
+	var current interface{} = map[string]interface{}(a)
+	for _, key := range strings.Split(path, ".") {
+		switch m := current.(type) {
+		case map[string]interface{}:
+			v, ok := m[key]
+			if !ok {
+				return nil, false
+			}
+			current = v
+		case Attributes:
+			v, ok := m[key]
+			if !ok {
+				return nil, false
+			}
+			current = v
+		case map[string]string:
+			v, ok := m[key]
+			if !ok {
+				return nil, false
+			}
+			current = v
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// Operators of attribute conditions.
+const (
+	OpEqual        = "eq"
+	OpNotEqual     = "ne"
+	OpIn           = "in"
+	OpNotIn        = "notIn"
+	OpLess         = "lt"
+	OpLessEqual    = "le"
+	OpGreater      = "gt"
+	OpGreaterEqual = "ge"
+	OpExists       = "exists"
+)
+
+var (
+	// ErrInvalidCondition occurred if an attribute condition is malformed
+	ErrInvalidCondition = errors.New("invalid condition")
+)
+
+// AttributeCondition compares the attribute `Attribute` with `Value`,
+// or with the attribute `Ref` if it is set, using `Operator`.
+// `in` and `notIn` expect a list, `lt`, `le`, `gt` and `ge` numbers,
+// and `exists` no operand.
+type AttributeCondition struct {
+	Attribute string
+	Operator  string
+	Value     interface{}
+	Ref       string
+}
+
+// Validate reports a malformed condition.
+func (c AttributeCondition) Validate() error {
+	if c.Attribute == "" {
+		return fmt.Errorf("%w: missing attribute", ErrInvalidCondition)
+	}
+	if c.Ref != "" && c.Value != nil {
+		return fmt.Errorf("%w: both value and ref are set", ErrInvalidCondition)
+	}
+	switch c.Operator {
+	case OpEqual, OpNotEqual:
+	case OpIn, OpNotIn:
+		if _, ok := normalize(c.Value).([]interface{}); c.Ref == "" && !ok {
+			return fmt.Errorf("%w: operator %q requires a list", ErrInvalidCondition, c.Operator)
+		}
+	case OpLess, OpLessEqual, OpGreater, OpGreaterEqual:
+		if _, ok := normalize(c.Value).(float64); c.Ref == "" && !ok {
+			return fmt.Errorf("%w: operator %q requires a number", ErrInvalidCondition, c.Operator)
+		}
+	case OpExists:
+		if c.Ref != "" || c.Value != nil {
+			return fmt.Errorf("%w: operator %q takes no operand", ErrInvalidCondition, c.Operator)
+		}
+	default:
+		return fmt.Errorf("%w: unknown operator %q", ErrInvalidCondition, c.Operator)
+	}
+	return nil
+}
+
+// evaluate returns the result of the condition. `ok` is false if an
+// attribute the condition refers to is missing. Attributes and operands
+// are normalized like the values of expressions, see normalize.
+func (c AttributeCondition) evaluate(attrs Attributes) (result, ok bool) {
+	v, ok := attrs.Lookup(c.Attribute)
+	if c.Operator == OpExists {
+		return ok, true
+	}
+	if !ok {
+		return false, false
+	}
+	v = normalize(v)
+	operand := normalize(c.Value)
+	if c.Ref != "" {
+		if operand, ok = attrs.Lookup(c.Ref); !ok {
+			return false, false
+		}
+		operand = normalize(operand)
+	}
+	switch c.Operator {
+	case OpEqual:
+		return exprEqual(v, operand), true
+	case OpNotEqual:
+		return !exprEqual(v, operand), true
+	case OpIn, OpNotIn:
+		list, ok := operand.([]interface{})
+		if !ok {
+			return false, true
+		}
+		found := false
+		for i := 0; i < len(list) && !found; i++ {
+			found = exprEqual(v, list[i])
+		}
+		return found == (c.Operator == OpIn), true
+	}
+	a, okA := v.(float64)
+	b, okB := operand.(float64)
+	if !okA || !okB {
+		return false, true
+	}
+	switch c.Operator {
+	case OpLess:
+		return a < b, true
+	case OpLessEqual:
+		return a <= b, true
+	case OpGreater:
+		return a > b, true
+	case OpGreaterEqual:
+		return a >= b, true
+	}
+	return false, true
+}
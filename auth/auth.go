@@ -1,15 +1,15 @@
 package auth
 
 import (
-	"encoding/json"
+	"fmt"
 	"github.com/mikespook/gorbac"
-	log "github.com/z26100/log-go"
 	rbac2 "github.com/z26100/rbac-go"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"gopkg.in/yaml.v3"
-	"os"
+	"io"
+	"sort"
 	"strings"
+	"sync/atomic"
 )
 
 type FileType string
@@ -17,19 +17,42 @@ type FileType string
 const (
 	JSON FileType = "json"
 	YAML FileType = "yaml"
+	TOML FileType = "toml"
+	HCL  FileType = "hcl"
 	AUTO FileType = "auto"
 )
 
 var (
 	fileType = AUTO
-	rbac     *rbac2.RBAC
+	// current holds the *rbac2.RBAC instance used by the package functions
+	current atomic.Value
+	// factory holds the func creating instances like the current one,
+	// used by Watch
+	factory atomic.Value
+	// closer holds the func releasing the resources of the current
+	// policy, used by CloseRBAC
+	closer atomic.Value
 )
 
+func instance() *rbac2.RBAC {
+	r, _ := current.Load().(*rbac2.RBAC)
+	return r
+}
+
+// newInstance returns an empty instance with a backend of the same kind
+// as the current one, see NewRBAC and NewMongo.
+func newInstance() (*rbac2.RBAC, error) {
+	if f, ok := factory.Load().(func() (*rbac2.RBAC, error)); ok {
+		return f()
+	}
+	return rbac2.Default(), nil
+}
+
 func NewRole(name string) (*rbac2.RBACRole, error) {
 	role := &rbac2.RBACRole{
 		Name: name,
 	}
-	err := rbac.Add(role)
+	err := instance().Add(role)
 	if err != nil {
 		return nil, err
 	}
@@ -44,11 +67,11 @@ func InterfaceAsString(in []interface{}) []string {
 	return p
 }
 func SetParents(id string, parents []string) error {
-	return rbac.SetParents(id, parents)
+	return instance().SetParents(id, parents)
 }
 
 func GetRole(id string) (*rbac2.RBACRole, []string, error) {
-	role, parents, err := rbac.Get(id)
+	role, parents, err := instance().Get(id)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -56,7 +79,7 @@ func GetRole(id string) (*rbac2.RBACRole, []string, error) {
 }
 
 func GetBackend() *rbac2.RBAC {
-	return rbac
+	return instance()
 }
 
 func AddPermission(name string) *rbac2.RBACPermission {
@@ -67,14 +90,25 @@ func AddPermission(name string) *rbac2.RBACPermission {
 }
 
 func AssignRole(role *rbac2.RBACRole, permission *rbac2.RBACPermission) error {
-	err := rbac.AssignRole(role, permission)
+	err := instance().AssignRole(role, permission)
 	if err != nil {
 		return err
 	}
-	return rbac.Set(role)
+	return instance().Set(role)
 }
 func IsGranted(roleId string, p rbac2.RBACPermission, fc rbac2.AssertionFunc) bool {
-	return rbac.IsGranted(roleId, p, fc)
+	return instance().IsGranted(roleId, p, fc)
+}
+
+// IsGrantedWithContext tests if the role `roleId` has the permission `p`,
+// evaluating attribute conditions against `attrs`.
+func IsGrantedWithContext(roleId string, p rbac2.RBACPermission, attrs rbac2.Attributes, fc rbac2.AssertionFunc) bool {
+	return instance().IsGrantedWithContext(roleId, p, attrs, fc)
+}
+
+// NewSession returns a session of the role `subject` without active roles.
+func NewSession(subject string, opts rbac2.SessionOptions) (*rbac2.Session, error) {
+	return instance().NewSession(subject, opts)
 }
 
 func IsPermitted(roles []gorbac.Role, action string) bool {
@@ -89,12 +123,21 @@ func IsPermitted(roles []gorbac.Role, action string) bool {
 	return false
 }
 
-func NewMongo(opts *options.ClientOptions, database string) error {
+// NewMongo connects to MongoDB and uses a MongoBackend configured by
+// `backendOpts` for the current policy. Watch loads changed policies
+// into the collections of the tenants `_reload0` and `_reload1` in turn.
+func NewMongo(opts *options.ClientOptions, database string, backendOpts ...rbac2.MongoOption) error {
 	client, err := mongo.NewClient(options.Client().ApplyURI(opts.GetURI()).SetAuth(*opts.Auth))
 	if err != nil {
 		return err
 	}
-	ctx, cancelFc := rbac2.Ctx()
+	// the backend only uses the client once connected,
+	// but configures the timeout of connecting
+	b, err := rbac2.NewMongoBackend(client, database, backendOpts...)
+	if err != nil {
+		return err
+	}
+	ctx, cancelFc := b.Ctx()
 	defer cancelFc()
 	err = client.Connect(ctx)
 	if err != nil {
@@ -104,150 +147,226 @@ func NewMongo(opts *options.ClientOptions, database string) error {
 	if err != nil {
 		return err
 	}
-	b, err := rbac2.NewMongoBackend(client, database)
+	err = b.EnsureSchema()
 	if err != nil {
 		return err
 	}
-	rbac = rbac2.New(b)
+	current.Store(rbac2.New(b))
+	factory.Store(reloadFactory(b))
+	closer.Store(b.Close)
 	return nil
 }
 
+// reloadFactory returns a factory alternating between two sets of
+// collections of `b`, see MongoBackend.Tenant. It clears and returns
+// the set the current instance doesn't use, so a policy can be loaded
+// completely before it replaces the current one.
+func reloadFactory(b *rbac2.MongoBackend) func() (*rbac2.RBAC, error) {
+	var reloads [2]*rbac2.RBAC
+	return func() (*rbac2.RBAC, error) {
+		i := 0
+		if old := instance(); reloads[0] != nil && old != nil && reloads[0].SharesBackend(old) {
+			i = 1
+		}
+		if reloads[i] == nil {
+			t, err := b.Tenant(fmt.Sprintf("_reload%d", i))
+			if err != nil {
+				return nil, err
+			}
+			reloads[i] = rbac2.New(t)
+		}
+		if err := reloads[i].Clear(); err != nil {
+			return nil, err
+		}
+		return reloads[i], nil
+	}
+}
+
 func NewRBAC() {
-	rbac = rbac2.Default()
+	current.Store(rbac2.Default())
+	factory.Store(func() (*rbac2.RBAC, error) { return rbac2.Default(), nil })
+	closer.Store(func() error { return instance().Close() })
 }
 
 func Clear() {
-	rbac.Clear()
+	instance().Clear()
 }
 func CloseRBAC() {
-	rbac.Close()
+	if f, ok := closer.Load().(func() error); ok {
+		f()
+	}
 }
 
 func SetFileType(t FileType) {
 	fileType = t
 }
 
+// LoadFromFile adds the policy stored in `filename` to the current policy.
+// `filename` may be a file, a directory or a glob pattern, see ParsePolicyFiles.
 func LoadFromFile(filename string) error {
-	if fileType == AUTO {
-		setAutoFileType(filename)
+	return loadFile(instance(), filename)
+}
+
+// DiffFile returns the changes required to turn the current
+// policy into the one stored in `filename`.
+func DiffFile(filename string) (*rbac2.Changeset, error) {
+	target := rbac2.Default()
+	defer target.Close()
+	if err := loadFile(target, filename); err != nil {
+		return nil, err
+	}
+	return rbac2.Diff(instance(), target)
+}
+
+// DiffFiles returns the changes required to turn the policy
+// stored in `from` into the one stored in `to`.
+func DiffFiles(from, to string) (*rbac2.Changeset, error) {
+	src := rbac2.Default()
+	defer src.Close()
+	if err := loadFile(src, from); err != nil {
+		return nil, err
+	}
+	dst := rbac2.Default()
+	defer dst.Close()
+	if err := loadFile(dst, to); err != nil {
+		return nil, err
 	}
-	var data map[string]interface{}
-	var err error
-	switch fileType {
-	case JSON:
-		err = loadJson(filename, &data)
-	default:
-		err = loadYaml(filename, &data)
+	return rbac2.Diff(src, dst)
+}
+
+func loadFile(target *rbac2.RBAC, filename string) error {
+	p, err := ParsePolicyFiles(filename)
+	if err != nil {
+		return err
 	}
-	roles := data["roles"].(map[string]interface{})
-	inher := data["inher"].(map[string]interface{})
+	return applyPolicy(target, p)
+}
+
+// LoadPolicy adds the roles and the inheritance of `p`
+// to the current policy.
+func LoadPolicy(p *Policy) error {
+	return applyPolicy(instance(), p)
+}
 
-	permissions := make(map[string]*rbac2.RBACPermission)
+// applyPolicy adds the roles of `p` to `target` and binds their parents.
+// It continues after errors and returns all of them by a LoadError.
+func applyPolicy(target *rbac2.RBAC, p *Policy) error {
+	var errs []error
 	// Build Roles and add them to goRBAC instance
-	for rid, pids := range roles {
-		role, err := NewRole(rid)
-		if err == nil {
-			for _, pid := range pids.([]interface{}) {
-				_, ok := permissions[pid.(string)]
-				if !ok {
-					permissions[pid.(string)] = AddPermission(pid.(string))
-				}
-				err = rbac.AssignRole(role, permissions[pid.(string)])
-			}
-		} else {
-			log.Error(err)
+	for _, name := range sortedNames(p.Roles) {
+		role := &rbac2.RBACRole{Name: name, Source: p.Sources[name]}
+		for _, pid := range p.Roles[name] {
+			role.AddPermission(grantOf(p.Grants[name], pid).permission())
+		}
+		for _, pid := range p.Deny[name] {
+			role.AddDeny(grantOf(p.DenyGrants[name], pid).permission())
+		}
+		if err := target.Add(role); err != nil {
+			errs = append(errs, fmt.Errorf("role %q: %w", name, err))
 		}
 	}
 	// Assign the inheritance relationship
-	for rid, parents := range inher {
-		if len(parents.([]interface{})) == 0 {
-			break
+	for _, name := range sortedNames(p.Inher) {
+		parents := p.Inher[name]
+		if len(parents) == 0 {
+			continue
+		}
+		if err := target.SetParents(strings.ToLower(name), lower(parents)); err != nil {
+			errs = append(errs, fmt.Errorf("parents of role %q: %w", name, err))
+			continue
 		}
-		if err := SetParents(rid, InterfaceAsString(parents.([]interface{}))); err != nil {
-			log.Fatal(err)
+		for _, parent := range parents {
+			v, ok := p.InherValidity[name][parent]
+			if !ok {
+				continue
+			}
+			if err := target.SetParentValidity(strings.ToLower(name), strings.ToLower(parent), v); err != nil {
+				errs = append(errs, fmt.Errorf("validity of parent %q of role %q: %w", parent, name, err))
+			}
 		}
 	}
-	return err
-}
-
-func SaveAsFilename(filename string) error {
-	if fileType == AUTO {
-		setAutoFileType(filename)
+	// Add the constraints once the inheritance they restrict is complete,
+	// as prerequisites can't be met role by role
+	names := make([]string, 0, len(p.Constraints))
+	for name := range p.Constraints {
+		names = append(names, name)
 	}
-
-	// map[RoleId]PermissionIds
-	outputRoles := make(map[string][]string)
-	// map[RoleId]ParentIds
-	outputInher := make(map[string][]string)
-
-	SaveJsonHandler := func(role gorbac.Role, parents []string) error {
-		// WARNING: Don't use rbacmap instance in the handler,
-		// otherwise it causes deadlock.
-		permissions := make([]string, 0)
-		for _, p := range role.(*rbac2.RBACRole).GetPermissions() {
-			permissions = append(permissions, p.ID())
+	sort.Strings(names)
+	for _, name := range names {
+		if err := target.AddConstraint(p.Constraints[name]); err != nil {
+			errs = append(errs, fmt.Errorf("constraint %q: %w", name, err))
 		}
-		outputRoles[role.ID()] = permissions
-		outputInher[role.ID()] = parents
-		return nil
 	}
-	if err := rbac2.Walk(rbac, SaveJsonHandler); err != nil {
-		return err
+	if len(errs) > 0 {
+		return &LoadError{Errors: errs}
 	}
-	// Save Roles information
-	data := make(map[string]interface{})
-	data["roles"] = outputRoles
-	data["inher"] = outputInher
+	return nil
+}
 
-	var err error
-	switch fileType {
-	case JSON:
-		err = saveJson(filename, data)
-	default:
-		err = saveYaml(filename, data)
-	}
-	return err
+// LoadError collects every error occurred while loading a policy.
+type LoadError struct {
+	Errors []error
 }
 
-func setAutoFileType(filename string) {
-	if strings.HasSuffix(strings.ToLower(filename), ".json") {
-		SetFileType(JSON)
-	} else {
-		SetFileType(YAML)
+func (e *LoadError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
 	}
+	return strings.Join(msgs, "\n")
 }
-func loadJson(filename string, v interface{}) error {
-	f, err := os.Open(filename)
-	if err != nil {
-		return err
+
+func sortedNames(in map[string][]string) []string {
+	result := make([]string, 0, len(in))
+	for name := range in {
+		result = append(result, name)
 	}
-	defer f.Close()
-	return json.NewDecoder(f).Decode(v)
+	sort.Strings(result)
+	return result
 }
 
-func saveJson(filename string, v interface{}) error {
-	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
+func lower(in []string) []string {
+	result := make([]string, len(in))
+	for i, s := range in {
+		result[i] = strings.ToLower(s)
 	}
-	defer f.Close()
-	return json.NewEncoder(f).Encode(v)
+	return result
 }
 
-func loadYaml(filename string, v interface{}) error {
-	f, err := os.Open(filename)
+// SaveAsFilename writes the current policy to `filename`.
+// Roles, permissions and parents are sorted, so saving the same policy
+// twice results in the same file. The file is replaced atomically.
+func SaveAsFilename(filename string) error {
+	p, err := currentPolicy(instance())
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	return yaml.NewDecoder(f).Decode(v)
-}
-
-func saveYaml(filename string, v interface{}) error {
-	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY, 0644)
+	t := fileType
+	if t == AUTO {
+		t = detectFileType(filename, YAML)
+	}
+	c, err := codecFor(t)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	return yaml.NewEncoder(f).Encode(v)
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		return c.Encode(w, p, saveOptions)
+	})
+}
+
+// SaveAsDOT writes the role hierarchy as Graphviz DOT to `filename`.
+func SaveAsDOT(filename string, opts rbac2.GraphOptions) error {
+	return saveGraph(filename, opts, rbac2.ExportDOT)
+}
+
+// SaveAsMermaid writes the role hierarchy as a Mermaid flowchart to `filename`.
+func SaveAsMermaid(filename string, opts rbac2.GraphOptions) error {
+	return saveGraph(filename, opts, rbac2.ExportMermaid)
+}
+
+func saveGraph(filename string, opts rbac2.GraphOptions,
+	export func(*rbac2.RBAC, io.Writer, rbac2.GraphOptions) error) error {
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		return export(instance(), w, opts)
+	})
 }
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Codec converts policy documents from and to a file format.
+type Codec interface {
+	// Decode parses `data` into the root nodes of its policy documents.
+	// The nodes are validated like YAML documents; errors are reported
+	// with the positions the nodes carry, if any.
+	Decode(data []byte) ([]*yaml.Node, error)
+	// Encode writes the policy `p`.
+	Encode(w io.Writer, p *Policy, opts SaveOptions) error
+}
+
+var (
+	// ErrUnknownFileType occurred if no codec is registered for a file type
+	ErrUnknownFileType = errors.New("unknown file type")
+	codecs             = make(map[FileType]Codec)
+	fileExtensions     = make(map[string]FileType)
+)
+
+func init() {
+	RegisterCodec(YAML, yamlCodec{}, ".yaml", ".yml")
+	RegisterCodec(JSON, jsonCodec{}, ".json")
+	RegisterCodec(TOML, tomlCodec{}, ".toml")
+	RegisterCodec(HCL, hclCodec{}, ".hcl")
+}
+
+// RegisterCodec registers the codec for the file type `t`.
+// Files with one of the `extensions` are detected as `t`
+// and are loaded from policy directories.
+func RegisterCodec(t FileType, c Codec, extensions ...string) {
+	codecs[t] = c
+	for _, ext := range extensions {
+		fileExtensions[strings.ToLower(ext)] = t
+	}
+}
+
+// codecFor returns the codec of the file type `t`.
+func codecFor(t FileType) (Codec, error) {
+	c, ok := codecs[t]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFileType, t)
+	}
+	return c, nil
+}
+
+// detectFileType returns the file type registered for the extension
+// of `filename`, or `fallback` if there is none.
+func detectFileType(filename string, fallback FileType) FileType {
+	if t, ok := fileExtensions[strings.ToLower(filepath.Ext(filename))]; ok {
+		return t
+	}
+	return fallback
+}
+
+// nodeOf converts a decoded value into a YAML node tree.
+func nodeOf(v interface{}) (*yaml.Node, error) {
+	var n yaml.Node
+	if err := n.Encode(v); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+type yamlCodec struct{}
+
+func (yamlCodec) Decode(data []byte) ([]*yaml.Node, error) {
+	var result []*yaml.Node
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(doc.Content) > 0 {
+			result = append(result, doc.Content[0])
+		}
+	}
+}
+
+func (yamlCodec) Encode(w io.Writer, p *Policy, opts SaveOptions) error {
+	doc, err := nodeOf(p.file())
+	if err != nil {
+		return err
+	}
+	doc.HeadComment = opts.Comment
+	if opts.SourceComments {
+		for i := 0; i < len(doc.Content); i += 2 {
+			if doc.Content[i].Value != "roles" {
+				continue
+			}
+			roles := doc.Content[i+1]
+			for j := 0; j < len(roles.Content); j += 2 {
+				if source := p.Sources[roles.Content[j].Value]; source != "" {
+					roles.Content[j].HeadComment = "source: " + source
+				}
+			}
+		}
+	}
+	enc := yaml.NewEncoder(w)
+	if opts.Indent > 0 {
+		enc.SetIndent(opts.Indent)
+	}
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// jsonCodec parses JSON as YAML, which is a superset of JSON,
+// to report errors with positions.
+type jsonCodec struct{}
+
+func (jsonCodec) Decode(data []byte) ([]*yaml.Node, error) {
+	return yamlCodec{}.Decode(data)
+}
+
+func (jsonCodec) Encode(w io.Writer, p *Policy, opts SaveOptions) error {
+	enc := json.NewEncoder(w)
+	if opts.Indent > 0 {
+		enc.SetIndent("", strings.Repeat(" ", opts.Indent))
+	}
+	return enc.Encode(p.file())
+}
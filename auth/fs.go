@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+var (
+	// ErrIncludeNotSupported occurred if a policy read from a stream includes files
+	ErrIncludeNotSupported = errors.New("includes are not supported when loading from a reader")
+)
+
+// policyFS gives the loader access to policy files.
+type policyFS interface {
+	ReadFile(name string) ([]byte, error)
+	Glob(pattern string) ([]string, error)
+	// ReadDir returns the names of the files in the directory `name`,
+	// or ok = false if `name` is not a directory.
+	ReadDir(name string) (names []string, ok bool, err error)
+	// Resolve returns the path of `include` relative to the file `from`.
+	Resolve(from, include string) (string, error)
+	// Key identifies a file for include cycle detection.
+	Key(name string) string
+}
+
+// osFS reads policy files from disk.
+type osFS struct{}
+
+func (osFS) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(name)
+}
+
+func (osFS) Glob(pattern string) ([]string, error) {
+	return filepath.Glob(pattern)
+}
+
+func (osFS) ReadDir(name string) ([]string, bool, error) {
+	info, err := os.Stat(name)
+	if err != nil || !info.IsDir() {
+		return nil, false, nil
+	}
+	entries, err := ioutil.ReadDir(name)
+	if err != nil {
+		return nil, true, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, filepath.Join(name, entry.Name()))
+		}
+	}
+	return names, true, nil
+}
+
+func (osFS) Resolve(from, include string) (string, error) {
+	if filepath.IsAbs(include) {
+		return include, nil
+	}
+	return filepath.Join(filepath.Dir(from), include), nil
+}
+
+func (osFS) Key(name string) string {
+	if abs, err := filepath.Abs(name); err == nil {
+		return abs
+	}
+	return name
+}
+
+// ioFS reads policy files from an fs.FS, for example an embed.FS.
+// Includes are resolved within the file system.
+type ioFS struct {
+	fsys fs.FS
+}
+
+func (f ioFS) ReadFile(name string) ([]byte, error) {
+	return fs.ReadFile(f.fsys, name)
+}
+
+func (f ioFS) Glob(pattern string) ([]string, error) {
+	return fs.Glob(f.fsys, pattern)
+}
+
+func (f ioFS) ReadDir(name string) ([]string, bool, error) {
+	info, err := fs.Stat(f.fsys, name)
+	if err != nil || !info.IsDir() {
+		return nil, false, nil
+	}
+	entries, err := fs.ReadDir(f.fsys, name)
+	if err != nil {
+		return nil, true, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, path.Join(name, entry.Name()))
+		}
+	}
+	return names, true, nil
+}
+
+func (f ioFS) Resolve(from, include string) (string, error) {
+	name := path.Join(path.Dir(from), include)
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "include", Path: include, Err: fs.ErrInvalid}
+	}
+	return name, nil
+}
+
+func (f ioFS) Key(name string) string {
+	return name
+}
+
+// readerFS is used for policies read from a stream, which cannot include files.
+type readerFS struct{}
+
+func (readerFS) ReadFile(name string) ([]byte, error) {
+	return nil, ErrIncludeNotSupported
+}
+
+func (readerFS) Glob(pattern string) ([]string, error) {
+	return nil, ErrIncludeNotSupported
+}
+
+func (readerFS) ReadDir(name string) ([]string, bool, error) {
+	return nil, false, ErrIncludeNotSupported
+}
+
+func (readerFS) Resolve(from, include string) (string, error) {
+	return "", ErrIncludeNotSupported
+}
+
+func (readerFS) Key(name string) string {
+	return name
+}
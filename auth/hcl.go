@@ -0,0 +1,235 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/hashicorp/hcl"
+	rbac2 "github.com/z26100/rbac-go"
+	"gopkg.in/yaml.v3"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// hclCodec reads and writes policies as HCL objects:
+//
+//	roles = {
+//	  "admin" = [".*"]
+//	}
+//	inher = {
+//	  "admin" = []
+//	}
+type hclCodec struct{}
+
+func (hclCodec) Decode(data []byte) ([]*yaml.Node, error) {
+	var v map[string]interface{}
+	if err := hcl.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	n, err := nodeOf(flattenHCL(v))
+	if err != nil {
+		return nil, err
+	}
+	return []*yaml.Node{n}, nil
+}
+
+// flattenHCL merges the lists of objects the HCL decoder returns
+// for object values into single objects.
+func flattenHCL(v interface{}) interface{} {
+	switch t := v.(type) {
+	case []map[string]interface{}:
+		result := make(map[string]interface{})
+		for _, m := range t {
+			for k, item := range m {
+				result[k] = flattenHCL(item)
+			}
+		}
+		return result
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(t))
+		for k, item := range t {
+			result[k] = flattenHCL(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(t))
+		for i, item := range t {
+			result[i] = flattenHCL(item)
+		}
+		return result
+	}
+	return v
+}
+
+func (hclCodec) Encode(w io.Writer, p *Policy, opts SaveOptions) error {
+	indent := strings.Repeat(" ", opts.Indent)
+	if opts.Indent == 0 {
+		indent = "  "
+	}
+	bw := bufio.NewWriter(w)
+	writeComment(bw, opts.Comment)
+	blocks := []struct {
+		key      string
+		values   map[string][]string
+		grants   map[string]map[string]*Grant
+		validity map[string]map[string]*rbac2.Validity
+	}{
+		{"roles", p.Roles, p.Grants, nil},
+		{"deny", p.Deny, p.DenyGrants, nil},
+		{"inher", p.Inher, nil, p.InherValidity},
+	}
+	for i, block := range blocks {
+		if block.key == "deny" && len(block.values) == 0 {
+			continue
+		}
+		if i > 0 {
+			fmt.Fprintln(bw)
+		}
+		fmt.Fprintf(bw, "%s = {\n", block.key)
+		for _, name := range sortedNames(block.values) {
+			if source := p.Sources[name]; opts.SourceComments && block.key == "roles" && source != "" {
+				fmt.Fprintf(bw, "%s# source: %s\n", indent, source)
+			}
+			quoted := make([]string, len(block.values[name]))
+			for j, value := range block.values[name] {
+				if block.key == "inher" {
+					quoted[j] = hclParent(value, block.validity[name][value])
+					continue
+				}
+				quoted[j] = hclGrant(grantOf(block.grants[name], value))
+			}
+			fmt.Fprintf(bw, "%s%s = [%s]\n", indent, strconv.Quote(name), strings.Join(quoted, ", "))
+		}
+		fmt.Fprintln(bw, "}")
+	}
+	if len(p.Constraints) > 0 {
+		fmt.Fprintln(bw)
+		fmt.Fprintln(bw, "constraints = {")
+		names := make([]string, 0, len(p.Constraints))
+		for name := range p.Constraints {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			c := constraintFileOf(p.Constraints[name])
+			var fields []string
+			if c.Roles != nil {
+				fields = append(fields, "roles = "+hclStrings(c.Roles))
+			}
+			if c.Role != "" {
+				fields = append(fields, "role = "+strconv.Quote(c.Role))
+			}
+			if c.Requires != nil {
+				fields = append(fields, "requires = "+hclStrings(c.Requires))
+			}
+			if c.Max != 0 {
+				fields = append(fields, fmt.Sprintf("max = %d", c.Max))
+			}
+			if c.Dynamic {
+				fields = append(fields, "dynamic = true")
+			}
+			fmt.Fprintf(bw, "%s%s = {%s}\n", indent, strconv.Quote(name), strings.Join(fields, ", "))
+		}
+		fmt.Fprintln(bw, "}")
+	}
+	return bw.Flush()
+}
+
+// hclStrings returns a list of strings.
+func hclStrings(values []string) string {
+	items := make([]interface{}, len(values))
+	for i, s := range values {
+		items[i] = s
+	}
+	return hclValue(items)
+}
+
+// hclGrant returns a permission, or a grant object if the permission
+// requires conditions or is limited in time.
+func hclGrant(g *Grant) string {
+	if !g.restricted() {
+		return strconv.Quote(g.Permission)
+	}
+	fields := []string{"permission = " + strconv.Quote(g.Permission)}
+	if len(g.Conditions) > 0 {
+		values := make([]interface{}, len(g.Conditions))
+		for i, c := range g.Conditions {
+			values[i] = c
+		}
+		fields = append(fields, "conditions = "+hclValue(values))
+	}
+	if len(g.When) > 0 {
+		objects := make([]string, len(g.When))
+		for i, c := range g.When {
+			object := []string{"attribute = " + strconv.Quote(c.Attribute), "operator = " + strconv.Quote(c.Operator)}
+			if c.Value != nil {
+				object = append(object, "value = "+hclValue(c.Value))
+			}
+			if c.Ref != "" {
+				object = append(object, "ref = "+strconv.Quote(c.Ref))
+			}
+			objects[i] = "{" + strings.Join(object, ", ") + "}"
+		}
+		fields = append(fields, "when = ["+strings.Join(objects, ", ")+"]")
+	}
+	if g.Expr != "" {
+		fields = append(fields, "expr = "+strconv.Quote(g.Expr))
+	}
+	fields = append(fields, hclValidity(g.Validity)...)
+	return "{" + strings.Join(fields, ", ") + "}"
+}
+
+// hclParent returns a role name, or a parent object if the inheritance
+// is limited in time.
+func hclParent(name string, validity *rbac2.Validity) string {
+	if validity == nil {
+		return strconv.Quote(name)
+	}
+	fields := append([]string{"role = " + strconv.Quote(name)}, hclValidity(validity)...)
+	return "{" + strings.Join(fields, ", ") + "}"
+}
+
+// hclValidity returns the fields of a validity.
+func hclValidity(validity *rbac2.Validity) []string {
+	if validity == nil {
+		return nil
+	}
+	var fields []string
+	if t := formatTime(validity.NotBefore); t != "" {
+		fields = append(fields, "notBefore = "+strconv.Quote(t))
+	}
+	if t := formatTime(validity.NotAfter); t != "" {
+		fields = append(fields, "notAfter = "+strconv.Quote(t))
+	}
+	if validity.Schedule != "" {
+		fields = append(fields, "schedule = "+strconv.Quote(validity.Schedule))
+	}
+	return fields
+}
+
+// hclValue returns the HCL literal of a decoded value.
+func hclValue(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return strconv.Quote(t)
+	case []interface{}:
+		values := make([]string, len(t))
+		for i, item := range t {
+			values[i] = hclValue(item)
+		}
+		return "[" + strings.Join(values, ", ") + "]"
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fields := make([]string, len(keys))
+		for i, k := range keys {
+			fields[i] = strconv.Quote(k) + " = " + hclValue(t[k])
+		}
+		return "{" + strings.Join(fields, ", ") + "}"
+	}
+	return fmt.Sprint(v)
+}
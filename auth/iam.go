@@ -0,0 +1,271 @@
+package auth
+
+import (
+	"gopkg.in/yaml.v3"
+	"regexp"
+	"strings"
+)
+
+// IAMVersion is the policy language version IAM-style documents must declare.
+const IAMVersion = "2012-10-17"
+
+// IAM-style policy documents describe roles by statements:
+//
+//	{
+//	  "Version": "2012-10-17",
+//	  "Roles": {
+//	    "editor": {
+//	      "Parents": ["viewer"],
+//	      "Statement": [{
+//	        "Effect": "Allow",
+//	        "Action": ["articles:Write", "articles:Publish"],
+//	        "Resource": "articles/*",
+//	        "Condition": {"Bool": {"mfa": "true"}}
+//	      }]
+//	    }
+//	  }
+//	}
+//
+// A document is detected as IAM-style by its `Version` key. Every action
+// and resource of an `Allow` statement is granted by the permission
+// IAMPermission returns, the ones of a `Deny` statement are denied.
+// `Bool` conditions name registered conditions, see rbac.RegisterCondition;
+// the value "false" negates the condition. IAM-style documents are
+// converted into plain policy documents before they are validated.
+
+// IAMPermission returns the permission granting `action` on `resource`,
+// which are matched like IAM does: actions are case-insensitive, and `*`
+// and `?` are wildcards. Permissions are requested as `action:resource`,
+// e.g. `articles:Write:articles/42`.
+func IAMPermission(action, resource string) string {
+	return "^(?i:" + glob(action, "[^:]*", "[^:]") + "):" + glob(resource, ".*", ".") + "$"
+}
+
+// glob quotes `pattern`, translating `*` and `?` into `many` and `one`.
+func glob(pattern, many, one string) string {
+	var b strings.Builder
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(many)
+		case '?':
+			b.WriteString(one)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return b.String()
+}
+
+// isIAM reports whether `root` is an IAM-style document.
+func isIAM(root *yaml.Node) bool {
+	if root.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i < len(root.Content); i += 2 {
+		if root.Content[i].Value == "Version" {
+			return true
+		}
+	}
+	return false
+}
+
+// iamRole collects the grants of a role of an IAM-style document.
+type iamRole struct {
+	allow *yaml.Node
+	deny  *yaml.Node
+}
+
+// iam converts the IAM-style document `root` into a plain policy document.
+// The nodes of the plain document carry the positions of the statements
+// they were converted from.
+func (v *validator) iam(root *yaml.Node) *yaml.Node {
+	roles, deny, inher := mappingAt(root), mappingAt(root), mappingAt(root)
+	doc := mappingAt(root)
+	for i := 0; i < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch key.Value {
+		case "Version":
+			if value.Value != IAMVersion {
+				v.errorf(value, "unsupported version %q, expected %q", value.Value, IAMVersion)
+			}
+		case "Include":
+			doc.Content = append(doc.Content, scalarAt("include", key), value)
+		case "Roles":
+			if value.Kind != yaml.MappingNode {
+				v.errorf(value, "Roles must be a mapping of role names to roles")
+				continue
+			}
+			for j := 0; j < len(value.Content); j += 2 {
+				name, role := value.Content[j], value.Content[j+1]
+				r := v.iamRole(role)
+				roles.Content = append(roles.Content, name, r.allow)
+				if len(r.deny.Content) > 0 {
+					deny.Content = append(deny.Content, name, r.deny)
+				}
+				for k := 0; role.Kind == yaml.MappingNode && k < len(role.Content); k += 2 {
+					if role.Content[k].Value == "Parents" {
+						inher.Content = append(inher.Content, name, role.Content[k+1])
+					}
+				}
+			}
+		default:
+			v.errorf(key, "unknown key %q", key.Value)
+		}
+	}
+	doc.Content = append(doc.Content,
+		scalarAt("roles", root), roles,
+		scalarAt("deny", root), deny,
+		scalarAt("inher", root), inher)
+	return doc
+}
+
+func (v *validator) iamRole(n *yaml.Node) iamRole {
+	r := iamRole{allow: sequenceAt(n), deny: sequenceAt(n)}
+	if n.Kind != yaml.MappingNode {
+		v.errorf(n, "role must be a mapping with the keys Parents and Statement")
+		return r
+	}
+	for i := 0; i < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		switch key.Value {
+		case "Parents":
+		case "Statement":
+			statements := []*yaml.Node{value}
+			if value.Kind == yaml.SequenceNode {
+				statements = value.Content
+			}
+			for _, s := range statements {
+				v.statement(s, &r)
+			}
+		default:
+			v.errorf(key, "unknown key %q", key.Value)
+		}
+	}
+	return r
+}
+
+func (v *validator) statement(n *yaml.Node, r *iamRole) {
+	if n.Kind != yaml.MappingNode {
+		v.errorf(n, "statement must be a mapping")
+		return
+	}
+	var effect *yaml.Node
+	var actions, resources, conditions []*yaml.Node
+	for i := 0; i < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		switch key.Value {
+		case "Sid":
+		case "Effect":
+			effect = value
+		case "Action":
+			actions = v.oneOrMore(value, "Action")
+		case "Resource":
+			resources = v.oneOrMore(value, "Resource")
+		case "Condition":
+			conditions = v.iamConditions(value)
+		case "NotAction", "NotResource", "Principal", "NotPrincipal":
+			v.errorf(key, "%s is not supported", key.Value)
+		default:
+			v.errorf(key, "unknown key %q", key.Value)
+		}
+	}
+	var grants *yaml.Node
+	switch {
+	case effect == nil:
+		v.errorf(n, "statement without Effect")
+		return
+	case effect.Value == "Allow":
+		grants = r.allow
+	case effect.Value == "Deny":
+		grants = r.deny
+	default:
+		v.errorf(effect, "Effect must be Allow or Deny")
+		return
+	}
+	if len(actions) == 0 {
+		v.errorf(n, "statement without Action")
+		return
+	}
+	if resources == nil {
+		resources = []*yaml.Node{scalarAt("*", n)}
+	}
+	for _, action := range actions {
+		for _, resource := range resources {
+			permission := scalarAt(IAMPermission(action.Value, resource.Value), action)
+			if len(conditions) == 0 {
+				grants.Content = append(grants.Content, permission)
+				continue
+			}
+			grant := mappingAt(action)
+			grant.Content = append(grant.Content,
+				scalarAt("permission", action), permission,
+				scalarAt("conditions", n), &yaml.Node{Kind: yaml.SequenceNode, Content: conditions})
+			grants.Content = append(grants.Content, grant)
+		}
+	}
+}
+
+// iamConditions returns the names of the conditions of a `Condition` block.
+func (v *validator) iamConditions(n *yaml.Node) []*yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		v.errorf(n, "Condition must be a mapping of operators to conditions")
+		return nil
+	}
+	var result []*yaml.Node
+	for i := 0; i < len(n.Content); i += 2 {
+		operator, block := n.Content[i], n.Content[i+1]
+		if operator.Value != "Bool" {
+			v.errorf(operator, "condition operator %q is not supported, only Bool", operator.Value)
+			continue
+		}
+		if block.Kind != yaml.MappingNode {
+			v.errorf(block, "Bool must be a mapping of condition names to true or false")
+			continue
+		}
+		for j := 0; j < len(block.Content); j += 2 {
+			key, values := block.Content[j], block.Content[j+1]
+			value := v.oneOrMore(values, "condition "+key.Value)
+			if len(value) != 1 || (value[0].Value != "true" && value[0].Value != "false") {
+				v.errorf(values, "condition %q must be true or false", key.Value)
+				continue
+			}
+			name := key.Value
+			if value[0].Value == "false" {
+				name = "!" + name
+			}
+			result = append(result, scalarAt(name, key))
+		}
+	}
+	return result
+}
+
+// oneOrMore returns the scalars of a string or a list of strings.
+func (v *validator) oneOrMore(n *yaml.Node, what string) []*yaml.Node {
+	if n.Kind == yaml.ScalarNode && !isNull(n) {
+		return []*yaml.Node{n}
+	}
+	if n.Kind == yaml.SequenceNode {
+		for _, item := range n.Content {
+			if item.Kind != yaml.ScalarNode || isNull(item) {
+				v.errorf(item, "%s must be a string or a list of strings", what)
+				return nil
+			}
+		}
+		return n.Content
+	}
+	v.errorf(n, "%s must be a string or a list of strings", what)
+	return nil
+}
+
+func scalarAt(value string, at *yaml.Node) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value, Line: at.Line, Column: at.Column}
+}
+
+func mappingAt(at *yaml.Node) *yaml.Node {
+	return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: at.Line, Column: at.Column}
+}
+
+func sequenceAt(at *yaml.Node) *yaml.Node {
+	return &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Line: at.Line, Column: at.Column}
+}
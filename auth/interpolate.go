@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"strings"
+)
+
+var (
+	variables map[string]string
+	envLookup bool
+)
+
+// SetVariables sets the variables substituted in policy files.
+// A reference `${NAME}` is replaced by the value of NAME, `${NAME:-default}`
+// falls back to `default` if NAME is not set and `$$` is a literal `$`.
+// Loading fails for references to variables which are not set.
+func SetVariables(vars map[string]string) {
+	variables = vars
+}
+
+// SetEnvLookup enables environment variables as a fallback for
+// variables which are not set by SetVariables.
+func SetEnvLookup(enabled bool) {
+	envLookup = enabled
+}
+
+func lookupVariable(name string) (string, bool) {
+	if value, ok := variables[name]; ok {
+		return value, true
+	}
+	if envLookup {
+		return os.LookupEnv(name)
+	}
+	return "", false
+}
+
+// interpolate substitutes the variables of all string scalars below `n`,
+// including mapping keys.
+func (v *validator) interpolate(n *yaml.Node) {
+	switch n.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode, yaml.MappingNode:
+		for _, c := range n.Content {
+			v.interpolate(c)
+		}
+	case yaml.ScalarNode:
+		if n.Tag != "!!str" || !strings.Contains(n.Value, "$") {
+			return
+		}
+		value, err := expand(n.Value, lookupVariable)
+		if err != nil {
+			v.errorf(n, "%v", err)
+			return
+		}
+		n.Value = value
+	}
+}
+
+// expand substitutes the variable references of `s`.
+func expand(s string, lookup func(string) (string, bool)) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			sb.WriteByte(s[i])
+			continue
+		}
+		switch s[i+1] {
+		case '$':
+			sb.WriteByte('$')
+			i++
+			continue
+		case '{':
+		default:
+			sb.WriteByte(s[i])
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable reference in %q", s)
+		}
+		ref := s[i+2 : i+end]
+		name, def, hasDefault := ref, "", false
+		if j := strings.Index(ref, ":-"); j >= 0 {
+			name, def, hasDefault = ref[:j], ref[j+2:], true
+		}
+		if !validVariableName(name) {
+			return "", fmt.Errorf("invalid variable name %q in %q", name, s)
+		}
+		value, ok := lookup(name)
+		if !ok {
+			if !hasDefault {
+				return "", fmt.Errorf("variable %q is not set", name)
+			}
+			value = def
+		}
+		sb.WriteString(value)
+		i += end
+	}
+	return sb.String(), nil
+}
+
+func validVariableName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		case i > 0 && (c >= '0' && c <= '9' || c == '.' || c == '-'):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,419 @@
+package auth
+
+import (
+	"errors"
+	rbac2 "github.com/z26100/rbac-go"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// MergeStrategy decides how a role defined by several policy documents is merged.
+type MergeStrategy string
+
+const (
+	// MergeError reports a role defined twice as an error.
+	MergeError MergeStrategy = "error"
+	// MergeOverride lets the document loaded last win.
+	MergeOverride MergeStrategy = "override"
+	// MergeUnion merges the permissions and the parents of all definitions.
+	MergeUnion MergeStrategy = "union"
+)
+
+var (
+	mergeStrategy = MergeError
+)
+
+func SetMergeStrategy(s MergeStrategy) {
+	mergeStrategy = s
+}
+
+// loader reads policy documents and merges them in a deterministic order:
+// files of a directory or glob are sorted by name, and the includes
+// of a document are loaded before the document itself.
+type loader struct {
+	fs       policyFS
+	fileType FileType
+	v        *validator
+	strategy MergeStrategy
+	policy   *Policy
+	names    map[string]string
+	roles    map[string]located
+	inher    map[string]located
+	parents  map[string][]located
+	loading  map[string]struct{}
+	loaded   map[string]struct{}
+	// constraints and constraintRoles locate the constraints
+	// and their roles by constraint name
+	constraints     map[string]located
+	constraintRoles map[string][]located
+	// files lists every file read, in load order
+	files []string
+}
+
+func newLoader(fs policyFS) *loader {
+	return &loader{
+		fs:       fs,
+		fileType: fileType,
+		v:        &validator{},
+		strategy: mergeStrategy,
+		policy:   newPolicy(),
+		names:    make(map[string]string),
+		roles:    make(map[string]located),
+		inher:    make(map[string]located),
+		parents:  make(map[string][]located),
+		loading:  make(map[string]struct{}),
+		loaded:   make(map[string]struct{}),
+
+		constraints:     make(map[string]located),
+		constraintRoles: make(map[string][]located),
+	}
+}
+
+// loadPath loads a file, all policy files of a directory or all files
+// matching a glob pattern. `from` is the include directive, if any.
+func (l *loader) loadPath(path string, from located) {
+	var files []string
+	if strings.ContainsAny(path, "*?[") {
+		matches, err := l.fs.Glob(path)
+		if err != nil {
+			l.v.errorAt(from, "invalid pattern %q: %v", path, err)
+			return
+		}
+		files = matches
+	} else if names, ok, err := l.fs.ReadDir(path); err != nil {
+		l.v.errorAt(from, "%v", err)
+		return
+	} else if ok {
+		for _, name := range names {
+			if isPolicyFile(name) {
+				files = append(files, name)
+			}
+		}
+	} else {
+		files = []string{path}
+	}
+	if len(files) == 0 {
+		l.v.errorAt(from, "no policy files found at %q", path)
+		return
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		l.loadFile(file, from)
+	}
+}
+
+func (l *loader) loadFile(filename string, from located) {
+	key := l.fs.Key(filename)
+	if _, ok := l.loading[key]; ok {
+		l.v.errorAt(from, "include cycle: %q includes itself", filename)
+		return
+	}
+	if _, ok := l.loaded[key]; ok {
+		return
+	}
+	l.files = append(l.files, filename)
+	data, err := l.fs.ReadFile(filename)
+	if err != nil {
+		l.v.errorAt(from, "%v", err)
+		return
+	}
+	l.loading[key] = struct{}{}
+	l.loadData(filename, data)
+	delete(l.loading, key)
+	l.loaded[key] = struct{}{}
+}
+
+// loadData loads every document of `data`. The format is detected
+// by the extension of `filename`, falling back to the file type of
+// the loader and finally to YAML.
+func (l *loader) loadData(filename string, data []byte) {
+	l.v.file = filename
+	fallback := l.fileType
+	if fallback == AUTO {
+		fallback = YAML
+	}
+	c, err := codecFor(detectFileType(filename, fallback))
+	if err != nil {
+		l.v.errorf(nil, "%v", err)
+		return
+	}
+	docs, err := c.Decode(data)
+	if err != nil {
+		l.v.errorf(nil, "%v", err)
+		return
+	}
+	for _, doc := range docs {
+		l.v.file = filename
+		l.v.interpolate(doc)
+		if isIAM(doc) {
+			doc = l.v.iam(doc)
+		}
+		d := l.v.document(doc)
+		for _, include := range d.includes {
+			at := located{file: filename, node: include}
+			path, err := l.fs.Resolve(filename, include.Value)
+			if err != nil {
+				l.v.errorAt(at, "%v", err)
+				continue
+			}
+			l.loadPath(path, at)
+		}
+		l.merge(d)
+	}
+}
+
+func (l *loader) merge(d *document) {
+	l.v.file = d.file
+	for _, name := range sortedNames(d.policy.Roles) {
+		at := located{file: d.file, node: d.roleKeys[name]}
+		id := strings.ToLower(name)
+		permissions := d.policy.Roles[name]
+		if prev, ok := l.roles[id]; ok {
+			existing := l.names[id]
+			switch l.strategy {
+			case MergeOverride:
+				l.policy.Roles[existing] = permissions
+				l.policy.Sources[existing] = d.file
+				l.roles[id] = at
+				l.setGrants(existing, d, name)
+			case MergeUnion:
+				l.policy.Roles[existing] = union(l.policy.Roles[existing], permissions)
+				l.unionGrants(existing, d, name)
+			default:
+				l.v.errorAt(at, "duplicate role %q, already defined at %s", name, prev)
+			}
+			continue
+		}
+		l.names[id] = name
+		l.roles[id] = at
+		l.policy.Roles[name] = permissions
+		l.policy.Sources[name] = d.file
+		l.setGrants(name, d, name)
+	}
+	for _, name := range sortedNames(d.policy.Inher) {
+		at := located{file: d.file, node: d.inherKeys[name]}
+		id := strings.ToLower(name)
+		var parents []located
+		for _, n := range d.parentNodes[name] {
+			parents = append(parents, located{file: d.file, node: n})
+		}
+		if prev, ok := l.inher[id]; ok {
+			existing := l.inherName(id)
+			switch l.strategy {
+			case MergeOverride:
+				l.policy.Inher[existing] = d.policy.Inher[name]
+				l.parents[id] = parents
+				l.inher[id] = at
+				setValidities(l.policy.InherValidity, existing, d.policy.InherValidity[name])
+			case MergeUnion:
+				l.policy.Inher[existing] = union(l.policy.Inher[existing], d.policy.Inher[name])
+				l.parents[id] = append(l.parents[id], parents...)
+				unionValidities(l.policy.InherValidity, existing, d.policy.InherValidity[name])
+			default:
+				l.v.errorAt(at, "duplicate inheritance for role %q, already defined at %s", name, prev)
+			}
+			continue
+		}
+		l.inher[id] = at
+		l.parents[id] = parents
+		l.policy.Inher[name] = d.policy.Inher[name]
+		setValidities(l.policy.InherValidity, name, d.policy.InherValidity[name])
+	}
+	names := make([]string, 0, len(d.policy.Constraints))
+	for name := range d.policy.Constraints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		at := located{file: d.file, node: d.constraintKeys[name]}
+		c := d.policy.Constraints[name]
+		var roles []located
+		for _, n := range d.constraintRoles[name] {
+			roles = append(roles, located{file: d.file, node: n})
+		}
+		if prev, ok := l.constraints[name]; ok {
+			switch l.strategy {
+			case MergeOverride:
+				l.constraintRoles[name] = roles
+			case MergeUnion:
+				// only separation of duty constraints of both documents
+				// can be combined, for others the later one wins
+				prevSoD, ok1 := l.policy.Constraints[name].(rbac2.SoDConstraint)
+				sod, ok2 := c.(rbac2.SoDConstraint)
+				if !ok1 || !ok2 {
+					l.constraintRoles[name] = roles
+					break
+				}
+				sod.Roles = union(prevSoD.Roles, sod.Roles)
+				c = sod
+				l.constraintRoles[name] = append(l.constraintRoles[name], roles...)
+			default:
+				l.v.errorAt(at, "duplicate constraint %q, already defined at %s", name, prev)
+				continue
+			}
+		} else {
+			l.constraintRoles[name] = roles
+		}
+		l.constraints[name] = at
+		l.policy.Constraints[name] = c
+	}
+}
+
+// setGrants replaces the denied permissions and the grants of the
+// role `existing` by the ones of role `name` of the document `d`.
+func (l *loader) setGrants(existing string, d *document, name string) {
+	delete(l.policy.Deny, existing)
+	deny := d.denyName(name)
+	if denied, ok := d.policy.Deny[deny]; ok {
+		l.policy.Deny[existing] = denied
+	}
+	for _, m := range []struct {
+		to   map[string]map[string]*Grant
+		from map[string]*Grant
+	}{
+		{l.policy.Grants, d.policy.Grants[name]},
+		{l.policy.DenyGrants, d.policy.DenyGrants[deny]},
+	} {
+		delete(m.to, existing)
+		if len(m.from) > 0 {
+			m.to[existing] = m.from
+		}
+	}
+}
+
+// unionGrants merges the denied permissions and the grants of role
+// `name` of the document `d` into the role `existing`. Grants of the
+// document replace the ones of the same permission.
+func (l *loader) unionGrants(existing string, d *document, name string) {
+	deny := d.denyName(name)
+	if denied, ok := d.policy.Deny[deny]; ok {
+		l.policy.Deny[existing] = union(l.policy.Deny[existing], denied)
+	}
+	for _, m := range []struct {
+		to   map[string]map[string]*Grant
+		from map[string]*Grant
+	}{
+		{l.policy.Grants, d.policy.Grants[name]},
+		{l.policy.DenyGrants, d.policy.DenyGrants[deny]},
+	} {
+		for pid, g := range m.from {
+			if m.to[existing] == nil {
+				m.to[existing] = make(map[string]*Grant)
+			}
+			m.to[existing][pid] = g
+		}
+	}
+}
+
+// setValidities replaces the validities of the parents of role
+// `existing` in `to`.
+func setValidities(to map[string]map[string]*rbac2.Validity, existing string,
+	from map[string]*rbac2.Validity) {
+	delete(to, existing)
+	if len(from) > 0 {
+		to[existing] = from
+	}
+}
+
+// unionValidities merges `from` into the validities of the parents of
+// role `existing` in `to`, replacing the ones of the same parent.
+func unionValidities(to map[string]map[string]*rbac2.Validity, existing string,
+	from map[string]*rbac2.Validity) {
+	for key, validity := range from {
+		if to[existing] == nil {
+			to[existing] = make(map[string]*rbac2.Validity)
+		}
+		to[existing][key] = validity
+	}
+}
+
+// inherName returns the name the inheritance of role `id` is stored by.
+func (l *loader) inherName(id string) string {
+	for name := range l.policy.Inher {
+		if strings.ToLower(name) == id {
+			return name
+		}
+	}
+	return id
+}
+
+// finish checks the references between the merged roles
+// and returns the merged policy.
+func (l *loader) finish() (*Policy, error) {
+	for _, name := range sortedNames(l.policy.Inher) {
+		id := strings.ToLower(name)
+		if _, ok := l.roles[id]; !ok {
+			l.v.errorAt(l.inher[id], "unknown role %q", name)
+		}
+		for _, parent := range l.parents[id] {
+			if _, ok := l.roles[strings.ToLower(parent.node.Value)]; !ok {
+				l.v.errorAt(parent, "unknown parent role %q", parent.node.Value)
+			}
+		}
+	}
+	names := make([]string, 0, len(l.constraintRoles))
+	for name := range l.constraintRoles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, role := range l.constraintRoles[name] {
+			if _, ok := l.roles[strings.ToLower(role.node.Value)]; !ok {
+				l.v.errorAt(role, "unknown role %q in constraint %q", role.node.Value, name)
+			}
+		}
+	}
+	cycles := policyCycles(l.policy)
+	for _, path := range cycles {
+		l.v.errorAt(l.inher[path[0]], "%v: %s", rbac2.ErrFoundCircle, strings.Join(path, " -> "))
+	}
+	if len(cycles) == 0 && len(l.v.errors) == 0 {
+		for _, err := range policyViolations(l.policy) {
+			var role string
+			var sod *rbac2.ConstraintError
+			var prereq *rbac2.PrerequisiteError
+			var card *rbac2.CardinalityError
+			switch {
+			case errors.As(err, &sod):
+				role = sod.Role
+			case errors.As(err, &prereq):
+				role = prereq.Subject
+			case errors.As(err, &card):
+				l.v.errorAt(l.constraints[card.Constraint], "%v", err)
+				continue
+			}
+			at, ok := l.inher[role]
+			if !ok {
+				at = l.roles[role]
+			}
+			l.v.errorAt(at, "%v", err)
+		}
+	}
+	if err := l.v.err(); err != nil {
+		return nil, err
+	}
+	return l.policy, nil
+}
+
+// isPolicyFile reports whether a codec is registered for the extension of `filename`.
+func isPolicyFile(filename string) bool {
+	_, ok := fileExtensions[strings.ToLower(filepath.Ext(filename))]
+	return ok
+}
+
+// union appends the values of `b` missing in `a`.
+func union(a, b []string) []string {
+	seen := make(map[string]struct{}, len(a))
+	result := append([]string{}, a...)
+	for _, s := range a {
+		seen[s] = struct{}{}
+	}
+	for _, s := range b {
+		if _, ok := seen[s]; !ok {
+			seen[s] = struct{}{}
+			result = append(result, s)
+		}
+	}
+	return result
+}
@@ -0,0 +1,888 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	rbac2 "github.com/z26100/rbac-go"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Policy is the document stored in policy files.
+// Roles maps role names to their permissions,
+// Deny maps role names to the permissions denied to them,
+// Inher maps role names to the names of their parents.
+// Grants and DenyGrants map role names and permissions to the grants
+// of the permissions requiring conditions or limited in time.
+// InherValidity maps role names and parents to the validity
+// of the inheritance.
+// Constraints maps constraint names to the constraints.
+// Sources maps role names to the file the role was loaded from.
+type Policy struct {
+	Roles         map[string][]string                   `json:"roles" yaml:"roles" toml:"roles"`
+	Deny          map[string][]string                   `json:"deny,omitempty" yaml:"deny,omitempty" toml:"deny,omitempty"`
+	Inher         map[string][]string                   `json:"inher" yaml:"inher" toml:"inher"`
+	Grants        map[string]map[string]*Grant          `json:"-" yaml:"-" toml:"-"`
+	DenyGrants    map[string]map[string]*Grant          `json:"-" yaml:"-" toml:"-"`
+	InherValidity map[string]map[string]*rbac2.Validity `json:"-" yaml:"-" toml:"-"`
+	Constraints   map[string]rbac2.Constraint           `json:"-" yaml:"-" toml:"-"`
+	Sources       map[string]string                     `json:"-" yaml:"-" toml:"-"`
+}
+
+// Grant is a permission granted or denied to a role with the names of
+// the conditions it requires, its attribute conditions, its expression
+// and its validity.
+type Grant struct {
+	Permission string
+	Conditions []string
+	When       []rbac2.AttributeCondition
+	Expr       string
+	Validity   *rbac2.Validity
+}
+
+// newPolicy returns an empty policy.
+func newPolicy() *Policy {
+	return &Policy{
+		Roles:         make(map[string][]string),
+		Deny:          make(map[string][]string),
+		Inher:         make(map[string][]string),
+		Grants:        make(map[string]map[string]*Grant),
+		DenyGrants:    make(map[string]map[string]*Grant),
+		InherValidity: make(map[string]map[string]*rbac2.Validity),
+		Constraints:   make(map[string]rbac2.Constraint),
+		Sources:       make(map[string]string),
+	}
+}
+
+// restricted returns true if the permission requires conditions
+// or is limited in time.
+func (g *Grant) restricted() bool {
+	return len(g.Conditions) > 0 || len(g.When) > 0 || g.Expr != "" || g.Validity != nil
+}
+
+// grantOf returns the grant of the permission `pid` in `grants`,
+// an unrestricted one if there is none.
+func grantOf(grants map[string]*Grant, pid string) *Grant {
+	if g, ok := grants[pid]; ok {
+		return g
+	}
+	return &Grant{Permission: pid}
+}
+
+// grantsOf returns the grants of the permissions `permissions`
+// requiring conditions or limited in time.
+func grantsOf(permissions map[string]*rbac2.RBACPermission) map[string]*Grant {
+	var result map[string]*Grant
+	for pid, perm := range permissions {
+		g := &Grant{Permission: pid, Conditions: perm.Conditions, When: perm.When, Expr: perm.Expr,
+			Validity: perm.Validity}
+		if !g.restricted() {
+			continue
+		}
+		if result == nil {
+			result = make(map[string]*Grant)
+		}
+		result[pid] = g
+	}
+	return result
+}
+
+// permission returns the permission of the grant.
+func (g *Grant) permission() *rbac2.RBACPermission {
+	permission := AddPermission(g.Permission)
+	permission.Conditions = g.Conditions
+	permission.When = g.When
+	permission.Expr = g.Expr
+	permission.Validity = g.Validity
+	return permission
+}
+
+var (
+	// ErrInvalidPolicy occurred if a policy document fails validation
+	ErrInvalidPolicy = errors.New("invalid policy")
+)
+
+// PolicyError describes a single problem of a policy document.
+// Line and Column are 0 if the position is unknown.
+type PolicyError struct {
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *PolicyError) Error() string {
+	var pos []string
+	if e.File != "" {
+		pos = append(pos, e.File)
+	}
+	if e.Line > 0 {
+		pos = append(pos, fmt.Sprintf("%d:%d", e.Line, e.Column))
+	}
+	if len(pos) == 0 {
+		return e.Msg
+	}
+	return strings.Join(pos, ":") + ": " + e.Msg
+}
+
+// ValidationError collects every problem found in a policy document.
+type ValidationError struct {
+	Errors []*PolicyError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return ErrInvalidPolicy.Error() + ":\n" + strings.Join(msgs, "\n")
+}
+
+// Unwrap makes `errors.Is(err, ErrInvalidPolicy)` work.
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidPolicy
+}
+
+// located remembers where a value of a policy document was defined.
+type located struct {
+	file string
+	node *yaml.Node
+}
+
+func (l located) String() string {
+	if l.node == nil {
+		return l.file
+	}
+	return fmt.Sprintf("%s:%d:%d", l.file, l.node.Line, l.node.Column)
+}
+
+// validator walks the YAML node tree of a policy document
+// and collects every problem it finds.
+type validator struct {
+	file   string
+	errors []*PolicyError
+}
+
+func (v *validator) errorf(n *yaml.Node, format string, args ...interface{}) {
+	v.errorAt(located{file: v.file, node: n}, format, args...)
+}
+
+func (v *validator) errorAt(at located, format string, args ...interface{}) {
+	e := &PolicyError{File: at.file, Msg: fmt.Sprintf(format, args...)}
+	if at.node != nil {
+		e.Line, e.Column = at.node.Line, at.node.Column
+	}
+	v.errors = append(v.errors, e)
+}
+
+func (v *validator) err() error {
+	if len(v.errors) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: v.errors}
+}
+
+// ParsePolicy parses and validates the policy stored in `data`.
+// `data` may contain multiple YAML documents. JSON documents are parsed
+// as YAML, which is a superset of JSON. Includes are resolved relative
+// to the directory of `filename`, which is also used for error positions.
+// All problems are reported at once by a ValidationError.
+func ParsePolicy(filename string, data []byte) (*Policy, error) {
+	l := newLoader(osFS{})
+	l.loadData(filename, data)
+	return l.finish()
+}
+
+// ParsePolicyFiles parses and validates the policy stored in `path`.
+// `path` may be a file, a directory or a glob pattern. Directories
+// are not searched recursively.
+func ParsePolicyFiles(path string) (*Policy, error) {
+	p, _, err := parsePolicyFiles(path)
+	return p, err
+}
+
+// parsePolicyFiles is ParsePolicyFiles which also returns every file read.
+func parsePolicyFiles(path string) (*Policy, []string, error) {
+	l := newLoader(osFS{})
+	l.loadPath(path, located{})
+	p, err := l.finish()
+	return p, l.files, err
+}
+
+// ParsePolicyFS parses and validates the policy stored in the files of
+// `fsys` matching `pattern`, which may also name a file or a directory.
+// Includes are resolved within `fsys`.
+func ParsePolicyFS(fsys fs.FS, pattern string) (*Policy, error) {
+	l := newLoader(ioFS{fsys: fsys})
+	l.loadPath(pattern, located{})
+	return l.finish()
+}
+
+// ParsePolicyReader parses and validates the policy read from `r`
+// in the format `t`. Includes are not supported.
+func ParsePolicyReader(r io.Reader, t FileType) (*Policy, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	l := newLoader(readerFS{})
+	if t != AUTO {
+		l.fileType = t
+	}
+	l.loadData("", data)
+	return l.finish()
+}
+
+// ValidateFile reports every problem of the policy stored in `path`.
+func ValidateFile(path string) error {
+	_, err := ParsePolicyFiles(path)
+	return err
+}
+
+// document is a single policy document whose structure is valid.
+// References between roles are checked after all documents are merged.
+type document struct {
+	file        string
+	includes    []*yaml.Node
+	policy      *Policy
+	roleKeys    map[string]*yaml.Node
+	denyKeys    map[string]*yaml.Node
+	inherKeys   map[string]*yaml.Node
+	parentNodes map[string][]*yaml.Node
+	// constraintKeys and constraintRoles hold the nodes the names
+	// and the roles of constraints were read from
+	constraintKeys  map[string]*yaml.Node
+	constraintRoles map[string][]*yaml.Node
+}
+
+func (v *validator) document(root *yaml.Node) *document {
+	d := &document{
+		file:            v.file,
+		policy:          newPolicy(),
+		roleKeys:        make(map[string]*yaml.Node),
+		denyKeys:        make(map[string]*yaml.Node),
+		inherKeys:       make(map[string]*yaml.Node),
+		parentNodes:     make(map[string][]*yaml.Node),
+		constraintKeys:  make(map[string]*yaml.Node),
+		constraintRoles: make(map[string][]*yaml.Node),
+	}
+	if isNull(root) {
+		return d
+	}
+	if root.Kind != yaml.MappingNode {
+		v.errorf(root, "policy document must be a mapping")
+		return d
+	}
+	seen := make(map[string]struct{})
+	for i := 0; i < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if _, ok := seen[key.Value]; ok {
+			v.errorf(key, "duplicate key %q", key.Value)
+			continue
+		}
+		seen[key.Value] = struct{}{}
+		switch key.Value {
+		case "roles":
+			v.roles(value, d)
+		case "deny":
+			v.deny(value, d)
+		case "inher":
+			v.inher(value, d)
+		case "constraints":
+			v.constraints(value, d)
+		case "include":
+			if isNull(value) {
+				continue
+			}
+			if value.Kind != yaml.SequenceNode {
+				v.errorf(value, "include must be a list of paths")
+				continue
+			}
+			for _, item := range value.Content {
+				if _, ok := v.name(item, "include path"); ok {
+					d.includes = append(d.includes, item)
+				}
+			}
+		default:
+			v.errorf(key, "unknown key %q", key.Value)
+		}
+	}
+	for _, name := range sortedNames(d.policy.Deny) {
+		if _, ok := d.roleKeys[d.roleName(name)]; !ok {
+			v.errorf(d.denyKeys[name], "deny for role %q which is not defined in this document", name)
+		}
+	}
+	return d
+}
+
+// denyName returns the name the denied permissions of role `name`
+// are listed by in the document, which may differ in case.
+func (d *document) denyName(name string) string {
+	for listed := range d.policy.Deny {
+		if strings.EqualFold(listed, name) {
+			return listed
+		}
+	}
+	return name
+}
+
+// roleName returns the name role `name` is defined by in the document,
+// which may differ in case.
+func (d *document) roleName(name string) string {
+	for defined := range d.policy.Roles {
+		if strings.EqualFold(defined, name) {
+			return defined
+		}
+	}
+	return name
+}
+
+func (v *validator) roles(n *yaml.Node, d *document) {
+	if isNull(n) {
+		return
+	}
+	if n.Kind != yaml.MappingNode {
+		v.errorf(n, "roles must be a mapping of role names to permissions")
+		return
+	}
+	ids := make(map[string]string)
+	for i := 0; i < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		name, ok := v.name(key, "role name")
+		if !ok {
+			continue
+		}
+		id := strings.ToLower(name)
+		if other, ok := ids[id]; ok {
+			v.errorf(key, "duplicate role %q, already defined as %q", name, other)
+			continue
+		}
+		ids[id] = name
+		g := v.grants(value, "permissions of role "+name)
+		d.policy.Roles[name], d.policy.Grants[name] = g.permissions, g.grants
+		d.roleKeys[name] = key
+	}
+}
+
+func (v *validator) deny(n *yaml.Node, d *document) {
+	if isNull(n) {
+		return
+	}
+	if n.Kind != yaml.MappingNode {
+		v.errorf(n, "deny must be a mapping of role names to permissions")
+		return
+	}
+	seen := make(map[string]struct{})
+	for i := 0; i < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		name, ok := v.name(key, "role name")
+		if !ok {
+			continue
+		}
+		id := strings.ToLower(name)
+		if _, ok := seen[id]; ok {
+			v.errorf(key, "duplicate deny for role %q", name)
+			continue
+		}
+		seen[id] = struct{}{}
+		g := v.grants(value, "denied permissions of role "+name)
+		d.policy.Deny[name], d.policy.DenyGrants[name] = g.permissions, g.grants
+		d.denyKeys[name] = key
+	}
+}
+
+// grantList holds the permissions of a list of grants and the grants
+// of the permissions requiring conditions or limited in time.
+type grantList struct {
+	permissions []string
+	grants      map[string]*Grant
+}
+
+// grant is a single entry of a list of grants.
+type grant struct {
+	Grant
+	node *yaml.Node
+}
+
+// grants returns the grants of a list. A grant is either a permission
+// or a mapping with the keys `permission`, `conditions`, which lists the
+// names of registered conditions, `when`, which lists attribute
+// conditions, `expr`, an expression, see rbac.CompileExpression, and
+// `notBefore`, `notAfter` and `schedule`, which limit its validity.
+func (v *validator) grants(n *yaml.Node, what string) grantList {
+	l := grantList{permissions: []string{}}
+	if isNull(n) {
+		return l
+	}
+	if n.Kind != yaml.SequenceNode {
+		v.errorf(n, "%s must be a list of strings", what)
+		return l
+	}
+	seen := make(map[string]string)
+	for _, item := range n.Content {
+		g, ok := v.grant(item, what)
+		if !ok {
+			continue
+		}
+		pid := g.Permission
+		if _, err := regexp.Compile(pid); err != nil {
+			v.errorf(g.node, "invalid permission %q: %v", pid, err)
+			continue
+		}
+		key := fmt.Sprint(g.Conditions, g.When, g.Expr, g.Validity)
+		if other, ok := seen[pid]; ok {
+			if other != key {
+				v.errorf(g.node, "permission %q is listed twice with different conditions", pid)
+			}
+			continue
+		}
+		seen[pid] = key
+		l.permissions = append(l.permissions, pid)
+		if g.restricted() {
+			if l.grants == nil {
+				l.grants = make(map[string]*Grant)
+			}
+			restricted := g.Grant
+			l.grants[pid] = &restricted
+		}
+	}
+	return l
+}
+
+// grant returns a grant with sorted conditions.
+func (v *validator) grant(n *yaml.Node, what string) (grant, bool) {
+	if n.Kind == yaml.ScalarNode && !isNull(n) {
+		return grant{Grant: Grant{Permission: n.Value}, node: n}, true
+	}
+	if n.Kind != yaml.MappingNode {
+		v.errorf(n, "%s must be permissions or mappings with a permission", what)
+		return grant{}, false
+	}
+	var g grant
+	for i := 0; i < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		switch key.Value {
+		case "permission":
+			if value.Kind != yaml.ScalarNode || isNull(value) {
+				v.errorf(value, "permission must be a string")
+				continue
+			}
+			g.Permission, g.node = value.Value, value
+		case "conditions":
+			values, nodes := v.strings(value, "conditions")
+			for j, name := range values {
+				if !rbac2.HasCondition(name) {
+					v.errorf(nodes[j], "%v %q", rbac2.ErrUnknownCondition, name)
+					continue
+				}
+				g.Conditions = append(g.Conditions, name)
+			}
+		case "when":
+			g.When = v.when(value)
+		case "expr":
+			src, ok := v.name(value, "expr")
+			if !ok {
+				continue
+			}
+			if _, err := rbac2.CompileExpression(src); err != nil {
+				v.errorf(value, "%v", err)
+				continue
+			}
+			g.Expr = src
+		case "notBefore", "notAfter", "schedule":
+			if g.Validity == nil {
+				g.Validity = &rbac2.Validity{}
+			}
+			v.validityKey(key, value, g.Validity)
+		default:
+			v.errorf(key, "unknown key %q", key.Value)
+		}
+	}
+	if g.node == nil {
+		v.errorf(n, "%s: grant without permission", what)
+		return grant{}, false
+	}
+	if g.Validity != nil {
+		if err := g.Validity.Validate(); err != nil {
+			v.errorf(n, "%v", err)
+			g.Validity = nil
+		}
+	}
+	sort.Strings(g.Conditions)
+	return g, true
+}
+
+// when returns the attribute conditions of a list of mappings with the
+// keys `attribute`, `operator` and either `value` or `ref`.
+func (v *validator) when(n *yaml.Node) []rbac2.AttributeCondition {
+	if isNull(n) {
+		return nil
+	}
+	if n.Kind != yaml.SequenceNode {
+		v.errorf(n, "when must be a list of attribute conditions")
+		return nil
+	}
+	var result []rbac2.AttributeCondition
+	for _, item := range n.Content {
+		if item.Kind != yaml.MappingNode {
+			v.errorf(item, "attribute condition must be a mapping")
+			continue
+		}
+		var c rbac2.AttributeCondition
+		ok := true
+		for i := 0; i < len(item.Content); i += 2 {
+			key, value := item.Content[i], item.Content[i+1]
+			switch key.Value {
+			case "attribute":
+				c.Attribute, ok = v.name(value, "attribute")
+			case "operator":
+				c.Operator, ok = v.name(value, "operator")
+			case "ref":
+				c.Ref, ok = v.name(value, "ref")
+			case "value":
+				if err := value.Decode(&c.Value); err != nil {
+					v.errorf(value, "%v", err)
+					ok = false
+				}
+			default:
+				v.errorf(key, "unknown key %q", key.Value)
+				ok = false
+			}
+			if !ok {
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+		if err := c.Validate(); err != nil {
+			v.errorf(item, "%v", err)
+			continue
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+func (v *validator) inher(n *yaml.Node, d *document) {
+	if isNull(n) {
+		return
+	}
+	if n.Kind != yaml.MappingNode {
+		v.errorf(n, "inher must be a mapping of role names to parents")
+		return
+	}
+	seen := make(map[string]struct{})
+	for i := 0; i < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		name, ok := v.name(key, "role name")
+		if !ok {
+			continue
+		}
+		id := strings.ToLower(name)
+		if _, ok := seen[id]; ok {
+			v.errorf(key, "duplicate inheritance for role %q", name)
+			continue
+		}
+		seen[id] = struct{}{}
+		d.policy.Inher[name], d.parentNodes[name], d.policy.InherValidity[name] = v.parents(value, name)
+		d.inherKeys[name] = key
+	}
+}
+
+// parents returns the parents of role `name` together with the nodes
+// they were read from and the validities of the inheritances. A parent
+// is either a role name or a mapping with the keys `role`, `notBefore`,
+// `notAfter` and `schedule`.
+func (v *validator) parents(n *yaml.Node, name string) ([]string, []*yaml.Node, map[string]*rbac2.Validity) {
+	what := "parents of role " + name
+	if isNull(n) {
+		return []string{}, nil, nil
+	}
+	if n.Kind != yaml.SequenceNode {
+		v.errorf(n, "%s must be a list of strings", what)
+		return []string{}, nil, nil
+	}
+	result := make([]string, 0, len(n.Content))
+	var nodes []*yaml.Node
+	var validities map[string]*rbac2.Validity
+	for _, item := range n.Content {
+		if item.Kind == yaml.ScalarNode && !isNull(item) {
+			result = append(result, item.Value)
+			nodes = append(nodes, item)
+			continue
+		}
+		if item.Kind != yaml.MappingNode {
+			v.errorf(item, "%s must be role names or mappings with a role", what)
+			continue
+		}
+		var role *yaml.Node
+		validity := &rbac2.Validity{}
+		for i := 0; i < len(item.Content); i += 2 {
+			key, value := item.Content[i], item.Content[i+1]
+			switch key.Value {
+			case "role":
+				if _, ok := v.name(value, "role"); ok {
+					role = value
+				}
+			case "notBefore", "notAfter", "schedule":
+				v.validityKey(key, value, validity)
+			default:
+				v.errorf(key, "unknown key %q", key.Value)
+			}
+		}
+		if role == nil {
+			v.errorf(item, "%s: parent without role", what)
+			continue
+		}
+		if err := validity.Validate(); err != nil {
+			v.errorf(item, "%v", err)
+			continue
+		}
+		result = append(result, role.Value)
+		nodes = append(nodes, role)
+		if validity.NotBefore.IsZero() && validity.NotAfter.IsZero() && validity.Schedule == "" {
+			continue
+		}
+		if validities == nil {
+			validities = make(map[string]*rbac2.Validity)
+		}
+		validities[role.Value] = validity
+	}
+	return result, nodes, validities
+}
+
+// validityKey sets the field of `validity` named by `key` to `value`.
+// Times are RFC 3339 timestamps or dates.
+func (v *validator) validityKey(key, value *yaml.Node, validity *rbac2.Validity) {
+	if key.Value == "schedule" {
+		src, ok := v.name(value, "schedule")
+		if !ok {
+			return
+		}
+		if _, err := rbac2.ParseSchedule(src); err != nil {
+			v.errorf(value, "%v", err)
+			return
+		}
+		validity.Schedule = src
+		return
+	}
+	t, err := parseTime(value)
+	if err != nil {
+		v.errorf(value, "%s %v", key.Value, err)
+		return
+	}
+	if key.Value == "notBefore" {
+		validity.NotBefore = t
+	} else {
+		validity.NotAfter = t
+	}
+}
+
+func parseTime(n *yaml.Node) (time.Time, error) {
+	if n.Kind != yaml.ScalarNode || isNull(n) {
+		return time.Time{}, fmt.Errorf("must be a timestamp")
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+		if t, err := time.Parse(layout, n.Value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is no RFC 3339 timestamp", n.Value)
+}
+
+// constraints reads a mapping of constraint names to constraints:
+// mappings with the keys `roles`, `max` and `dynamic` are separation
+// of duty constraints, ones with `role` and `max` cardinality and ones
+// with `role` and `requires` prerequisite constraints, see rbac.Constraint.
+func (v *validator) constraints(n *yaml.Node, d *document) {
+	if isNull(n) {
+		return
+	}
+	if n.Kind != yaml.MappingNode {
+		v.errorf(n, "constraints must be a mapping of constraint names to constraints")
+		return
+	}
+	for i := 0; i < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		name, ok := v.name(key, "constraint name")
+		if !ok {
+			continue
+		}
+		if _, ok := d.constraintKeys[name]; ok {
+			v.errorf(key, "duplicate constraint %q", name)
+			continue
+		}
+		if value.Kind != yaml.MappingNode {
+			v.errorf(value, "constraint %q must be a mapping with roles or a role", name)
+			continue
+		}
+		var (
+			roles, requires []string
+			role            string
+			max             int
+			dynamic         bool
+			nodes, reqNodes []*yaml.Node
+			keys            = make(map[string]struct{})
+		)
+		ok = true
+		for j := 0; j < len(value.Content); j += 2 {
+			k, val := value.Content[j], value.Content[j+1]
+			keys[k.Value] = struct{}{}
+			switch k.Value {
+			case "roles":
+				roles, nodes = v.strings(val, "roles of constraint "+name)
+			case "role":
+				if role, ok = v.name(val, "role of constraint "+name); ok {
+					nodes = append([]*yaml.Node{val}, nodes...)
+				}
+			case "requires":
+				requires, reqNodes = v.strings(val, "required roles of constraint "+name)
+			case "max":
+				if err := val.Decode(&max); err != nil || max < 1 {
+					v.errorf(val, "max of constraint %q must be a positive integer", name)
+					ok = false
+				}
+			case "dynamic":
+				if err := val.Decode(&dynamic); err != nil {
+					v.errorf(val, "dynamic of constraint %q must be a boolean", name)
+					ok = false
+				}
+			default:
+				v.errorf(k, "unknown key %q", k.Value)
+				ok = false
+			}
+			if !ok {
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+		var c rbac2.Constraint
+		var allowed []string
+		switch _, hasRoles := keys["roles"]; {
+		case hasRoles:
+			c, allowed = rbac2.SoDConstraint{Name: name, Roles: roles, Max: max, Dynamic: dynamic},
+				[]string{"roles", "max", "dynamic"}
+		case role != "" && requires != nil:
+			c, allowed = rbac2.PrerequisiteConstraint{Name: name, Role: role, Requires: requires},
+				[]string{"role", "requires"}
+			nodes = append(nodes, reqNodes...)
+		case role != "":
+			c, allowed = rbac2.CardinalityConstraint{Name: name, Role: role, Max: max},
+				[]string{"role", "max"}
+		default:
+			v.errorf(value, "constraint %q must have roles or a role", name)
+			continue
+		}
+		for j := 0; j < len(value.Content); j += 2 {
+			if k := value.Content[j]; !contains(allowed, k.Value) {
+				v.errorf(k, "key %q is not allowed by constraint %q", k.Value, name)
+				ok = false
+			}
+		}
+		if !ok {
+			continue
+		}
+		if err := c.Validate(); err != nil {
+			v.errorf(value, "%v", err)
+			continue
+		}
+		d.policy.Constraints[name] = c
+		d.constraintKeys[name] = key
+		d.constraintRoles[name] = nodes
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *validator) name(n *yaml.Node, what string) (string, bool) {
+	if n.Kind != yaml.ScalarNode || n.Tag != "!!str" || n.Value == "" {
+		v.errorf(n, "%s must be a non-empty string", what)
+		return "", false
+	}
+	return n.Value, true
+}
+
+// strings returns the values of a list of strings
+// together with the nodes they were read from.
+func (v *validator) strings(n *yaml.Node, what string) ([]string, []*yaml.Node) {
+	if isNull(n) {
+		return []string{}, nil
+	}
+	if n.Kind != yaml.SequenceNode {
+		v.errorf(n, "%s must be a list of strings", what)
+		return []string{}, nil
+	}
+	result := make([]string, 0, len(n.Content))
+	var nodes []*yaml.Node
+	for _, item := range n.Content {
+		if item.Kind != yaml.ScalarNode || isNull(item) {
+			v.errorf(item, "%s must be a list of strings", what)
+			continue
+		}
+		result = append(result, item.Value)
+		nodes = append(nodes, item)
+	}
+	return result, nodes
+}
+
+func isNull(n *yaml.Node) bool {
+	return n.Kind == yaml.ScalarNode && n.Tag == "!!null"
+}
+
+// policyCycles returns one path for every inheritance circle,
+// using role ids.
+func policyCycles(p *Policy) [][]string {
+	b := rbac2.NewMapBackend()
+	r := rbac2.New(b)
+	defer r.Close()
+	for name, parents := range p.Inher {
+		for _, parent := range parents {
+			b.SetParent(strings.ToLower(name), strings.ToLower(parent), struct{}{})
+		}
+	}
+	if err, ok := rbac2.InherCircle(r).(*rbac2.CircleError); ok {
+		return err.Paths
+	}
+	return nil
+}
+
+// policyViolations returns the violations of the constraints of `p`,
+// whose roles must all be defined.
+func policyViolations(p *Policy) []error {
+	if len(p.Constraints) == 0 {
+		return nil
+	}
+	b := rbac2.NewMapBackend()
+	r := rbac2.New(b)
+	defer r.Close()
+	for name := range p.Roles {
+		b.SetRole(strings.ToLower(name), &rbac2.RBACRole{Name: name})
+	}
+	// constraints are added before the parents are bound,
+	// which would fail instead of reporting every violation
+	for _, c := range p.Constraints {
+		r.AddConstraint(c)
+	}
+	for name, parents := range p.Inher {
+		for _, parent := range parents {
+			b.SetParent(strings.ToLower(name), strings.ToLower(parent), struct{}{})
+		}
+	}
+	return r.Violations()
+}
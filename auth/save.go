@@ -0,0 +1,263 @@
+package auth
+
+import (
+	"bufio"
+	"github.com/mikespook/gorbac"
+	rbac2 "github.com/z26100/rbac-go"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// SaveOptions controls the layout of saved policy files.
+type SaveOptions struct {
+	// Indent is the number of spaces used for indentation.
+	// 0 selects 4 spaces for YAML and compact output for JSON.
+	Indent int
+	// Comment is written at the top of YAML, TOML and HCL files.
+	Comment string
+	// SourceComments annotates each role of YAML and HCL files with its source file.
+	SourceComments bool
+}
+
+var (
+	saveOptions = SaveOptions{}
+)
+
+func SetSaveOptions(opts SaveOptions) {
+	saveOptions = opts
+}
+
+// currentPolicy returns the policy of `r` with sorted permissions and parents.
+func currentPolicy(r *rbac2.RBAC) (*Policy, error) {
+	p := newPolicy()
+	err := rbac2.Walk(r, func(role gorbac.Role, parents []string) error {
+		// WARNING: Don't use rbacmap instance in the handler,
+		// otherwise it causes deadlock.
+		permissions := make([]string, 0)
+		if rr, ok := role.(*rbac2.RBACRole); ok {
+			permissions = sortedIds(rr.Permissions)
+			if g := grantsOf(rr.Permissions); g != nil {
+				p.Grants[role.ID()] = g
+			}
+			if len(rr.Deny) > 0 {
+				p.Deny[role.ID()] = sortedIds(rr.Deny)
+				if g := grantsOf(rr.Deny); g != nil {
+					p.DenyGrants[role.ID()] = g
+				}
+			}
+			if rr.Source != "" {
+				p.Sources[role.ID()] = rr.Source
+			}
+		}
+		sort.Strings(permissions)
+		sorted := append([]string{}, parents...)
+		sort.Strings(sorted)
+		p.Roles[role.ID()] = permissions
+		p.Inher[role.ID()] = sorted
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for id, parents := range p.Inher {
+		for _, parent := range parents {
+			v, err := r.GetParentValidity(id, parent)
+			if err != nil {
+				return nil, err
+			}
+			if v == nil {
+				continue
+			}
+			if p.InherValidity[id] == nil {
+				p.InherValidity[id] = make(map[string]*rbac2.Validity)
+			}
+			p.InherValidity[id][parent] = v
+		}
+	}
+	for _, c := range r.Constraints() {
+		p.Constraints[c.ConstraintName()] = c
+	}
+	return p, nil
+}
+
+// sortedIds returns the sorted ids of `permissions`.
+func sortedIds(permissions map[string]*rbac2.RBACPermission) []string {
+	result := make([]string, 0, len(permissions))
+	for pid := range permissions {
+		result = append(result, pid)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// policyFile is the layout policies are saved in.
+// Permissions requiring conditions are saved as grant mappings,
+// parents whose inheritance is limited in time as parent mappings.
+type policyFile struct {
+	Roles map[string][]interface{} `json:"roles" yaml:"roles" toml:"roles"`
+	Deny  map[string][]interface{} `json:"deny,omitempty" yaml:"deny,omitempty" toml:"deny,omitempty"`
+	Inher map[string][]interface{} `json:"inher" yaml:"inher" toml:"inher"`
+
+	Constraints map[string]constraintFile `json:"constraints,omitempty" yaml:"constraints,omitempty" toml:"constraints,omitempty"`
+}
+
+type constraintFile struct {
+	Roles    []string `json:"roles,omitempty" yaml:"roles,omitempty" toml:"roles,omitempty"`
+	Role     string   `json:"role,omitempty" yaml:"role,omitempty" toml:"role,omitempty"`
+	Requires []string `json:"requires,omitempty" yaml:"requires,omitempty" toml:"requires,omitempty"`
+	Max      int      `json:"max,omitempty" yaml:"max,omitempty" toml:"max,omitempty,omitzero"`
+	Dynamic  bool     `json:"dynamic,omitempty" yaml:"dynamic,omitempty" toml:"dynamic,omitempty"`
+}
+
+// constraintFileOf returns the fields of one of the constraint types.
+func constraintFileOf(c rbac2.Constraint) constraintFile {
+	switch c := c.(type) {
+	case rbac2.SoDConstraint:
+		return constraintFile{Roles: c.Roles, Max: c.Max, Dynamic: c.Dynamic}
+	case rbac2.CardinalityConstraint:
+		return constraintFile{Role: c.Role, Max: c.Max}
+	case rbac2.PrerequisiteConstraint:
+		return constraintFile{Role: c.Role, Requires: c.Requires}
+	}
+	return constraintFile{}
+}
+
+type grantFile struct {
+	Permission string          `json:"permission" yaml:"permission" toml:"permission"`
+	Conditions []string        `json:"conditions,omitempty" yaml:"conditions,omitempty" toml:"conditions,omitempty"`
+	When       []conditionFile `json:"when,omitempty" yaml:"when,omitempty" toml:"when,omitempty"`
+	Expr       string          `json:"expr,omitempty" yaml:"expr,omitempty" toml:"expr,omitempty"`
+	NotBefore  string          `json:"notBefore,omitempty" yaml:"notBefore,omitempty" toml:"notBefore,omitempty"`
+	NotAfter   string          `json:"notAfter,omitempty" yaml:"notAfter,omitempty" toml:"notAfter,omitempty"`
+	Schedule   string          `json:"schedule,omitempty" yaml:"schedule,omitempty" toml:"schedule,omitempty"`
+}
+
+type parentFile struct {
+	Role      string `json:"role" yaml:"role" toml:"role"`
+	NotBefore string `json:"notBefore,omitempty" yaml:"notBefore,omitempty" toml:"notBefore,omitempty"`
+	NotAfter  string `json:"notAfter,omitempty" yaml:"notAfter,omitempty" toml:"notAfter,omitempty"`
+	Schedule  string `json:"schedule,omitempty" yaml:"schedule,omitempty" toml:"schedule,omitempty"`
+}
+
+type conditionFile struct {
+	Attribute string      `json:"attribute" yaml:"attribute" toml:"attribute"`
+	Operator  string      `json:"operator" yaml:"operator" toml:"operator"`
+	Value     interface{} `json:"value,omitempty" yaml:"value,omitempty" toml:"value,omitempty"`
+	Ref       string      `json:"ref,omitempty" yaml:"ref,omitempty" toml:"ref,omitempty"`
+}
+
+func (p *Policy) file() *policyFile {
+	f := &policyFile{
+		Roles: grantFiles(p.Roles, p.Grants),
+		Inher: make(map[string][]interface{}, len(p.Inher)),
+	}
+	if len(p.Deny) > 0 {
+		f.Deny = grantFiles(p.Deny, p.DenyGrants)
+	}
+	for name, parents := range p.Inher {
+		values := make([]interface{}, len(parents))
+		for i, parent := range parents {
+			values[i] = parent
+			if v := p.InherValidity[name][parent]; v != nil {
+				values[i] = parentFile{
+					Role:      parent,
+					NotBefore: formatTime(v.NotBefore),
+					NotAfter:  formatTime(v.NotAfter),
+					Schedule:  v.Schedule,
+				}
+			}
+		}
+		f.Inher[name] = values
+	}
+	if len(p.Constraints) > 0 {
+		f.Constraints = make(map[string]constraintFile, len(p.Constraints))
+		for name, c := range p.Constraints {
+			f.Constraints[name] = constraintFileOf(c)
+		}
+	}
+	return f
+}
+
+func grantFiles(permissions map[string][]string, grants map[string]map[string]*Grant) map[string][]interface{} {
+	result := make(map[string][]interface{}, len(permissions))
+	for name, pids := range permissions {
+		values := make([]interface{}, len(pids))
+		for i, pid := range pids {
+			g := grantOf(grants[name], pid)
+			if !g.restricted() {
+				values[i] = pid
+				continue
+			}
+			f := grantFile{Permission: pid, Conditions: g.Conditions, Expr: g.Expr}
+			for _, ac := range g.When {
+				f.When = append(f.When, conditionFile(ac))
+			}
+			if v := g.Validity; v != nil {
+				f.NotBefore, f.NotAfter, f.Schedule = formatTime(v.NotBefore), formatTime(v.NotAfter), v.Schedule
+			}
+			values[i] = f
+		}
+		result[name] = values
+	}
+	return result
+}
+
+// formatTime returns `t` as RFC 3339 timestamp, or "" if it is zero.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// writeFileAtomic writes `filename` by writing a temporary file in the
+// same directory, syncing it to disk and renaming it into place.
+// Readers see either the old or the new file, never a partial one.
+// A symlink is followed, so its target is replaced, and the mode of an
+// existing file is kept; new files are created with mode 0644.
+func writeFileAtomic(filename string, write func(w io.Writer) error) (err error) {
+	if resolved, err := filepath.EvalSymlinks(filename); err == nil {
+		filename = resolved
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(filename); err == nil {
+		mode = info.Mode().Perm()
+	}
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := ioutil.TempFile(dir, "."+base+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	bw := bufio.NewWriter(f)
+	if err = write(bw); err != nil {
+		return err
+	}
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+	if err = f.Chmod(mode); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
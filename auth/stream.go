@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"io"
+	"io/fs"
+)
+
+// Load adds the policy read from `r` in the format `t` to the current policy.
+// AUTO selects YAML, which also reads JSON. Includes are not supported.
+func Load(r io.Reader, t FileType) error {
+	p, err := ParsePolicyReader(r, t)
+	if err != nil {
+		return err
+	}
+	return LoadPolicy(p)
+}
+
+// LoadFS adds the policy stored in the files of `fsys` matching `pattern`
+// to the current policy, for example from an embed.FS bundle.
+// `pattern` may also name a file or a directory.
+func LoadFS(fsys fs.FS, pattern string) error {
+	p, err := ParsePolicyFS(fsys, pattern)
+	if err != nil {
+		return err
+	}
+	return LoadPolicy(p)
+}
+
+// Save writes the current policy to `w` in the format `t`.
+// AUTO selects YAML.
+func Save(w io.Writer, t FileType) error {
+	if t == AUTO {
+		t = YAML
+	}
+	c, err := codecFor(t)
+	if err != nil {
+		return err
+	}
+	p, err := currentPolicy(instance())
+	if err != nil {
+		return err
+	}
+	return c.Encode(w, p, saveOptions)
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	rbac2 "github.com/z26100/rbac-go"
+)
+
+// SyncOptions controls how Sync applies a policy file.
+type SyncOptions struct {
+	// DryRun computes the changeset without applying it.
+	DryRun bool
+	// Prune removes roles which are not declared in the policy file.
+	// Without Prune, such roles are kept together with the parents
+	// bound to or from them.
+	Prune bool
+}
+
+// Sync makes the current policy match the policy stored in `filename`.
+// Roles declared in the file are added or updated, including their
+// permissions and parents. The applied changeset is returned. Sync is
+// not atomic: on an error, the changes applied until then are returned
+// together with the error, see rbac.Apply.
+func Sync(filename string, opts SyncOptions) (*rbac2.Changeset, error) {
+	cs, err := DiffFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.Prune {
+		cs = withoutRemovedRoles(cs)
+	}
+	if opts.DryRun {
+		return cs, nil
+	}
+	return instance().Apply(cs)
+}
+
+// withoutRemovedRoles drops the removed roles and the parents
+// bound to them from the changeset.
+func withoutRemovedRoles(cs *rbac2.Changeset) *rbac2.Changeset {
+	removed := make(map[string]struct{}, len(cs.RemovedRoles))
+	for _, id := range cs.RemovedRoles {
+		removed[id] = struct{}{}
+	}
+	result := *cs
+	result.RemovedRoles = nil
+	result.RemovedParents = nil
+	for _, e := range cs.RemovedParents {
+		if _, ok := removed[e.Child]; ok {
+			continue
+		}
+		if _, ok := removed[e.Parent]; ok {
+			continue
+		}
+		result.RemovedParents = append(result.RemovedParents, e)
+	}
+	return &result
+}
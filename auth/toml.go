@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"io"
+	"strings"
+)
+
+// tomlCodec reads and writes policies as TOML tables:
+//
+//	[roles]
+//	admin = [".*"]
+//	[inher]
+//	admin = []
+type tomlCodec struct{}
+
+func (tomlCodec) Decode(data []byte) ([]*yaml.Node, error) {
+	var v map[string]interface{}
+	if _, err := toml.Decode(string(data), &v); err != nil {
+		return nil, err
+	}
+	n, err := nodeOf(v)
+	if err != nil {
+		return nil, err
+	}
+	return []*yaml.Node{n}, nil
+}
+
+func (tomlCodec) Encode(w io.Writer, p *Policy, opts SaveOptions) error {
+	bw := bufio.NewWriter(w)
+	writeComment(bw, opts.Comment)
+	enc := toml.NewEncoder(bw)
+	enc.Indent = strings.Repeat(" ", opts.Indent)
+	f := p.file()
+	for _, values := range []map[string][]interface{}{f.Roles, f.Deny, f.Inher} {
+		if err := inlineTables(values); err != nil {
+			return err
+		}
+	}
+	if err := enc.Encode(f); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// inlineTables converts the mappings of lists mixing strings and mappings
+// into maps. Such lists are written as inline tables, and the encoder
+// writes a trailing comma if the last field of a struct is left out.
+func inlineTables(values map[string][]interface{}) error {
+	for name, list := range values {
+		mixed := false
+		for _, v := range list {
+			if _, ok := v.(string); ok {
+				mixed = true
+				break
+			}
+		}
+		if !mixed {
+			continue
+		}
+		for i, v := range list {
+			if _, ok := v.(string); ok {
+				continue
+			}
+			data, err := yaml.Marshal(v)
+			if err != nil {
+				return err
+			}
+			var m map[string]interface{}
+			if err := yaml.Unmarshal(data, &m); err != nil {
+				return err
+			}
+			values[name][i] = m
+		}
+	}
+	return nil
+}
+
+// writeComment writes `comment` as `#` comment lines.
+func writeComment(w io.Writer, comment string) {
+	if comment == "" {
+		return
+	}
+	for _, line := range strings.Split(comment, "\n") {
+		fmt.Fprintln(w, strings.TrimRight("# "+line, " "))
+	}
+}
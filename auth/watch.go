@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	rbac2 "github.com/z26100/rbac-go"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrSharedBackend occurred if the factory of a Watcher returned an
+	// instance sharing the backend of the current one
+	ErrSharedBackend = errors.New("fresh instance shares the backend of the current one")
+)
+
+// ReloadEvent describes the outcome of a policy reload.
+type ReloadEvent struct {
+	Filename string
+	Time     time.Time
+	Duration time.Duration
+	// Changes lists the changes of the policy if the reload succeeded.
+	Changes *rbac2.Changeset
+	// Err is set if the reload failed and the previous policy was kept.
+	Err error
+}
+
+// Watcher reloads a policy whenever one of its files changes.
+type Watcher struct {
+	filename  string
+	interval  time.Duration
+	callbacks []func(ReloadEvent)
+	mutex     sync.Mutex
+	files     []string
+	version   string
+	factory   func() (*rbac2.RBAC, error)
+	stop      chan struct{}
+	done      chan struct{}
+
+	// constraints holds the names of the constraints of the policy
+	// loaded last, the others were added by code
+	constraints map[string]struct{}
+}
+
+// Watch loads the policy stored in `filename` and polls its files every
+// `interval` for changes. A changed policy is validated and built into a
+// fresh RBAC instance with a backend of the same kind as the current
+// one, see NewRBAC and NewMongo, which atomically replaces the current
+// one. If the new policy is invalid, the current one is kept. The
+// callbacks are invoked after every reload, successful or not.
+// `filename` may be a file, a directory or a glob pattern, see ParsePolicyFiles.
+func Watch(filename string, interval time.Duration, callbacks ...func(ReloadEvent)) (*Watcher, error) {
+	return WatchWith(filename, interval, newInstance, callbacks...)
+}
+
+// WatchWith is like Watch, but builds the instances by `factory`, which
+// must return an empty instance whose backend isn't the one of the
+// current instance, see ErrSharedBackend. The clock and the constraints
+// added by code are copied from the current instance. A fresh instance
+// failing to load is cleared. The replaced instance isn't closed, since
+// callers may still use it and its backend may share resources like a
+// client with the fresh one.
+func WatchWith(filename string, interval time.Duration, factory func() (*rbac2.RBAC, error),
+	callbacks ...func(ReloadEvent)) (*Watcher, error) {
+	w := &Watcher{
+		filename:  filename,
+		interval:  interval,
+		callbacks: callbacks,
+		factory:   factory,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if err := w.Reload(); err != nil {
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+// Reload reloads the policy immediately.
+func (w *Watcher) Reload() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.reload()
+}
+
+// Close stops watching. The current policy is kept.
+func (w *Watcher) Close() {
+	close(w.stop)
+	<-w.done
+}
+
+func (w *Watcher) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.mutex.Lock()
+			if w.fingerprint() != w.version {
+				w.reload()
+			}
+			w.mutex.Unlock()
+		}
+	}
+}
+
+func (w *Watcher) reload() error {
+	start := time.Now()
+	event := ReloadEvent{Filename: w.filename, Time: start}
+	// files changed while parsing differ from the version
+	// and are reloaded by the next poll
+	w.version = w.fingerprint()
+	p, files, err := parsePolicyFiles(w.filename)
+	w.files = files
+	if err == nil {
+		event.Changes, err = w.replace(p)
+	}
+	event.Err = err
+	event.Duration = time.Since(start)
+	for _, fc := range w.callbacks {
+		fc(event)
+	}
+	return err
+}
+
+// replace builds `p` into a fresh instance which replaces the current one.
+func (w *Watcher) replace(p *Policy) (*rbac2.Changeset, error) {
+	old := instance()
+	fresh, err := w.factory()
+	if err != nil {
+		return nil, err
+	}
+	if old != nil && fresh.SharesBackend(old) {
+		return nil, ErrSharedBackend
+	}
+	var clock func() time.Time
+	var added []rbac2.Constraint
+	if old != nil {
+		clock = old.Clock()
+		added = w.addedConstraints(old, p)
+	}
+	if err := load(fresh, p, clock, added); err != nil {
+		fresh.Clear()
+		return nil, err
+	}
+	var changes *rbac2.Changeset
+	if old != nil {
+		changes, err = rbac2.Diff(old, fresh)
+		if err != nil {
+			fresh.Clear()
+			return nil, err
+		}
+	}
+	current.Store(fresh)
+	w.constraints = make(map[string]struct{}, len(p.Constraints))
+	for name := range p.Constraints {
+		w.constraints[name] = struct{}{}
+	}
+	return changes, nil
+}
+
+// addedConstraints returns the constraints of `old` added by code:
+// the ones neither the policy loaded last nor `p` define.
+func (w *Watcher) addedConstraints(old *rbac2.RBAC, p *Policy) []rbac2.Constraint {
+	var result []rbac2.Constraint
+	for _, c := range old.Constraints() {
+		_, loaded := w.constraints[c.ConstraintName()]
+		_, defined := p.Constraints[c.ConstraintName()]
+		if !loaded && !defined {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// load applies `p` to `target` with the clock `clock`
+// and adds the constraints `added`.
+func load(target *rbac2.RBAC, p *Policy, clock func() time.Time, added []rbac2.Constraint) error {
+	target.SetClock(clock)
+	if err := applyPolicy(target, p); err != nil {
+		return err
+	}
+	for _, c := range added {
+		if err := target.AddConstraint(c); err != nil {
+			return fmt.Errorf("constraint %q: %w", c.ConstraintName(), err)
+		}
+	}
+	return nil
+}
+
+// fingerprint summarizes name, size and modification time of every
+// policy file, including files added to a watched directory or glob.
+func (w *Watcher) fingerprint() string {
+	names := make(map[string]struct{})
+	for _, file := range w.files {
+		names[file] = struct{}{}
+	}
+	if strings.ContainsAny(w.filename, "*?[") {
+		matches, _ := filepath.Glob(w.filename)
+		for _, file := range matches {
+			names[file] = struct{}{}
+		}
+	} else if info, err := os.Stat(w.filename); err == nil && info.IsDir() {
+		matches, _ := filepath.Glob(filepath.Join(w.filename, "*"))
+		for _, file := range matches {
+			if isPolicyFile(file) {
+				names[file] = struct{}{}
+			}
+		}
+	}
+	var files []string
+	for file := range names {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	h := sha256.New()
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			fmt.Fprintf(h, "%s %d %d\n", file, info.Size(), info.ModTime().UnixNano())
+		} else {
+			fmt.Fprintf(h, "%s missing\n", file)
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
@@ -17,8 +17,21 @@ type Backend interface {
 	DeleteRole(id string) error
 	GetAllParents() map[string]map[string]struct{}
 	GetParents(id string) (map[string]struct{}, bool)
+	GetChildren(id string) (map[string]struct{}, bool)
 	SetParent(id string, pid string, p struct{}) error
 	SetParents(id string, p map[string]struct{})
 	DeleteParents(id string) error
-	DeleteParent(pid, id string) error
+	DeleteParent(id, pid string) error
+	// GetParentValidities returns the validities of the parents of
+	// the role `id` which are limited in time.
+	GetParentValidities(id string) map[string]*Validity
+	// SetParentValidity limits the inheritance of `pid` by `id`,
+	// a nil Validity removes the limit. SetParent removes it as well.
+	SetParentValidity(id, pid string, v *Validity) error
+	// GetConstraints returns the stored constraints.
+	GetConstraints() []Constraint
+	// SetConstraint stores `c`, replacing a constraint of the same name.
+	SetConstraint(c Constraint) error
+	// DeleteConstraint removes the constraint named `name`, if any.
+	DeleteConstraint(name string) error
 }
@@ -0,0 +1,315 @@
+// Package casbin converts between Casbin RBAC policies and rbac-go policies.
+//
+// A Casbin policy line `p, sub, obj, act, allow` grants the role `sub`
+// the permission `act:obj`, `p, sub, obj, act, deny` denies it, and
+// `g, child, parent` lets the role `child` inherit from `parent`. As in
+// rbac-go, denials override grants. Casbin values are matched literally,
+// so imported permissions are anchored regular expressions with all meta
+// characters quoted. Lines which cannot be represented are skipped and
+// reported as warnings.
+package casbin
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"github.com/z26100/rbac-go/auth"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Model is a Casbin model matching the policies written by Export.
+const Model = `[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act, eft
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow)) && !some(where (p.eft == deny))
+
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+`
+
+// Warning reports a part of a policy which cannot be converted.
+// Line is 0 if the warning does not refer to a line.
+type Warning struct {
+	Line int
+	Msg  string
+}
+
+func (w Warning) String() string {
+	if w.Line == 0 {
+		return w.Msg
+	}
+	return fmt.Sprintf("line %d: %s", w.Line, w.Msg)
+}
+
+// effects are the policy effects which can be represented.
+var effects = []string{
+	"some(where (p.eft == allow))",
+	"some(where (p.eft == allow)) && !some(where (p.eft == deny))",
+}
+
+// Permission returns the permission granted by `p, sub, obj, act`.
+func Permission(obj, act string) string {
+	return "^" + regexp.QuoteMeta(act) + ":" + regexp.QuoteMeta(obj) + "$"
+}
+
+// Import reads a Casbin policy CSV. The inheritance of the returned policy
+// is bound by SetParents when it is loaded by auth.LoadPolicy.
+func Import(r io.Reader) (*auth.Policy, []Warning, error) {
+	p := &auth.Policy{
+		Roles: make(map[string][]string),
+		Deny:  make(map[string][]string),
+		Inher: make(map[string][]string),
+	}
+	var warnings []Warning
+	role := func(name string) {
+		if _, ok := p.Roles[name]; !ok {
+			p.Roles[name] = []string{}
+		}
+	}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		cr := csv.NewReader(strings.NewReader(text))
+		cr.TrimLeadingSpace = true
+		record, err := cr.Read()
+		if err != nil {
+			return nil, warnings, fmt.Errorf("line %d: %w", line, err)
+		}
+		for i := range record {
+			record[i] = strings.TrimSpace(record[i])
+		}
+		switch record[0] {
+		case "p":
+			eft := "allow"
+			if len(record) == 5 && (record[4] == "allow" || record[4] == "deny") {
+				eft, record = record[4], record[:4]
+			}
+			if len(record) != 4 {
+				warnings = append(warnings, Warning{line, fmt.Sprintf("policy with %d values is not supported, "+
+					"only `p, sub, obj, act, eft` can be represented", len(record)-1)})
+				continue
+			}
+			sub, obj, act := record[1], record[2], record[3]
+			role(sub)
+			if eft == "deny" {
+				p.Deny[sub] = appendUnique(p.Deny[sub], Permission(obj, act))
+			} else {
+				p.Roles[sub] = appendUnique(p.Roles[sub], Permission(obj, act))
+			}
+		case "g":
+			if len(record) != 3 {
+				warnings = append(warnings, Warning{line, "roles with domains are not supported"})
+				continue
+			}
+			child, parent := record[1], record[2]
+			role(child)
+			role(parent)
+			p.Inher[child] = appendUnique(p.Inher[child], parent)
+		default:
+			warnings = append(warnings, Warning{line, fmt.Sprintf("policy type %q is not supported", record[0])})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, warnings, err
+	}
+	return p, warnings, nil
+}
+
+// CheckModel reports the features of a Casbin model file
+// which cannot be represented by imported policies.
+func CheckModel(r io.Reader) ([]Warning, error) {
+	var warnings []Warning
+	scanner := bufio.NewScanner(r)
+	section := ""
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			section = text[1 : len(text)-1]
+			continue
+		}
+		kv := strings.SplitN(text, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		values := strings.Split(value, ",")
+		fields := len(values)
+		switch section {
+		case "policy_definition":
+			if key != "p" {
+				warnings = append(warnings, Warning{line, fmt.Sprintf("policy type %q is not supported", key)})
+			} else if fields != 3 && (fields != 4 || strings.TrimSpace(values[3]) != "eft") {
+				warnings = append(warnings, Warning{line, "only `p = sub, obj, act, eft` can be represented"})
+			}
+		case "role_definition":
+			if key != "g" {
+				warnings = append(warnings, Warning{line, fmt.Sprintf("role definition %q is not supported", key)})
+			} else if fields != 2 {
+				warnings = append(warnings, Warning{line, "roles with domains are not supported"})
+			}
+		case "policy_effect":
+			if !supportedEffect(value) {
+				warnings = append(warnings, Warning{line, fmt.Sprintf("policy effect %q is not supported, "+
+					"denials always override grants", value)})
+			}
+		case "matchers":
+			for _, fc := range []string{"keyMatch", "regexMatch", "globMatch", "ipMatch"} {
+				if strings.Contains(value, fc) {
+					warnings = append(warnings, Warning{line, fmt.Sprintf("%s is not supported, "+
+						"values are imported as literals", fc)})
+				}
+			}
+		}
+	}
+	return warnings, scanner.Err()
+}
+
+// supportedEffect returns true if the policy effect `value` is one
+// of `effects`, ignoring white space.
+func supportedEffect(value string) bool {
+	for _, e := range effects {
+		if strings.Join(strings.Fields(e), "") == strings.Join(strings.Fields(value), "") {
+			return true
+		}
+	}
+	return false
+}
+
+// Export writes `p` as Casbin policy CSV for the model Model.
+// Only permissions of the form `act:obj` without regular expression
+// operators can be represented. Casbin has no equivalent of conditions,
+// attribute conditions, expressions and validities: granted permissions
+// requiring any are skipped, denied ones are exported unconditionally,
+// which denies more rather than less.
+func Export(w io.Writer, p *auth.Policy) ([]Warning, error) {
+	var warnings []Warning
+	bw := bufio.NewWriter(w)
+	cw := csv.NewWriter(bw)
+	for _, rules := range []struct {
+		eft    string
+		what   string
+		values map[string][]string
+		grants map[string]map[string]*auth.Grant
+	}{
+		{"allow", "permission", p.Roles, p.Grants},
+		{"deny", "denied permission", p.Deny, p.DenyGrants},
+	} {
+		for _, name := range sortedKeys(rules.values) {
+			permissions := append([]string{}, rules.values[name]...)
+			sort.Strings(permissions)
+			for _, permission := range permissions {
+				obj, act, ok := split(permission)
+				if !ok {
+					warnings = append(warnings, Warning{Msg: fmt.Sprintf("%s %q of role %q "+
+						"is not a literal `act:obj` and cannot be represented", rules.what, permission, name)})
+					continue
+				}
+				if !strings.HasPrefix(permission, "^") || !strings.HasSuffix(permission, "$") {
+					warnings = append(warnings, Warning{Msg: fmt.Sprintf("%s %q of role %q "+
+						"matches substrings and is exported as an exact match", rules.what, permission, name)})
+				}
+				if g, ok := rules.grants[name][permission]; ok {
+					if rules.eft == "allow" {
+						warnings = append(warnings, Warning{Msg: fmt.Sprintf("permission %q of role %q "+
+							"requires %s and cannot be represented", permission, name, restrictions(g))})
+						continue
+					}
+					warnings = append(warnings, Warning{Msg: fmt.Sprintf("denied permission %q of role %q "+
+						"requires %s and is exported unconditionally", permission, name, restrictions(g))})
+				}
+				if err := cw.Write([]string{"p", name, obj, act, rules.eft}); err != nil {
+					return warnings, err
+				}
+			}
+		}
+	}
+	for _, name := range sortedKeys(p.Inher) {
+		parents := append([]string{}, p.Inher[name]...)
+		sort.Strings(parents)
+		for _, parent := range parents {
+			if err := cw.Write([]string{"g", name, parent}); err != nil {
+				return warnings, err
+			}
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return warnings, err
+	}
+	return warnings, bw.Flush()
+}
+
+// restrictions describes what a grant requires.
+func restrictions(g *auth.Grant) string {
+	var result []string
+	if len(g.Conditions) > 0 {
+		result = append(result, "conditions")
+	}
+	if len(g.When) > 0 {
+		result = append(result, "attribute conditions")
+	}
+	if g.Expr != "" {
+		result = append(result, "an expression")
+	}
+	if g.Validity != nil {
+		result = append(result, "a validity")
+	}
+	return strings.Join(result, ", ")
+}
+
+// split returns object and action of a permission
+// which is a literal `act:obj`, optionally anchored.
+func split(permission string) (obj, act string, ok bool) {
+	body := strings.TrimSuffix(strings.TrimPrefix(permission, "^"), "$")
+	re, err := regexp.Compile(body)
+	if err != nil {
+		return "", "", false
+	}
+	literal, complete := re.LiteralPrefix()
+	if !complete {
+		return "", "", false
+	}
+	i := strings.Index(literal, ":")
+	if i < 0 {
+		return "", "", false
+	}
+	return literal[i+1:], literal[:i], true
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+func sortedKeys(in map[string][]string) []string {
+	result := make([]string, 0, len(in))
+	for k := range in {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,109 @@
+package rbac
+
+import (
+	"sort"
+	"strings"
+)
+
+// CircleError is returned when an inheritance circle is detected.
+// Each path starts and ends with the same role and follows the
+// inheritance from child to parent.
+type CircleError struct {
+	Paths [][]string
+}
+
+func (e *CircleError) Error() string {
+	paths := make([]string, len(e.Paths))
+	for i, path := range e.Paths {
+		paths[i] = strings.Join(path, " -> ")
+	}
+	return ErrFoundCircle.Error() + ": " + strings.Join(paths, "; ")
+}
+
+// Unwrap makes `errors.Is(err, ErrFoundCircle)` work.
+func (e *CircleError) Unwrap() error {
+	return ErrFoundCircle
+}
+
+// checkCircle returns a CircleError if binding `parent` to the role `id`
+// would close an inheritance circle.
+func (rbac *RBAC) checkCircle(id string, parent string) error {
+	if id == parent {
+		return &CircleError{Paths: [][]string{{id, id}}}
+	}
+	if path := rbac.findPath(parent, id); path != nil {
+		return &CircleError{Paths: [][]string{append([]string{id}, path...)}}
+	}
+	return nil
+}
+
+// findPath returns the inheritance path from the role `from`
+// up to its ancestor `to`, or nil if `to` is not an ancestor.
+func (rbac *RBAC) findPath(from string, to string) []string {
+	prev := map[string]string{from: ""}
+	queue := []string{from}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		parents, _ := rbac.backend.GetParents(current)
+		for _, pid := range sortedKeys(parents) {
+			if _, ok := prev[pid]; ok {
+				continue
+			}
+			prev[pid] = current
+			if pid == to {
+				var path []string
+				for id := pid; id != ""; id = prev[id] {
+					path = append([]string{id}, path...)
+				}
+				return path
+			}
+			queue = append(queue, pid)
+		}
+	}
+	return nil
+}
+
+// cycles returns one path for every back edge of the inheritance graph.
+func (rbac *RBAC) cycles() [][]string {
+	var ids []string
+	for id := range rbac.backend.GetRoles() {
+		ids = append(ids, id)
+	}
+	for id := range rbac.backend.GetAllParents() {
+		if _, ok := rbac.backend.GetRole(id); !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	done := make(map[string]struct{}, len(ids))
+	onStack := make(map[string]int)
+	var stack []string
+	var result [][]string
+
+	var visit func(id string)
+	visit = func(id string) {
+		if _, ok := done[id]; ok {
+			return
+		}
+		onStack[id] = len(stack)
+		stack = append(stack, id)
+		parents, _ := rbac.backend.GetParents(id)
+		for _, pid := range sortedKeys(parents) {
+			if i, ok := onStack[pid]; ok {
+				path := append([]string{}, stack[i:]...)
+				result = append(result, append(path, pid))
+				continue
+			}
+			visit(pid)
+		}
+		stack = stack[:len(stack)-1]
+		delete(onStack, id)
+		done[id] = empty
+	}
+	for _, id := range ids {
+		visit(id)
+	}
+	return result
+}
@@ -0,0 +1,83 @@
+// Command rbac works with rbac-go policy files.
+//
+// Usage:
+//
+//	rbac validate [-merge error|override|union] path...
+//
+// validate parses the policy stored in each path, which may be a file,
+// a directory or a glob pattern, and reports every problem, including
+// roles violating separation of duty, cardinality or prerequisite
+// constraints. It exits with status 1 if any policy is invalid.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	auth "github.com/z26100/rbac-go/auth"
+	"io"
+	"os"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return 2
+	}
+	switch args[0] {
+	case "validate":
+		return validate(args[1:], stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		usage(stdout)
+		return 0
+	}
+	fmt.Fprintf(stderr, "rbac: unknown command %q\n", args[0])
+	usage(stderr)
+	return 2
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: rbac validate [-merge error|override|union] path...")
+}
+
+func validate(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	merge := fs.String("merge", string(auth.MergeError), "strategy for roles defined by several documents")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		usage(stderr)
+		return 2
+	}
+	switch s := auth.MergeStrategy(*merge); s {
+	case auth.MergeError, auth.MergeOverride, auth.MergeUnion:
+		auth.SetMergeStrategy(s)
+	default:
+		fmt.Fprintf(stderr, "rbac: unknown merge strategy %q\n", *merge)
+		return 2
+	}
+	status := 0
+	for _, path := range fs.Args() {
+		err := auth.ValidateFile(path)
+		var v *auth.ValidationError
+		switch {
+		case err == nil:
+			fmt.Fprintf(stdout, "%s: ok\n", path)
+			continue
+		case errors.As(err, &v):
+			for _, e := range v.Errors {
+				fmt.Fprintln(stderr, e)
+			}
+		default:
+			fmt.Fprintf(stderr, "%s: %v\n", path, err)
+		}
+		status = 1
+	}
+	return status
+}
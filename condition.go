@@ -0,0 +1,73 @@
+package rbac
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrUnknownCondition occurred if a condition is not registered
+	ErrUnknownCondition = errors.New("unknown condition")
+	conditions          = make(map[string]AssertionFunc)
+	conditionsMutex     sync.RWMutex
+)
+
+// RegisterCondition registers `fc` as the condition `name`. A permission
+// requiring the condition `name` matches only if `fc` returns true, and
+// one requiring `!name` only if `fc` returns false. `fc` is called with
+// the role the permission was requested for while the RBAC instance is
+// locked, so it must not call methods of the instance.
+func RegisterCondition(name string, fc AssertionFunc) {
+	conditionsMutex.Lock()
+	defer conditionsMutex.Unlock()
+	conditions[name] = fc
+}
+
+// HasCondition reports whether the condition `name`,
+// which may be negated by a leading `!`, is registered.
+func HasCondition(name string) bool {
+	_, ok := condition(name)
+	return ok
+}
+
+func condition(name string) (AssertionFunc, bool) {
+	conditionsMutex.RLock()
+	defer conditionsMutex.RUnlock()
+	fc, ok := conditions[strings.TrimPrefix(name, "!")]
+	return fc, ok
+}
+
+// holds reports whether `p` is valid at the time of the request `r` and
+// every condition of `p` holds for it. Unknown conditions never hold.
+// Attribute conditions and expressions referring to missing attributes
+// hold only for denied permissions.
+func (p RBACPermission) holds(rbac *RBAC, r request, deny bool) bool {
+	if !p.Validity.Active(r.now) {
+		return false
+	}
+	for _, name := range p.Conditions {
+		fc, ok := condition(name)
+		if !ok || fc(rbac, r.subject, r.permission) == strings.HasPrefix(name, "!") {
+			return false
+		}
+	}
+	for _, c := range p.When {
+		result, ok := c.evaluate(r.attrs)
+		if ok && !result || !ok && !deny {
+			return false
+		}
+	}
+	if p.Expr != "" {
+		e, err := compiledExpression(p.Expr)
+		if err != nil {
+			return deny
+		}
+		result, err := e.evaluate(&exprEnv{attrs: r.attrs, now: r.now})
+		if err != nil {
+			return deny
+		}
+		return result
+	}
+	return true
+}
@@ -0,0 +1,559 @@
+package rbac
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrConstraintViolated occurred if a change would violate a constraint
+	ErrConstraintViolated = errors.New("constraint violated")
+	// ErrInvalidConstraint occurred if a constraint can't be added
+	ErrInvalidConstraint = errors.New("invalid constraint")
+	// ErrConstraintExist occurred if a constraint shouldn't be found
+	ErrConstraintExist = errors.New("constraint has already existed")
+)
+
+// Constraint restricts the inheritance between roles. Constraints are
+// checked by every RBAC call changing the inheritance; a call which
+// would violate one returns a ConstraintError, CardinalityError or
+// PrerequisiteError and changes nothing. Constraints are stored by the
+// backend, so all instances sharing a MongoDB database enforce them.
+//
+// Subjects are the roles no other role inherits from, like users.
+type Constraint interface {
+	ConstraintName() string
+	// Validate reports a constraint which can never be violated.
+	Validate() error
+	// normalized returns the constraint with role ids instead of names.
+	normalized() Constraint
+	// references returns the roles the constraint refers to.
+	references() []string
+	// check returns an error if the role `id` violates the constraint.
+	check(g *graph, id string) error
+}
+
+// SoDConstraint is a separation of duty constraint: a role may hold,
+// itself or by inheritance, at most `Max` of the roles `Roles`.
+// A Max of 0 is 1, which makes the roles mutually exclusive.
+// Static constraints are enforced when parents are bound, dynamic
+// constraints only limit the roles active at the same time,
+// see CheckActiveRoles.
+type SoDConstraint struct {
+	Name    string
+	Roles   []string
+	Max     int
+	Dynamic bool
+}
+
+func (c SoDConstraint) ConstraintName() string {
+	return c.Name
+}
+
+func (c SoDConstraint) max() int {
+	if c.Max == 0 {
+		return 1
+	}
+	return c.Max
+}
+
+// Validate reports a constraint which can never be violated.
+func (c SoDConstraint) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("%w: missing name", ErrInvalidConstraint)
+	}
+	if err := unique(c.Name, c.Roles); err != nil {
+		return err
+	}
+	if len(c.Roles) < 2 {
+		return fmt.Errorf("%w %q: at least 2 roles are required", ErrInvalidConstraint, c.Name)
+	}
+	if c.Max < 0 || c.max() >= len(c.Roles) {
+		return fmt.Errorf("%w %q: max must be between 1 and %d", ErrInvalidConstraint, c.Name, len(c.Roles)-1)
+	}
+	return nil
+}
+
+func (c SoDConstraint) normalized() Constraint {
+	c.Roles = lowerAll(c.Roles)
+	return c
+}
+
+func (c SoDConstraint) references() []string {
+	return c.Roles
+}
+
+func (c SoDConstraint) check(g *graph, id string) error {
+	if c.Dynamic {
+		return nil
+	}
+	if err := c.violation(id, g.held(id)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// violation returns a ConstraintError if the role `id` holding the
+// roles `held` violates the constraint.
+func (c SoDConstraint) violation(id string, held map[string]struct{}) *ConstraintError {
+	var roles []string
+	for _, rid := range c.Roles {
+		if _, ok := held[rid]; ok {
+			roles = append(roles, rid)
+		}
+	}
+	if len(roles) <= c.max() {
+		return nil
+	}
+	sort.Strings(roles)
+	return &ConstraintError{Constraint: c.Name, Role: id, Roles: roles, Max: c.max()}
+}
+
+// CardinalityConstraint limits the number of subjects which hold the
+// role `Role`, directly or by inheritance, to `Max`.
+type CardinalityConstraint struct {
+	Name string
+	Role string
+	Max  int
+}
+
+func (c CardinalityConstraint) ConstraintName() string {
+	return c.Name
+}
+
+// Validate reports a constraint which can never be violated.
+func (c CardinalityConstraint) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("%w: missing name", ErrInvalidConstraint)
+	}
+	if c.Role == "" {
+		return fmt.Errorf("%w %q: missing role", ErrInvalidConstraint, c.Name)
+	}
+	if c.Max < 1 {
+		return fmt.Errorf("%w %q: max must be at least 1", ErrInvalidConstraint, c.Name)
+	}
+	return nil
+}
+
+func (c CardinalityConstraint) normalized() Constraint {
+	c.Role = strings.ToLower(c.Role)
+	return c
+}
+
+func (c CardinalityConstraint) references() []string {
+	return []string{c.Role}
+}
+
+func (c CardinalityConstraint) check(g *graph, id string) error {
+	if id == c.Role || !g.subject(id) {
+		return nil
+	}
+	if _, ok := g.held(id)[c.Role]; !ok {
+		return nil
+	}
+	var subjects []string
+	for _, did := range g.descendants(c.Role) {
+		if g.subject(did) {
+			subjects = append(subjects, did)
+		}
+	}
+	if len(subjects) <= c.Max {
+		return nil
+	}
+	return &CardinalityError{Constraint: c.Name, Role: c.Role, Subjects: subjects, Max: c.Max}
+}
+
+// PrerequisiteConstraint requires subjects holding the role `Role`
+// to hold the roles `Requires` as well.
+type PrerequisiteConstraint struct {
+	Name     string
+	Role     string
+	Requires []string
+}
+
+func (c PrerequisiteConstraint) ConstraintName() string {
+	return c.Name
+}
+
+// Validate reports a constraint which can never be violated.
+func (c PrerequisiteConstraint) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("%w: missing name", ErrInvalidConstraint)
+	}
+	if c.Role == "" {
+		return fmt.Errorf("%w %q: missing role", ErrInvalidConstraint, c.Name)
+	}
+	if len(c.Requires) == 0 {
+		return fmt.Errorf("%w %q: at least 1 required role is needed", ErrInvalidConstraint, c.Name)
+	}
+	return unique(c.Name, append([]string{c.Role}, c.Requires...))
+}
+
+func (c PrerequisiteConstraint) normalized() Constraint {
+	c.Role = strings.ToLower(c.Role)
+	c.Requires = lowerAll(c.Requires)
+	return c
+}
+
+func (c PrerequisiteConstraint) references() []string {
+	return append([]string{c.Role}, c.Requires...)
+}
+
+func (c PrerequisiteConstraint) check(g *graph, id string) error {
+	if id == c.Role || !g.subject(id) {
+		return nil
+	}
+	held := g.held(id)
+	if _, ok := held[c.Role]; !ok {
+		return nil
+	}
+	var missing []string
+	for _, rid := range c.Requires {
+		if _, ok := held[rid]; !ok {
+			missing = append(missing, rid)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	return &PrerequisiteError{Constraint: c.Name, Role: c.Role, Subject: id, Missing: missing}
+}
+
+// ConstraintError is returned when the role `Role` holds, or would
+// hold, more of the roles of a SoDConstraint than it allows.
+type ConstraintError struct {
+	Constraint string
+	Role       string
+	// Roles lists the roles of the constraint held by the role.
+	Roles []string
+	Max   int
+}
+
+func (e *ConstraintError) Error() string {
+	return fmt.Sprintf("%v: %q: role %q holds %s, at most %d allowed",
+		ErrConstraintViolated, e.Constraint, e.Role, quoteAll(e.Roles), e.Max)
+}
+
+// Unwrap makes `errors.Is(err, ErrConstraintViolated)` work.
+func (e *ConstraintError) Unwrap() error {
+	return ErrConstraintViolated
+}
+
+// CardinalityError is returned when more subjects hold, or would hold,
+// the role `Role` than a CardinalityConstraint allows.
+type CardinalityError struct {
+	Constraint string
+	Role       string
+	// Subjects lists the subjects holding the role.
+	Subjects []string
+	Max      int
+}
+
+func (e *CardinalityError) Error() string {
+	return fmt.Sprintf("%v: %q: role %q is held by %s, at most %d allowed",
+		ErrConstraintViolated, e.Constraint, e.Role, quoteAll(e.Subjects), e.Max)
+}
+
+// Unwrap makes `errors.Is(err, ErrConstraintViolated)` work.
+func (e *CardinalityError) Unwrap() error {
+	return ErrConstraintViolated
+}
+
+// PrerequisiteError is returned when the subject `Subject` holds, or
+// would hold, the role `Role` without the roles it requires.
+type PrerequisiteError struct {
+	Constraint string
+	Role       string
+	Subject    string
+	// Missing lists the required roles the subject doesn't hold.
+	Missing []string
+}
+
+func (e *PrerequisiteError) Error() string {
+	return fmt.Sprintf("%v: %q: role %q holds %q without %s",
+		ErrConstraintViolated, e.Constraint, e.Subject, e.Role, quoteAll(e.Missing))
+}
+
+// Unwrap makes `errors.Is(err, ErrConstraintViolated)` work.
+func (e *PrerequisiteError) Unwrap() error {
+	return ErrConstraintViolated
+}
+
+// AddConstraint adds the constraint `c`. Its roles must exist,
+// and the current roles must not violate it.
+func (rbac *RBAC) AddConstraint(c Constraint) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	c = c.normalized()
+	rbac.backend.Lock()
+	defer rbac.backend.Unlock()
+	for _, existing := range rbac.backend.GetConstraints() {
+		if existing.ConstraintName() == c.ConstraintName() {
+			return ErrConstraintExist
+		}
+	}
+	for _, id := range c.references() {
+		if _, ok := rbac.backend.GetRole(id); !ok {
+			return ErrRoleNotExist
+		}
+	}
+	g := rbac.graph()
+	for _, id := range rbac.roleIds() {
+		if err := c.check(g, id); err != nil {
+			return err
+		}
+	}
+	return rbac.backend.SetConstraint(c)
+}
+
+// RemoveConstraint removes the constraint named `name`, if any.
+func (rbac *RBAC) RemoveConstraint(name string) error {
+	rbac.backend.Lock()
+	defer rbac.backend.Unlock()
+	return rbac.backend.DeleteConstraint(name)
+}
+
+// Constraints returns the constraints sorted by name.
+func (rbac *RBAC) Constraints() []Constraint {
+	rbac.backend.RLock()
+	defer rbac.backend.RUnlock()
+	return rbac.sortedConstraints()
+}
+
+func (rbac *RBAC) sortedConstraints() []Constraint {
+	result := append([]Constraint{}, rbac.backend.GetConstraints()...)
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ConstraintName() < result[j].ConstraintName()
+	})
+	return result
+}
+
+// Violations returns an error for every violation of a constraint,
+// sorted by role and constraint. A violation found for several roles,
+// like too many subjects holding a role, is returned once.
+func (rbac *RBAC) Violations() []error {
+	rbac.backend.RLock()
+	defer rbac.backend.RUnlock()
+	var result []error
+	seen := make(map[string]struct{})
+	constraints := rbac.sortedConstraints()
+	g := rbac.graph()
+	for _, id := range rbac.roleIds() {
+		for _, c := range constraints {
+			err := c.check(g, id)
+			if err == nil {
+				continue
+			}
+			if _, ok := seen[err.Error()]; ok {
+				continue
+			}
+			seen[err.Error()] = empty
+			result = append(result, err)
+		}
+	}
+	return result
+}
+
+// CheckActiveRoles returns a ConstraintError if the roles `active`,
+// together with the roles they inherit from, violate a SoDConstraint.
+// Roles which don't exist are ignored. Session.Activate checks the
+// roles of a session by it.
+func (rbac *RBAC) CheckActiveRoles(subject string, active []string) error {
+	rbac.backend.RLock()
+	defer rbac.backend.RUnlock()
+	g := rbac.graph()
+	held := make(map[string]struct{})
+	for _, id := range active {
+		if _, ok := rbac.backend.GetRole(id); !ok {
+			continue
+		}
+		for hid := range g.held(id) {
+			held[hid] = empty
+		}
+	}
+	for _, c := range rbac.sortedConstraints() {
+		if sod, ok := c.(SoDConstraint); ok {
+			if err := sod.violation(subject, held); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkConstraints returns an error if any of the roles `ids` or their
+// descendants violates a constraint once `change` is applied.
+func (rbac *RBAC) checkConstraints(ids []string, change func(g *graph)) error {
+	constraints := rbac.sortedConstraints()
+	if len(constraints) == 0 {
+		return nil
+	}
+	g := rbac.graph()
+	affected := make(map[string]struct{})
+	for _, id := range ids {
+		affected[id] = empty
+		for _, did := range g.descendants(id) {
+			affected[did] = empty
+		}
+	}
+	change(g)
+	for _, did := range sortedKeys(affected) {
+		if _, ok := g.deleted[did]; ok {
+			continue
+		}
+		for _, c := range constraints {
+			if err := c.check(g, did); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// graph is the inheritance stored by the backend with pending changes.
+type graph struct {
+	rbac    *RBAC
+	added   map[string]map[string]struct{}
+	removed map[string]map[string]struct{}
+	deleted map[string]struct{}
+}
+
+func (rbac *RBAC) graph() *graph {
+	return &graph{
+		rbac:    rbac,
+		added:   make(map[string]map[string]struct{}),
+		removed: make(map[string]map[string]struct{}),
+		deleted: make(map[string]struct{}),
+	}
+}
+
+// bind adds the parent `pid` to the role `id`.
+func (g *graph) bind(id, pid string) {
+	delete(g.removed[id], pid)
+	if g.added[id] == nil {
+		g.added[id] = make(map[string]struct{})
+	}
+	g.added[id][pid] = empty
+}
+
+// unbind removes the parent `pid` from the role `id`.
+func (g *graph) unbind(id, pid string) {
+	delete(g.added[id], pid)
+	if g.removed[id] == nil {
+		g.removed[id] = make(map[string]struct{})
+	}
+	g.removed[id][pid] = empty
+}
+
+// remove removes the role `id` and its inheritance.
+func (g *graph) remove(id string) {
+	g.deleted[id] = empty
+}
+
+func (g *graph) parents(id string) (map[string]struct{}, bool) {
+	parents, _ := g.rbac.backend.GetParents(id)
+	return g.apply(id, parents, g.added[id], g.removed[id])
+}
+
+func (g *graph) children(id string) (map[string]struct{}, bool) {
+	children, _ := g.rbac.backend.GetChildren(id)
+	return g.apply(id, children, inverse(g.added, id), inverse(g.removed, id))
+}
+
+// apply returns the roles `related` to the role `id` with the pending
+// changes `added` and `removed` and without deleted roles.
+func (g *graph) apply(id string, related, added, removed map[string]struct{}) (map[string]struct{}, bool) {
+	if _, ok := g.deleted[id]; ok {
+		return nil, false
+	}
+	result := make(map[string]struct{}, len(related)+len(added))
+	for rid := range related {
+		result[rid] = empty
+	}
+	for rid := range added {
+		result[rid] = empty
+	}
+	for rid := range result {
+		_, unbound := removed[rid]
+		_, deleted := g.deleted[rid]
+		if unbound || deleted {
+			delete(result, rid)
+		}
+	}
+	return result, len(result) > 0
+}
+
+// inverse returns the children of the role `id` among the `edges`
+// mapping children to parents.
+func inverse(edges map[string]map[string]struct{}, id string) map[string]struct{} {
+	result := make(map[string]struct{})
+	for child, parents := range edges {
+		if _, ok := parents[id]; ok {
+			result[child] = empty
+		}
+	}
+	return result
+}
+
+// held returns the role `id` and all roles it inherits from.
+func (g *graph) held(id string) map[string]struct{} {
+	result := map[string]struct{}{id: empty}
+	for _, hid := range g.rbac.traverse(id, g.parents) {
+		result[hid] = empty
+	}
+	return result
+}
+
+// descendants returns all roles inheriting from the role `id`.
+func (g *graph) descendants(id string) []string {
+	return g.rbac.traverse(id, g.children)
+}
+
+// subject reports whether no role inherits from the role `id`.
+func (g *graph) subject(id string) bool {
+	children, _ := g.children(id)
+	return len(children) == 0
+}
+
+// roleIds returns the sorted ids of all roles.
+func (rbac *RBAC) roleIds() []string {
+	roles := rbac.backend.GetRoles()
+	result := make([]string, 0, len(roles))
+	for id := range roles {
+		result = append(result, id)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// unique returns an error if a role is listed twice by a constraint.
+func unique(name string, roles []string) error {
+	seen := make(map[string]struct{}, len(roles))
+	for _, id := range roles {
+		if _, ok := seen[strings.ToLower(id)]; ok {
+			return fmt.Errorf("%w %q: role %q is listed twice", ErrInvalidConstraint, name, id)
+		}
+		seen[strings.ToLower(id)] = empty
+	}
+	return nil
+}
+
+func quoteAll(in []string) string {
+	quoted := make([]string, len(in))
+	for i, s := range in {
+		quoted[i] = fmt.Sprintf("%q", s)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func lowerAll(in []string) []string {
+	result := make([]string, len(in))
+	for i, s := range in {
+		result[i] = strings.ToLower(s)
+	}
+	return result
+}
@@ -0,0 +1,213 @@
+package rbac
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mikespook/gorbac"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Changeset describes the changes turning one RBAC state into another.
+type Changeset struct {
+	AddedRoles     []RoleChange `json:"addedRoles,omitempty"`
+	RemovedRoles   []string     `json:"removedRoles,omitempty"`
+	ChangedRoles   []RoleChange `json:"changedRoles,omitempty"`
+	AddedParents   []Edge       `json:"addedParents,omitempty"`
+	RemovedParents []Edge       `json:"removedParents,omitempty"`
+}
+
+// RoleChange lists the permissions added to or removed from a role.
+// For an added role, all of its permissions are listed as added.
+type RoleChange struct {
+	ID                 string   `json:"id"`
+	Name               string   `json:"name"`
+	Source             string   `json:"source,omitempty"`
+	AddedPermissions   []string `json:"addedPermissions,omitempty"`
+	RemovedPermissions []string `json:"removedPermissions,omitempty"`
+}
+
+// Edge binds the role `Child` to its parent `Parent`.
+type Edge struct {
+	Child  string `json:"child"`
+	Parent string `json:"parent"`
+}
+
+type roleState struct {
+	name        string
+	source      string
+	permissions map[string]struct{}
+	parents     map[string]struct{}
+}
+
+// Diff returns the changes required to turn `from` into `to`.
+func Diff(from, to *RBAC) (*Changeset, error) {
+	src, err := snapshot(from)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := snapshot(to)
+	if err != nil {
+		return nil, err
+	}
+	cs := &Changeset{}
+	for _, id := range sortedRoleIds(dst) {
+		d := dst[id]
+		s, ok := src[id]
+		if !ok {
+			cs.AddedRoles = append(cs.AddedRoles, RoleChange{
+				ID:               id,
+				Name:             d.name,
+				Source:           d.source,
+				AddedPermissions: sortedKeys(d.permissions),
+			})
+			continue
+		}
+		added, removed := compareSets(s.permissions, d.permissions)
+		if len(added) > 0 || len(removed) > 0 {
+			cs.ChangedRoles = append(cs.ChangedRoles, RoleChange{
+				ID:                 id,
+				Name:               d.name,
+				AddedPermissions:   added,
+				RemovedPermissions: removed,
+			})
+		}
+	}
+	for _, id := range sortedRoleIds(src) {
+		if _, ok := dst[id]; !ok {
+			cs.RemovedRoles = append(cs.RemovedRoles, id)
+		}
+	}
+	ids := make(map[string]struct{})
+	for id := range src {
+		ids[id] = empty
+	}
+	for id := range dst {
+		ids[id] = empty
+	}
+	for _, id := range sortedKeys(ids) {
+		var before, after map[string]struct{}
+		if s, ok := src[id]; ok {
+			before = s.parents
+		}
+		if d, ok := dst[id]; ok {
+			after = d.parents
+		}
+		added, removed := compareSets(before, after)
+		for _, pid := range added {
+			cs.AddedParents = append(cs.AddedParents, Edge{Child: id, Parent: pid})
+		}
+		for _, pid := range removed {
+			cs.RemovedParents = append(cs.RemovedParents, Edge{Child: id, Parent: pid})
+		}
+	}
+	return cs, nil
+}
+
+// Empty reports whether the changeset contains no changes.
+func (cs *Changeset) Empty() bool {
+	return len(cs.AddedRoles) == 0 && len(cs.RemovedRoles) == 0 && len(cs.ChangedRoles) == 0 &&
+		len(cs.AddedParents) == 0 && len(cs.RemovedParents) == 0
+}
+
+// String renders the changeset as human-readable text.
+func (cs *Changeset) String() string {
+	var sb strings.Builder
+	cs.WriteText(&sb)
+	return sb.String()
+}
+
+// WriteText writes the changeset as human-readable text, one change per line.
+// Added items are prefixed by `+`, removed ones by `-` and changed roles by `~`.
+func (cs *Changeset) WriteText(w io.Writer) error {
+	var lines []string
+	if cs.Empty() {
+		lines = append(lines, "no changes")
+	}
+	for _, r := range cs.AddedRoles {
+		lines = append(lines, "+ role "+r.ID)
+		for _, p := range r.AddedPermissions {
+			lines = append(lines, "    + permission "+p)
+		}
+	}
+	for _, id := range cs.RemovedRoles {
+		lines = append(lines, "- role "+id)
+	}
+	for _, r := range cs.ChangedRoles {
+		lines = append(lines, "~ role "+r.ID)
+		for _, p := range r.AddedPermissions {
+			lines = append(lines, "    + permission "+p)
+		}
+		for _, p := range r.RemovedPermissions {
+			lines = append(lines, "    - permission "+p)
+		}
+	}
+	for _, e := range cs.AddedParents {
+		lines = append(lines, fmt.Sprintf("+ parent %s -> %s", e.Child, e.Parent))
+	}
+	for _, e := range cs.RemovedParents {
+		lines = append(lines, fmt.Sprintf("- parent %s -> %s", e.Child, e.Parent))
+	}
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+// WriteJSON writes the changeset as indented JSON.
+func (cs *Changeset) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(cs)
+}
+
+func snapshot(rbac *RBAC) (map[string]*roleState, error) {
+	result := make(map[string]*roleState)
+	err := Walk(rbac, func(role gorbac.Role, parents []string) error {
+		state := &roleState{
+			name:        role.ID(),
+			permissions: make(map[string]struct{}),
+			parents:     make(map[string]struct{}),
+		}
+		if r, ok := role.(*RBACRole); ok {
+			state.name = r.Name
+			state.source = r.Source
+		}
+		if r, ok := role.(interface{ GetPermissions() []gorbac.Permission }); ok {
+			for _, p := range r.GetPermissions() {
+				state.permissions[p.ID()] = empty
+			}
+		}
+		for _, pid := range parents {
+			state.parents[pid] = empty
+		}
+		result[role.ID()] = state
+		return nil
+	})
+	return result, err
+}
+
+func sortedRoleIds(in map[string]*roleState) []string {
+	var result []string
+	for id := range in {
+		result = append(result, id)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// compareSets returns the sorted keys only in `after` and only in `before`.
+func compareSets(before, after map[string]struct{}) (added, removed []string) {
+	for k := range after {
+		if _, ok := before[k]; !ok {
+			added = append(added, k)
+		}
+	}
+	for k := range before {
+		if _, ok := after[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return
+}
@@ -0,0 +1,810 @@
+package rbac
+
+import (
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Expressions are conditions of permissions like
+//
+//	resource.owner == subject.id && environment.region in ["eu", "us"]
+//
+// They compare attributes, addressed by dotted paths, with literals
+// (strings, numbers, booleans and lists) or other attributes using
+// `==`, `!=`, `<`, `<=`, `>`, `>=` and `in`, and combine the results
+// with `&&`, `||` and `!`. The functions
+//
+//	startsWith(s, prefix), endsWith(s, suffix), lower(s)
+//	now(), time("2006-01-02T15:04:05Z"), hour(t), weekday(t)
+//
+// work on strings and times; weekday returns 0 for Sunday.
+// Expressions are type-checked when they are compiled. Their size is
+// limited and they contain no loops, so evaluation is bounded by the
+// size of the expression and of the attributes.
+
+const (
+	// MaxExpressionLength is the maximum length of an expression in bytes.
+	MaxExpressionLength = 1024
+	// MaxExpressionDepth is the maximum nesting depth of an expression.
+	MaxExpressionDepth = 32
+	// MaxExpressionNodes is the maximum number of operands and operators.
+	MaxExpressionNodes = 256
+	// maxCachedExpressions is the maximum number of compiled expressions
+	// compiledExpression keeps.
+	maxCachedExpressions = 1024
+)
+
+var (
+	// ErrInvalidExpression occurred if an expression can't be compiled
+	ErrInvalidExpression = errors.New("invalid expression")
+	expressions          = make(map[string]*Expression)
+	expressionsMux       sync.RWMutex
+)
+
+// Expression is a compiled expression.
+type Expression struct {
+	src  string
+	root exprNode
+}
+
+// CompileExpression parses and type-checks `src`.
+func CompileExpression(src string) (*Expression, error) {
+	if len(src) > MaxExpressionLength {
+		return nil, fmt.Errorf("%w: longer than %d bytes", ErrInvalidExpression, MaxExpressionLength)
+	}
+	p := &exprParser{src: src}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	root, err := p.or(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+	if t := root.typ(); t != typeBool && t != typeAny {
+		return nil, fmt.Errorf("%w: %q is a %s, not a boolean", ErrInvalidExpression, src, t)
+	}
+	return &Expression{src: src, root: root}, nil
+}
+
+// compiledExpression returns the compiled expression `src`,
+// compiling it once. If the cache is full, an arbitrary expression
+// is evicted, so it stays bounded however many expressions are used.
+func compiledExpression(src string) (*Expression, error) {
+	expressionsMux.RLock()
+	e, ok := expressions[src]
+	expressionsMux.RUnlock()
+	if ok {
+		return e, nil
+	}
+	e, err := CompileExpression(src)
+	if err != nil {
+		return nil, err
+	}
+	expressionsMux.Lock()
+	if len(expressions) >= maxCachedExpressions {
+		for key := range expressions {
+			delete(expressions, key)
+			break
+		}
+	}
+	expressions[src] = e
+	expressionsMux.Unlock()
+	return e, nil
+}
+
+func (e *Expression) String() string {
+	return e.src
+}
+
+// Evaluate evaluates the expression against `attrs`. An error is returned
+// if an attribute is missing or has a type the expression can't use.
+func (e *Expression) Evaluate(attrs Attributes) (bool, error) {
+	return e.evaluate(&exprEnv{attrs: attrs, now: time.Now()})
+}
+
+// exprEnv is what an expression is evaluated against;
+// `now` is the time returned by now().
+type exprEnv struct {
+	attrs Attributes
+	now   time.Time
+}
+
+func (e *Expression) evaluate(env *exprEnv) (bool, error) {
+	v, err := e.root.eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%q is not a boolean", e.src)
+	}
+	return b, nil
+}
+
+// exprType is the static type of an expression node.
+type exprType int
+
+const (
+	typeAny exprType = iota
+	typeBool
+	typeNumber
+	typeString
+	typeTime
+	typeList
+)
+
+func (t exprType) String() string {
+	return [...]string{"value", "boolean", "number", "string", "time", "list"}[t]
+}
+
+// ordered reports whether values of the types `a` and `b`
+// may be compared by order.
+func ordered(a, b exprType) bool {
+	if a == typeAny || b == typeAny {
+		return a != typeBool && a != typeList && b != typeBool && b != typeList
+	}
+	return a == b && (a == typeNumber || a == typeString || a == typeTime)
+}
+
+type exprNode interface {
+	typ() exprType
+	eval(env *exprEnv) (interface{}, error)
+}
+
+type literalNode struct {
+	value interface{}
+	t     exprType
+}
+
+func (n *literalNode) typ() exprType {
+	return n.t
+}
+
+func (n *literalNode) eval(*exprEnv) (interface{}, error) {
+	return n.value, nil
+}
+
+type pathNode struct {
+	path string
+}
+
+func (n *pathNode) typ() exprType {
+	return typeAny
+}
+
+func (n *pathNode) eval(env *exprEnv) (interface{}, error) {
+	v, ok := env.attrs.Lookup(n.path)
+	if !ok {
+		return nil, fmt.Errorf("missing attribute %q", n.path)
+	}
+	return normalize(v), nil
+}
+
+type listNode struct {
+	items []exprNode
+}
+
+func (n *listNode) typ() exprType {
+	return typeList
+}
+
+func (n *listNode) eval(env *exprEnv) (interface{}, error) {
+	result := make([]interface{}, len(n.items))
+	for i, item := range n.items {
+		v, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = v
+	}
+	return result, nil
+}
+
+type notNode struct {
+	operand exprNode
+}
+
+func (n *notNode) typ() exprType {
+	return typeBool
+}
+
+func (n *notNode) eval(env *exprEnv) (interface{}, error) {
+	v, err := evalBool(n.operand, env)
+	return !v, err
+}
+
+type logicalNode struct {
+	and         bool
+	left, right exprNode
+}
+
+func (n *logicalNode) typ() exprType {
+	return typeBool
+}
+
+func (n *logicalNode) eval(env *exprEnv) (interface{}, error) {
+	left, err := evalBool(n.left, env)
+	if err != nil {
+		return nil, err
+	}
+	if left != n.and {
+		return left, nil
+	}
+	return evalBool(n.right, env)
+}
+
+type compareNode struct {
+	op          string
+	left, right exprNode
+}
+
+func (n *compareNode) typ() exprType {
+	return typeBool
+}
+
+func (n *compareNode) eval(env *exprEnv) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return exprEqual(left, right), nil
+	case "!=":
+		return !exprEqual(left, right), nil
+	case "in":
+		list, ok := right.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("right operand of in is no list")
+		}
+		for _, item := range list {
+			if exprEqual(left, item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	c, err := exprCompare(left, right)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	}
+	return c >= 0, nil
+}
+
+var comparisons = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+type callNode struct {
+	name string
+	args []exprNode
+}
+
+// exprFuncs lists the parameter and the result types of the functions.
+var exprFuncs = map[string]struct {
+	params []exprType
+	result exprType
+}{
+	"startsWith": {[]exprType{typeString, typeString}, typeBool},
+	"endsWith":   {[]exprType{typeString, typeString}, typeBool},
+	"lower":      {[]exprType{typeString}, typeString},
+	"now":        {nil, typeTime},
+	"time":       {[]exprType{typeString}, typeTime},
+	"hour":       {[]exprType{typeTime}, typeNumber},
+	"weekday":    {[]exprType{typeTime}, typeNumber},
+}
+
+func (n *callNode) typ() exprType {
+	return exprFuncs[n.name].result
+}
+
+func (n *callNode) eval(env *exprEnv) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		if !hasType(v, exprFuncs[n.name].params[i]) {
+			return nil, fmt.Errorf("argument %d of %s is no %s", i+1, n.name, exprFuncs[n.name].params[i])
+		}
+		args[i] = v
+	}
+	switch n.name {
+	case "startsWith":
+		return strings.HasPrefix(args[0].(string), args[1].(string)), nil
+	case "endsWith":
+		return strings.HasSuffix(args[0].(string), args[1].(string)), nil
+	case "lower":
+		return strings.ToLower(args[0].(string)), nil
+	case "now":
+		return env.now, nil
+	case "time":
+		return time.Parse(time.RFC3339, args[0].(string))
+	case "hour":
+		return float64(args[0].(time.Time).Hour()), nil
+	}
+	return float64(args[0].(time.Time).Weekday()), nil
+}
+
+func evalBool(n exprNode, env *exprEnv) (bool, error) {
+	v, err := n.eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("operand is no boolean")
+	}
+	return b, nil
+}
+
+// normalize converts attribute values to the types expressions use.
+// Lists whose items are normalized already are returned as they are.
+func normalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case int:
+		return float64(t)
+	case int8:
+		return float64(t)
+	case int16:
+		return float64(t)
+	case int32:
+		return float64(t)
+	case int64:
+		return float64(t)
+	case uint:
+		return float64(t)
+	case uint8:
+		return float64(t)
+	case uint16:
+		return float64(t)
+	case uint32:
+		return float64(t)
+	case uint64:
+		return float64(t)
+	case float32:
+		return float64(t)
+	case []string:
+		result := make([]interface{}, len(t))
+		for i, s := range t {
+			result[i] = s
+		}
+		return result
+	case primitive.A:
+		return normalize([]interface{}(t))
+	case []interface{}:
+		if isNormalized(t) {
+			return t
+		}
+		result := make([]interface{}, len(t))
+		for i, item := range t {
+			result[i] = normalize(item)
+		}
+		return result
+	}
+	return v
+}
+
+// isNormalized returns true if `v` has one of the types normalize
+// converts values to.
+func isNormalized(v interface{}) bool {
+	switch t := v.(type) {
+	case bool, float64, string, time.Time, nil:
+		return true
+	case []interface{}:
+		for _, item := range t {
+			if !isNormalized(item) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func hasType(v interface{}, t exprType) bool {
+	switch v.(type) {
+	case bool:
+		return t == typeBool
+	case float64:
+		return t == typeNumber
+	case string:
+		return t == typeString
+	case time.Time:
+		return t == typeTime
+	case []interface{}:
+		return t == typeList
+	}
+	return false
+}
+
+// exprEqual compares normalized values, lists element by element.
+func exprEqual(a, b interface{}) bool {
+	switch x := a.(type) {
+	case time.Time:
+		y, ok := b.(time.Time)
+		return ok && x.Equal(y)
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !exprEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case bool, float64, string, nil:
+		return a == b
+	}
+	return false
+}
+
+func exprCompare(a, b interface{}) (int, error) {
+	switch x := a.(type) {
+	case float64:
+		if y, ok := b.(float64); ok {
+			return compareOrdered(x < y, x > y), nil
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return compareOrdered(x < y, x > y), nil
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			return compareOrdered(x.Before(y), x.After(y)), nil
+		}
+	}
+	return 0, fmt.Errorf("can't compare %v and %v", a, b)
+}
+
+func compareOrdered(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.value)
+}
+
+// exprParser is a recursive descent parser which type-checks
+// the nodes while it builds them.
+type exprParser struct {
+	src   string
+	pos   int
+	tok   token
+	nodes int
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return p.errorAt(p.tok.pos, format, args...)
+}
+
+func (p *exprParser) errorAt(pos int, format string, args ...interface{}) error {
+	return fmt.Errorf("%w: column %d: %s", ErrInvalidExpression, pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *exprParser) next() error {
+	for p.pos < len(p.src) && strings.ContainsRune(" \t\r\n", rune(p.src[p.pos])) {
+		p.pos++
+	}
+	start := p.pos
+	p.tok = token{kind: tokEOF, pos: start}
+	if p.pos >= len(p.src) {
+		return nil
+	}
+	c := p.src[p.pos]
+	switch {
+	case isIdentByte(c, true):
+		for p.pos < len(p.src) && isIdentByte(p.src[p.pos], false) {
+			p.pos++
+		}
+		p.tok = token{kind: tokIdent, value: p.src[start:p.pos], pos: start}
+	case c >= '0' && c <= '9' || c == '-':
+		p.pos++
+		for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		p.tok = token{kind: tokNumber, value: p.src[start:p.pos], pos: start}
+	case c == '"' || c == '\'':
+		p.pos++
+		var b strings.Builder
+		for {
+			if p.pos >= len(p.src) {
+				return p.errorAt(start, "unterminated string")
+			}
+			if p.src[p.pos] == c {
+				p.pos++
+				break
+			}
+			if p.src[p.pos] == '\\' && p.pos+1 < len(p.src) {
+				p.pos++
+			}
+			b.WriteByte(p.src[p.pos])
+			p.pos++
+		}
+		p.tok = token{kind: tokString, value: b.String(), pos: start}
+	default:
+		for _, op := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", "[", "]", ",", "."} {
+			if strings.HasPrefix(p.src[p.pos:], op) {
+				p.pos += len(op)
+				p.tok = token{kind: tokOp, value: op, pos: start}
+				return nil
+			}
+		}
+		return p.errorf("unexpected character %q", c)
+	}
+	return nil
+}
+
+func isIdentByte(c byte, first bool) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || !first && c >= '0' && c <= '9'
+}
+
+func (p *exprParser) isOp(op string) bool {
+	return p.tok.kind == tokOp && p.tok.value == op
+}
+
+func (p *exprParser) expect(op string) error {
+	if !p.isOp(op) {
+		return p.errorf("expected %q, found %s", op, p.tok)
+	}
+	return p.next()
+}
+
+// enter counts a node and checks the limits.
+func (p *exprParser) enter(depth int) error {
+	p.nodes++
+	if p.nodes > MaxExpressionNodes {
+		return p.errorf("more than %d nodes", MaxExpressionNodes)
+	}
+	if depth > MaxExpressionDepth {
+		return p.errorf("nested deeper than %d", MaxExpressionDepth)
+	}
+	return nil
+}
+
+func (p *exprParser) or(depth int) (exprNode, error) {
+	return p.logical(depth, "||", p.and)
+}
+
+func (p *exprParser) and(depth int) (exprNode, error) {
+	return p.logical(depth, "&&", p.not)
+}
+
+func (p *exprParser) logical(depth int, op string, operand func(int) (exprNode, error)) (exprNode, error) {
+	left, err := operand(depth + 1)
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp(op) {
+		if err := p.enter(depth); err != nil {
+			return nil, err
+		}
+		pos := p.tok.pos
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := operand(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range []exprNode{left, right} {
+			if t := n.typ(); t != typeBool && t != typeAny {
+				return nil, p.errorAt(pos, "operands of %s must be booleans, not a %s", op, t)
+			}
+		}
+		left = &logicalNode{and: op == "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) not(depth int) (exprNode, error) {
+	if !p.isOp("!") {
+		return p.compare(depth)
+	}
+	if err := p.enter(depth); err != nil {
+		return nil, err
+	}
+	pos := p.tok.pos
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	operand, err := p.not(depth + 1)
+	if err != nil {
+		return nil, err
+	}
+	if t := operand.typ(); t != typeBool && t != typeAny {
+		return nil, p.errorAt(pos, "operand of ! must be a boolean, not a %s", t)
+	}
+	return &notNode{operand: operand}, nil
+}
+
+func (p *exprParser) compare(depth int) (exprNode, error) {
+	left, err := p.primary(depth + 1)
+	if err != nil {
+		return nil, err
+	}
+	op := p.tok.value
+	if p.tok.kind == tokIdent && op == "in" || p.tok.kind == tokOp && comparisons[op] {
+		if err := p.enter(depth); err != nil {
+			return nil, err
+		}
+		pos := p.tok.pos
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.primary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		lt, rt := left.typ(), right.typ()
+		switch op {
+		case "in":
+			if rt != typeList && rt != typeAny {
+				return nil, p.errorAt(pos, "right operand of in must be a list, not a %s", rt)
+			}
+		case "==", "!=":
+			if lt != rt && lt != typeAny && rt != typeAny {
+				return nil, p.errorAt(pos, "can't compare a %s with a %s", lt, rt)
+			}
+		default:
+			if !ordered(lt, rt) {
+				return nil, p.errorAt(pos, "can't order a %s and a %s", lt, rt)
+			}
+		}
+		left = &compareNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) primary(depth int) (exprNode, error) {
+	if err := p.enter(depth); err != nil {
+		return nil, err
+	}
+	tok := p.tok
+	switch {
+	case tok.kind == tokString:
+		return &literalNode{value: tok.value, t: typeString}, p.next()
+	case tok.kind == tokNumber:
+		f, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %s", tok)
+		}
+		return &literalNode{value: f, t: typeNumber}, p.next()
+	case tok.kind == tokIdent && (tok.value == "true" || tok.value == "false"):
+		return &literalNode{value: tok.value == "true", t: typeBool}, p.next()
+	case tok.kind == tokIdent:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.isOp("(") {
+			return p.call(tok, depth)
+		}
+		path := tok.value
+		for p.isOp(".") {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if p.tok.kind != tokIdent {
+				return nil, p.errorf("expected attribute name, found %s", p.tok)
+			}
+			path += "." + p.tok.value
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		}
+		return &pathNode{path: path}, nil
+	case p.isOp("("):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		n, err := p.or(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(")")
+	case p.isOp("["):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		list := &listNode{}
+		for !p.isOp("]") {
+			if len(list.items) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			item, err := p.primary(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			list.items = append(list.items, item)
+		}
+		return list, p.next()
+	}
+	return nil, p.errorf("unexpected %s", tok)
+}
+
+func (p *exprParser) call(name token, depth int) (exprNode, error) {
+	f, ok := exprFuncs[name.value]
+	if !ok {
+		return nil, p.errorAt(name.pos, "unknown function %q", name.value)
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	n := &callNode{name: name.value}
+	for !p.isOp(")") {
+		if len(n.args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.or(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		n.args = append(n.args, arg)
+	}
+	if len(n.args) != len(f.params) {
+		return nil, p.errorAt(name.pos, "%s takes %d arguments, not %d", name.value, len(f.params), len(n.args))
+	}
+	for i, arg := range n.args {
+		if t := arg.typ(); t != f.params[i] && t != typeAny {
+			return nil, p.errorAt(name.pos, "argument %d of %s must be a %s, not a %s", i+1, name.value, f.params[i], t)
+		}
+	}
+	if name.value == "time" {
+		if lit, ok := n.args[0].(*literalNode); ok {
+			if _, err := time.Parse(time.RFC3339, lit.value.(string)); err != nil {
+				return nil, p.errorAt(name.pos, "%v", err)
+			}
+		}
+	}
+	return n, p.next()
+}
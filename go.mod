@@ -1,16 +1,12 @@
 module github.com/z26100/rbac-go
 
-go 1.15
+go 1.16
 
 require (
-	github.com/aws/aws-sdk-go v1.37.3 // indirect
-	github.com/golang/snappy v0.0.2 // indirect
-	github.com/klauspost/compress v1.11.7 // indirect
+	github.com/BurntSushi/toml v1.3.2
+	github.com/hashicorp/hcl v1.0.0
 	github.com/mikespook/gorbac v2.1.0+incompatible
-	github.com/z26100/log-go v0.0.0-20210128171943-85c9f9118ce3
+	github.com/open-policy-agent/opa v0.42.2
 	go.mongodb.org/mongo-driver v1.4.6
-	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad // indirect
-	golang.org/x/sync v0.0.0-20201207232520-09787c993a3a // indirect
-	golang.org/x/text v0.3.5 // indirect
-	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,170 @@
+package rbac
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/mikespook/gorbac"
+	"io"
+	"sort"
+	"strings"
+)
+
+// GraphOptions selects the part of the role hierarchy to export.
+type GraphOptions struct {
+	// Root limits the graph to the role `Root` and all roles inheriting from it.
+	Root string
+	// Permissions adds the permissions of each role to the graph.
+	Permissions bool
+	// PermissionPrefix limits the permissions to those starting with the prefix.
+	// A non-empty prefix implies Permissions.
+	PermissionPrefix string
+}
+
+type graphNode struct {
+	id          string
+	parents     []string
+	permissions []string
+}
+
+// ExportDOT writes the role hierarchy as a Graphviz DOT digraph.
+// Edges point from a role to the roles it inherits from.
+func ExportDOT(rbac *RBAC, w io.Writer, opts GraphOptions) error {
+	nodes, err := graphNodes(rbac, opts)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph rbac {")
+	fmt.Fprintln(bw, "\trankdir=BT;")
+	fmt.Fprintln(bw, "\tnode [shape=box];")
+	for _, n := range nodes {
+		fmt.Fprintf(bw, "\t%s;\n", dotQuote(n.id))
+	}
+	for _, n := range nodes {
+		for _, pid := range n.parents {
+			fmt.Fprintf(bw, "\t%s -> %s;\n", dotQuote(n.id), dotQuote(pid))
+		}
+	}
+	for _, pid := range graphPermissions(nodes) {
+		fmt.Fprintf(bw, "\t%s [label=%s, shape=ellipse];\n", dotQuote("permission:"+pid), dotQuote(pid))
+	}
+	for _, n := range nodes {
+		for _, pid := range n.permissions {
+			fmt.Fprintf(bw, "\t%s -> %s [style=dashed];\n", dotQuote(n.id), dotQuote("permission:"+pid))
+		}
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// ExportMermaid writes the role hierarchy as a Mermaid flowchart.
+// Edges point from a role to the roles it inherits from.
+func ExportMermaid(rbac *RBAC, w io.Writer, opts GraphOptions) error {
+	nodes, err := graphNodes(rbac, opts)
+	if err != nil {
+		return err
+	}
+	roles := make(map[string]string, len(nodes))
+	for i, n := range nodes {
+		roles[n.id] = fmt.Sprintf("r%d", i)
+	}
+	permissions := make(map[string]string)
+	for i, pid := range graphPermissions(nodes) {
+		permissions[pid] = fmt.Sprintf("p%d", i)
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "flowchart BT")
+	for _, n := range nodes {
+		fmt.Fprintf(bw, "\t%s[%s]\n", roles[n.id], mermaidQuote(n.id))
+	}
+	for _, n := range nodes {
+		for _, pid := range n.parents {
+			fmt.Fprintf(bw, "\t%s --> %s\n", roles[n.id], roles[pid])
+		}
+	}
+	for _, pid := range graphPermissions(nodes) {
+		fmt.Fprintf(bw, "\t%s([%s])\n", permissions[pid], mermaidQuote(pid))
+	}
+	for _, n := range nodes {
+		for _, pid := range n.permissions {
+			fmt.Fprintf(bw, "\t%s -.-> %s\n", roles[n.id], permissions[pid])
+		}
+	}
+	return bw.Flush()
+}
+
+// graphNodes collects the roles selected by `opts`, sorted by id.
+// Parents outside of the selection are dropped.
+func graphNodes(rbac *RBAC, opts GraphOptions) ([]graphNode, error) {
+	var scope map[string]struct{}
+	if opts.Root != "" {
+		descendants, err := rbac.Descendants(opts.Root)
+		if err != nil {
+			return nil, err
+		}
+		scope = map[string]struct{}{opts.Root: empty}
+		for _, id := range descendants {
+			scope[id] = empty
+		}
+	}
+	withPermissions := opts.Permissions || opts.PermissionPrefix != ""
+
+	var nodes []graphNode
+	err := Walk(rbac, func(role gorbac.Role, parents []string) error {
+		if scope != nil {
+			if _, ok := scope[role.ID()]; !ok {
+				return nil
+			}
+		}
+		n := graphNode{id: role.ID(), parents: parents}
+		if r, ok := role.(interface{ GetPermissions() []gorbac.Permission }); ok && withPermissions {
+			for _, p := range r.GetPermissions() {
+				if strings.HasPrefix(p.ID(), opts.PermissionPrefix) {
+					n.permissions = append(n.permissions, p.ID())
+				}
+			}
+		}
+		sort.Strings(n.permissions)
+		nodes = append(nodes, n)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]struct{}, len(nodes))
+	for _, n := range nodes {
+		ids[n.id] = empty
+	}
+	for i, n := range nodes {
+		var parents []string
+		for _, pid := range n.parents {
+			if _, ok := ids[pid]; ok {
+				parents = append(parents, pid)
+			}
+		}
+		sort.Strings(parents)
+		nodes[i].parents = parents
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].id < nodes[j].id
+	})
+	return nodes, nil
+}
+
+func graphPermissions(nodes []graphNode) []string {
+	set := make(map[string]struct{})
+	for _, n := range nodes {
+		for _, pid := range n.permissions {
+			set[pid] = empty
+		}
+	}
+	return sortedKeys(set)
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+func mermaidQuote(s string) string {
+	return `"` + strings.NewReplacer(`"`, "#quot;", "\n", " ").Replace(s) + `"`
+}
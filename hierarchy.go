@@ -0,0 +1,142 @@
+package rbac
+
+import (
+	"sort"
+)
+
+// Children returns the roles which directly inherit from the role `id`.
+// If the role is not existing, an error will be returned.
+func (rbac *RBAC) Children(id string) ([]string, error) {
+	rbac.backend.RLock()
+	defer rbac.backend.RUnlock()
+	if _, ok := rbac.backend.GetRole(id); !ok {
+		return nil, ErrRoleNotExist
+	}
+	children, _ := rbac.backend.GetChildren(id)
+	return sortedKeys(children), nil
+}
+
+// Ancestors returns all roles the role `id` inherits from,
+// directly or transitively.
+// If the role is not existing, an error will be returned.
+func (rbac *RBAC) Ancestors(id string) ([]string, error) {
+	rbac.backend.RLock()
+	defer rbac.backend.RUnlock()
+	if _, ok := rbac.backend.GetRole(id); !ok {
+		return nil, ErrRoleNotExist
+	}
+	return rbac.traverse(id, rbac.backend.GetParents), nil
+}
+
+// Descendants returns all roles which inherit from the role `id`,
+// directly or transitively.
+// If the role is not existing, an error will be returned.
+func (rbac *RBAC) Descendants(id string) ([]string, error) {
+	rbac.backend.RLock()
+	defer rbac.backend.RUnlock()
+	if _, ok := rbac.backend.GetRole(id); !ok {
+		return nil, ErrRoleNotExist
+	}
+	return rbac.traverse(id, rbac.backend.GetChildren), nil
+}
+
+// Roots returns all roles without parents.
+// The inheritances are read at once rather than role by role.
+func (rbac *RBAC) Roots() []string {
+	rbac.backend.RLock()
+	defer rbac.backend.RUnlock()
+	all := rbac.backend.GetAllParents()
+	var roots []string
+	for id := range rbac.backend.GetRoles() {
+		if len(all[id]) == 0 {
+			roots = append(roots, id)
+		}
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+// Leaves returns all roles without children.
+// The inheritances are read at once rather than role by role.
+func (rbac *RBAC) Leaves() []string {
+	rbac.backend.RLock()
+	defer rbac.backend.RUnlock()
+	parents := make(map[string]struct{})
+	for _, pids := range rbac.backend.GetAllParents() {
+		for pid := range pids {
+			parents[pid] = empty
+		}
+	}
+	var leaves []string
+	for id := range rbac.backend.GetRoles() {
+		if _, ok := parents[id]; !ok {
+			leaves = append(leaves, id)
+		}
+	}
+	sort.Strings(leaves)
+	return leaves
+}
+
+// Depth returns the length of the longest inheritance path
+// from the role `id` up to a root. Roots have a depth of 0.
+// If the role is not existing, an error will be returned.
+func (rbac *RBAC) Depth(id string) (int, error) {
+	rbac.backend.RLock()
+	defer rbac.backend.RUnlock()
+	if _, ok := rbac.backend.GetRole(id); !ok {
+		return 0, ErrRoleNotExist
+	}
+	return rbac.depth(id, make(map[string]int), make(map[string]struct{})), nil
+}
+
+func (rbac *RBAC) depth(id string, known map[string]int, path map[string]struct{}) int {
+	if d, ok := known[id]; ok {
+		return d
+	}
+	path[id] = empty
+	defer delete(path, id)
+	result := 0
+	parents, _ := rbac.backend.GetParents(id)
+	for pid := range parents {
+		if _, ok := path[pid]; ok {
+			continue
+		}
+		if d := rbac.depth(pid, known, path) + 1; d > result {
+			result = d
+		}
+	}
+	known[id] = result
+	return result
+}
+
+// traverse collects every role reachable from `id` by following `next`.
+// The role `id` itself is not part of the result.
+func (rbac *RBAC) traverse(id string, next func(string) (map[string]struct{}, bool)) []string {
+	visited := map[string]struct{}{id: empty}
+	queue := []string{id}
+	var result []string
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		ids, _ := next(current)
+		for nid := range ids {
+			if _, ok := visited[nid]; ok {
+				continue
+			}
+			visited[nid] = empty
+			result = append(result, nid)
+			queue = append(queue, nid)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func sortedKeys(in map[string]struct{}) []string {
+	var result []string
+	for k := range in {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,353 @@
+// Package k8s imports Kubernetes RBAC manifests as rbac-go policies.
+//
+// Roles, ClusterRoles, RoleBindings and ClusterRoleBindings are read from
+// YAML manifests without any dependency on a cluster. The roles are named
+//
+//	ClusterRole                   <name>
+//	Role                          <namespace>/<name>
+//	User and Group subjects       user:<name>, group:<name>
+//	ServiceAccount subjects       serviceaccount:<namespace>/<name>
+//
+// Every rule grants permissions of the form `verb:group/resource`, with
+// `core` standing for the core API group, and `verb:url` for non-resource
+// URLs. Wildcards are translated to regular expressions. Bindings become
+// parent edges from the subject to the bound role, and ClusterRole
+// aggregation becomes parent edges from the aggregated role to the
+// selected ClusterRoles. Anything which cannot be represented is skipped
+// and reported as a warning.
+package k8s
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/z26100/rbac-go/auth"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Warning reports a part of a manifest which cannot be imported.
+type Warning struct {
+	Object string
+	Msg    string
+}
+
+func (w Warning) String() string {
+	return w.Object + ": " + w.Msg
+}
+
+type object struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Name      string            `yaml:"name"`
+		Namespace string            `yaml:"namespace"`
+		Labels    map[string]string `yaml:"labels"`
+	} `yaml:"metadata"`
+	Rules           []rule `yaml:"rules"`
+	AggregationRule *struct {
+		ClusterRoleSelectors []selector `yaml:"clusterRoleSelectors"`
+	} `yaml:"aggregationRule"`
+	RoleRef struct {
+		Kind string `yaml:"kind"`
+		Name string `yaml:"name"`
+	} `yaml:"roleRef"`
+	Subjects []struct {
+		Kind      string `yaml:"kind"`
+		Name      string `yaml:"name"`
+		Namespace string `yaml:"namespace"`
+	} `yaml:"subjects"`
+	Items []object `yaml:"items"`
+}
+
+type rule struct {
+	APIGroups       []string `yaml:"apiGroups"`
+	Resources       []string `yaml:"resources"`
+	Verbs           []string `yaml:"verbs"`
+	ResourceNames   []string `yaml:"resourceNames"`
+	NonResourceURLs []string `yaml:"nonResourceURLs"`
+}
+
+type selector struct {
+	MatchLabels      map[string]string `yaml:"matchLabels"`
+	MatchExpressions []struct {
+		Key      string   `yaml:"key"`
+		Operator string   `yaml:"operator"`
+		Values   []string `yaml:"values"`
+	} `yaml:"matchExpressions"`
+}
+
+// importer collects the objects of all manifests before converting them,
+// because aggregation selects ClusterRoles of any manifest.
+type importer struct {
+	objects  []object
+	policy   *auth.Policy
+	warnings []Warning
+}
+
+// Import reads Kubernetes RBAC manifests from `r`, which may contain
+// multiple YAML documents and `List` objects.
+func Import(r io.Reader) (*auth.Policy, []Warning, error) {
+	im := &importer{}
+	if err := im.read(r); err != nil {
+		return nil, nil, err
+	}
+	return im.convert()
+}
+
+// ImportFiles reads Kubernetes RBAC manifests from the files `filenames`.
+func ImportFiles(filenames ...string) (*auth.Policy, []Warning, error) {
+	im := &importer{}
+	for _, filename := range filenames {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := im.read(bytes.NewReader(data)); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", filename, err)
+		}
+	}
+	return im.convert()
+}
+
+func (im *importer) read(r io.Reader) error {
+	dec := yaml.NewDecoder(r)
+	for {
+		var o object
+		err := dec.Decode(&o)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		im.add(o)
+	}
+}
+
+func (im *importer) add(o object) {
+	if o.Kind == "List" || strings.HasSuffix(o.Kind, "List") {
+		for _, item := range o.Items {
+			im.add(item)
+		}
+		return
+	}
+	if o.Kind != "" {
+		im.objects = append(im.objects, o)
+	}
+}
+
+func (im *importer) convert() (*auth.Policy, []Warning, error) {
+	im.policy = &auth.Policy{
+		Roles: make(map[string][]string),
+		Inher: make(map[string][]string),
+	}
+	// roles are converted first, as bindings may precede
+	// the roles they refer to
+	for _, o := range im.objects {
+		if o.Kind == "ClusterRole" || o.Kind == "Role" {
+			im.role(o)
+		}
+	}
+	for _, o := range im.objects {
+		switch o.Kind {
+		case "ClusterRole", "Role":
+		case "ClusterRoleBinding", "RoleBinding":
+			im.binding(o)
+		default:
+			im.warn(o, fmt.Sprintf("kind %q is not supported", o.Kind))
+		}
+	}
+	for _, o := range im.objects {
+		if o.Kind == "ClusterRole" && o.AggregationRule != nil {
+			im.aggregate(o)
+		}
+	}
+	for name, parents := range im.policy.Inher {
+		sort.Strings(parents)
+		im.policy.Inher[name] = parents
+	}
+	return im.policy, im.warnings, nil
+}
+
+func (im *importer) role(o object) {
+	name := roleName(o.Kind, o.Metadata.Namespace, o.Metadata.Name)
+	permissions := im.policy.Roles[name]
+	for i, r := range o.Rules {
+		if len(r.ResourceNames) > 0 {
+			im.warn(o, fmt.Sprintf("rule %d: resourceNames are not supported, the rule is skipped", i))
+			continue
+		}
+		for _, verb := range r.Verbs {
+			for _, url := range r.NonResourceURLs {
+				permissions = appendUnique(permissions, "^"+wildcard(verb, "[^:]*")+":"+urlPattern(url)+"$")
+			}
+			for _, group := range r.APIGroups {
+				for _, resource := range r.Resources {
+					permissions = appendUnique(permissions, Permission(verb, group, resource))
+				}
+			}
+		}
+	}
+	sort.Strings(permissions)
+	if permissions == nil {
+		permissions = []string{}
+	}
+	im.policy.Roles[name] = permissions
+}
+
+func (im *importer) binding(o object) {
+	kind := o.RoleRef.Kind
+	if kind != "ClusterRole" && kind != "Role" {
+		im.warn(o, fmt.Sprintf("roleRef kind %q is not supported", kind))
+		return
+	}
+	if kind == "Role" && o.Kind == "ClusterRoleBinding" {
+		im.warn(o, "a ClusterRoleBinding cannot bind a Role")
+		return
+	}
+	if o.Kind == "RoleBinding" && kind == "ClusterRole" {
+		im.warn(o, fmt.Sprintf("the permissions of ClusterRole %q are granted in all namespaces, "+
+			"not only in namespace %q", o.RoleRef.Name, o.Metadata.Namespace))
+	}
+	parent := roleName(kind, o.Metadata.Namespace, o.RoleRef.Name)
+	if _, ok := im.policy.Roles[parent]; !ok {
+		im.policy.Roles[parent] = []string{}
+		im.warn(o, fmt.Sprintf("%s %q is not defined", kind, parent))
+	}
+	for _, s := range o.Subjects {
+		var name string
+		switch s.Kind {
+		case "User":
+			name = "user:" + s.Name
+		case "Group":
+			name = "group:" + s.Name
+		case "ServiceAccount":
+			ns := s.Namespace
+			if ns == "" {
+				ns = o.Metadata.Namespace
+			}
+			name = "serviceaccount:" + ns + "/" + s.Name
+		default:
+			im.warn(o, fmt.Sprintf("subject kind %q is not supported", s.Kind))
+			continue
+		}
+		if _, ok := im.policy.Roles[name]; !ok {
+			im.policy.Roles[name] = []string{}
+		}
+		im.policy.Inher[name] = appendUnique(im.policy.Inher[name], parent)
+	}
+}
+
+func (im *importer) aggregate(o object) {
+	name := o.Metadata.Name
+	for _, other := range im.objects {
+		if other.Kind != "ClusterRole" || other.Metadata.Name == name {
+			continue
+		}
+		for _, s := range o.AggregationRule.ClusterRoleSelectors {
+			ok, err := s.matches(other.Metadata.Labels)
+			if err != nil {
+				im.warn(o, err.Error())
+				return
+			}
+			if ok {
+				im.policy.Inher[name] = appendUnique(im.policy.Inher[name], other.Metadata.Name)
+				break
+			}
+		}
+	}
+}
+
+func (im *importer) warn(o object, msg string) {
+	id := o.Kind + " " + o.Metadata.Name
+	if o.Metadata.Namespace != "" {
+		id = o.Kind + " " + o.Metadata.Namespace + "/" + o.Metadata.Name
+	}
+	im.warnings = append(im.warnings, Warning{Object: id, Msg: msg})
+}
+
+func (s selector) matches(labels map[string]string) (bool, error) {
+	for k, v := range s.MatchLabels {
+		if labels[k] != v {
+			return false, nil
+		}
+	}
+	for _, e := range s.MatchExpressions {
+		value, ok := labels[e.Key]
+		switch e.Operator {
+		case "In":
+			if !ok || !contains(e.Values, value) {
+				return false, nil
+			}
+		case "NotIn":
+			if ok && contains(e.Values, value) {
+				return false, nil
+			}
+		case "Exists":
+			if !ok {
+				return false, nil
+			}
+		case "DoesNotExist":
+			if ok {
+				return false, nil
+			}
+		default:
+			return false, fmt.Errorf("selector operator %q is not supported", e.Operator)
+		}
+	}
+	return true, nil
+}
+
+// Permission returns the permission a rule with a single verb,
+// API group and resource grants.
+func Permission(verb, group, resource string) string {
+	if group == "" {
+		group = "core"
+	}
+	return "^" + wildcard(verb, "[^:]*") + ":" + wildcard(group, "[^/]*") + "/" + wildcard(resource, ".*") + "$"
+}
+
+func roleName(kind, namespace, name string) string {
+	if kind == "Role" {
+		return namespace + "/" + name
+	}
+	return name
+}
+
+// wildcard quotes `s`, translating the wildcard `*` into `any`.
+func wildcard(s string, any string) string {
+	if s == "*" {
+		return any
+	}
+	return regexp.QuoteMeta(s)
+}
+
+// urlPattern quotes a non-resource URL, which may end with a `*` wildcard.
+func urlPattern(url string) string {
+	if strings.HasSuffix(url, "*") {
+		return regexp.QuoteMeta(strings.TrimSuffix(url, "*")) + ".*"
+	}
+	return regexp.QuoteMeta(url)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
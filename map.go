@@ -6,28 +6,41 @@ import (
 )
 
 type MapBackend struct {
-	roles   gorbac.Roles
-	mutex   sync.RWMutex
-	parents map[string]map[string]struct{}
+	roles    gorbac.Roles
+	mutex    sync.RWMutex
+	parents  map[string]map[string]struct{}
+	children map[string]map[string]struct{}
+	// validities holds the validities of parents by child and parent
+	validities  map[string]map[string]*Validity
+	constraints map[string]Constraint
 }
 
 func NewMapBackend() *MapBackend {
 	return &MapBackend{
-		roles:   make(gorbac.Roles),
-		parents: make(map[string]map[string]struct{}),
-		mutex:   sync.RWMutex{},
+		roles:       make(gorbac.Roles),
+		parents:     make(map[string]map[string]struct{}),
+		children:    make(map[string]map[string]struct{}),
+		validities:  make(map[string]map[string]*Validity),
+		constraints: make(map[string]Constraint),
+		mutex:       sync.RWMutex{},
 	}
 }
 
 func (b *MapBackend) Close() error {
 	b.roles = nil
 	b.parents = nil
+	b.children = nil
+	b.validities = nil
+	b.constraints = nil
 	return nil
 }
 
 func (b *MapBackend) Clear() error {
 	b.roles = make(gorbac.Roles)
 	b.parents = make(map[string]map[string]struct{})
+	b.children = make(map[string]map[string]struct{})
+	b.validities = make(map[string]map[string]*Validity)
+	b.constraints = make(map[string]Constraint)
 	return nil
 }
 
@@ -74,18 +87,98 @@ func (b *MapBackend) GetParents(id string) (map[string]struct{}, bool) {
 	}
 	return result, true
 }
+func (b *MapBackend) GetChildren(id string) (map[string]struct{}, bool) {
+	result := b.children[id]
+	if len(result) == 0 {
+		return nil, false
+	}
+	return result, true
+}
 func (b *MapBackend) SetParent(id string, pid string, p struct{}) error {
+	if b.parents[id] == nil {
+		b.parents[id] = make(map[string]struct{})
+	}
 	b.parents[id][pid] = p
+	b.addChild(pid, id)
+	b.removeValidity(id, pid)
 	return nil
 }
 func (b *MapBackend) SetParents(id string, p map[string]struct{}) {
+	for pid := range b.parents[id] {
+		b.removeChild(pid, id)
+	}
 	b.parents[id] = p
+	delete(b.validities, id)
+	for pid := range p {
+		b.addChild(pid, id)
+	}
 }
 func (b *MapBackend) DeleteParents(id string) error {
+	for pid := range b.parents[id] {
+		b.removeChild(pid, id)
+	}
 	delete(b.parents, id)
+	delete(b.validities, id)
 	return nil
 }
-func (b *MapBackend) DeleteParent(pid, id string) error {
-	delete(b.parents[pid], id)
+func (b *MapBackend) DeleteParent(id, pid string) error {
+	delete(b.parents[id], pid)
+	b.removeChild(pid, id)
+	b.removeValidity(id, pid)
 	return nil
 }
+
+func (b *MapBackend) GetParentValidities(id string) map[string]*Validity {
+	return b.validities[id]
+}
+
+func (b *MapBackend) SetParentValidity(id, pid string, v *Validity) error {
+	if v == nil {
+		b.removeValidity(id, pid)
+		return nil
+	}
+	if b.validities[id] == nil {
+		b.validities[id] = make(map[string]*Validity)
+	}
+	b.validities[id][pid] = v
+	return nil
+}
+
+func (b *MapBackend) GetConstraints() []Constraint {
+	result := make([]Constraint, 0, len(b.constraints))
+	for _, c := range b.constraints {
+		result = append(result, c)
+	}
+	return result
+}
+
+func (b *MapBackend) SetConstraint(c Constraint) error {
+	b.constraints[c.ConstraintName()] = c
+	return nil
+}
+
+func (b *MapBackend) DeleteConstraint(name string) error {
+	delete(b.constraints, name)
+	return nil
+}
+
+func (b *MapBackend) addChild(pid, id string) {
+	if b.children[pid] == nil {
+		b.children[pid] = make(map[string]struct{})
+	}
+	b.children[pid][id] = empty
+}
+
+func (b *MapBackend) removeChild(pid, id string) {
+	delete(b.children[pid], id)
+	if len(b.children[pid]) == 0 {
+		delete(b.children, pid)
+	}
+}
+
+func (b *MapBackend) removeValidity(id, pid string) {
+	delete(b.validities[id], pid)
+	if len(b.validities[id]) == 0 {
+		delete(b.validities, id)
+	}
+}
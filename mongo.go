@@ -1,11 +1,15 @@
 package rbac
 
 import (
+	"context"
 	"fmt"
 	"github.com/mikespook/gorbac"
 	"go.mongodb.org/mongo-driver/bson"
 	m "go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 	"sync"
 	"time"
 )
@@ -20,22 +24,137 @@ type MongoBackend struct {
 	mongo    *m.Client
 	parents  map[string]map[string]struct{}
 	database string
-	timeout  time.Duration
 	config   config
 	colRoles string
 	colInher string
+
+	// colConstraints stores the constraints, see AddConstraint
+	colConstraints string
+	// colGrants stores the permissions whose validity ends,
+	// see GrantDocument
+	colGrants string
+	// colSchema stores the schema version, see EnsureSchema
+	colSchema  string
+	migrations []MongoMigration
+	// shared marks the backends returned by Tenant, which use the
+	// client of their parent and leave it connected on Close
+	shared bool
+}
+
+// MongoOption configures a MongoBackend, see NewMongoBackend.
+type MongoOption func(b *MongoBackend) error
+
+// WithCollections sets the names of the collections storing the roles
+// and the inheritance, `roles` and `inheritance` by default.
+func WithCollections(roles, inheritance string) MongoOption {
+	return func(b *MongoBackend) error {
+		if roles == "" || inheritance == "" || roles == inheritance {
+			return fmt.Errorf("invalid collection names %q and %q", roles, inheritance)
+		}
+		b.colRoles, b.colInher = roles, inheritance
+		return nil
+	}
+}
+
+// WithConstraintCollection sets the name of the collection storing
+// the constraints, `constraints` by default.
+func WithConstraintCollection(name string) MongoOption {
+	return func(b *MongoBackend) error {
+		if name == "" {
+			return fmt.Errorf("invalid collection name %q", name)
+		}
+		b.colConstraints = name
+		return nil
+	}
+}
+
+// WithGrantCollection sets the name of the collection storing the
+// permissions whose validity ends, `grants` by default.
+func WithGrantCollection(name string) MongoOption {
+	return func(b *MongoBackend) error {
+		if name == "" {
+			return fmt.Errorf("invalid collection name %q", name)
+		}
+		b.colGrants = name
+		return nil
+	}
+}
+
+// WithSchemaCollection sets the name of the collection storing the
+// schema version, `schema` by default.
+func WithSchemaCollection(name string) MongoOption {
+	return func(b *MongoBackend) error {
+		if name == "" {
+			return fmt.Errorf("invalid collection name %q", name)
+		}
+		b.colSchema = name
+		return nil
+	}
+}
+
+// WithMigrations adds migrations run by EnsureSchema
+// after the ones of this package.
+func WithMigrations(ms ...MongoMigration) MongoOption {
+	return func(b *MongoBackend) error {
+		b.migrations = append(b.migrations, ms...)
+		return nil
+	}
 }
 
-func NewMongoBackend(client *m.Client, database string) (*MongoBackend, error) {
-	return &MongoBackend{
-		mutex:    sync.RWMutex{},
-		mongo:    client,
-		timeout:  defaultTimeout,
-		colRoles: "roles",
-		colInher: "inheritance",
+// WithTimeout sets the timeout of every MongoDB operation,
+// 10 seconds by default.
+func WithTimeout(timeout time.Duration) MongoOption {
+	return func(b *MongoBackend) error {
+		if timeout <= 0 {
+			return fmt.Errorf("invalid timeout %v", timeout)
+		}
+		b.config.timeout = timeout
+		return nil
+	}
+}
+
+// WithReadConcern sets the read concern of the database.
+func WithReadConcern(rc *readconcern.ReadConcern) MongoOption {
+	return WithDatabaseOptions(options.Database().SetReadConcern(rc))
+}
+
+// WithWriteConcern sets the write concern of the database.
+func WithWriteConcern(wc *writeconcern.WriteConcern) MongoOption {
+	return WithDatabaseOptions(options.Database().SetWriteConcern(wc))
+}
+
+// WithReadPreference sets the read preference of the database.
+func WithReadPreference(rp *readpref.ReadPref) MongoOption {
+	return WithDatabaseOptions(options.Database().SetReadPreference(rp))
+}
+
+// WithDatabaseOptions sets the options of the database. Options set by
+// several calls are merged, the last one set wins.
+func WithDatabaseOptions(opts *options.DatabaseOptions) MongoOption {
+	return func(b *MongoBackend) error {
+		b.config.databaseOpts = options.MergeDatabaseOptions(b.config.databaseOpts, opts)
+		return nil
+	}
+}
+
+// NewMongoBackend returns a backend storing the roles and the
+// inheritance in the database `database`, configured by `opts`.
+func NewMongoBackend(client *m.Client, database string, opts ...MongoOption) (*MongoBackend, error) {
+	b := &MongoBackend{
+		mutex:      sync.RWMutex{},
+		mongo:      client,
+		database:   database,
+		colRoles:   "roles",
+		colInher:   "inheritance",
+		colSchema:  "schema",
+		migrations: append([]MongoMigration{}, migrations...),
+
+		colConstraints: "constraints",
+		colGrants:      "grants",
 		config: config{
 			client:         nil,
 			database:       database,
+			timeout:        defaultTimeout,
 			databaseOpts:   nil,
 			collectionOpts: nil,
 			findOptions:    nil,
@@ -52,7 +171,46 @@ func NewMongoBackend(client *m.Client, database string) (*MongoBackend, error) {
 			},
 			findOneAndDeleteOptions: &options.FindOneAndDeleteOptions{},
 		},
-	}, nil
+	}
+	for _, opt := range opts {
+		if err := opt(b); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// Tenant returns a backend of the same database storing the roles and
+// the inheritance of the tenant `tenant` in its own collections, named
+// like `acme.roles` and `acme.inheritance`, whose schema is set up by
+// EnsureSchema. Use it with NewTenants. The backend shares the client
+// of `b`, which stays connected until `b` is closed.
+func (b *MongoBackend) Tenant(tenant string) (*MongoBackend, error) {
+	if err := ValidateTenant(tenant); err != nil {
+		return nil, err
+	}
+	t := &MongoBackend{
+		mongo:      b.mongo,
+		database:   b.database,
+		config:     b.config,
+		colRoles:   tenant + "." + b.colRoles,
+		colInher:   tenant + "." + b.colInher,
+		colSchema:  tenant + "." + b.colSchema,
+		migrations: b.migrations,
+
+		colConstraints: tenant + "." + b.colConstraints,
+		colGrants:      tenant + "." + b.colGrants,
+		shared:         true,
+	}
+	if err := t.EnsureSchema(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Ctx returns a context with the timeout of the backend, see WithTimeout.
+func (b *MongoBackend) Ctx() (context.Context, context.CancelFunc) {
+	return b.config.ctx()
 }
 
 func (b *MongoBackend) RLock() {
@@ -73,47 +231,70 @@ func (b *MongoBackend) RUnlock() {
 
 func (b *MongoBackend) GetRoles() map[string]gorbac.Role {
 	result := make(map[string]gorbac.Role)
-	res, err := FindMany(b.mongo, b.config, b.colRoles, bson.M{}, []*RBACRole{})
-	r := res.([]*RBACRole)
-	if res == nil || len(r) == 0 || err != nil {
+	res, err := Aggregate(b.mongo, b.config, b.colRoles, b.withGrants(bson.M{}), []*roleDocument{})
+	if res == nil || err != nil {
 		return result
 	}
-	for _, r := range r {
-		result[r.ID()] = r
+	for _, r := range res.([]*roleDocument) {
+		role := r.role()
+		result[role.ID()] = role
 	}
 	return result
 }
 
 func (b *MongoBackend) GetRole(id string) (gorbac.Role, bool) {
-	res, err := FindOne(b.mongo, b.config, b.colRoles, id, []*RBACRole{})
-	result := res.([]*RBACRole)
-	if len(result) == 0 || err != nil {
+	res, err := Aggregate(b.mongo, b.config, b.colRoles, b.withGrants(filterById(id)), []*roleDocument{})
+	if res == nil || err != nil {
+		return nil, false
+	}
+	result := res.([]*roleDocument)
+	if len(result) == 0 {
 		return nil, false
 	}
-	return result[0], true
+	return result[0].role(), true
+}
+
+// withGrants returns the pipeline joining the roles matching `filter`
+// with their grants, so both are read at once.
+func (b *MongoBackend) withGrants(filter bson.M) bson.A {
+	return bson.A{
+		bson.M{"$match": filter},
+		bson.M{"$lookup": bson.M{"from": b.colGrants, "localField": "_id", "foreignField": "role", "as": "grants"}},
+	}
 }
 
+// SetRole stores the permissions of `role` whose validity ends apart
+// from the role, see GrantDocument. Roles stored before keep such
+// permissions in their document until they are set again.
 func (b *MongoBackend) SetRole(id string, role gorbac.Role) error {
-	_, err := FindOneAndReplace(b.mongo, b.config, b.colRoles, id, role)
-	if err != nil {
+	role, grants := splitGrants(id, role)
+	if _, err := DeleteMany(b.mongo, b.config, b.colGrants, bson.M{"role": id}); err != nil {
 		return err
 	}
+	if len(grants) > 0 {
+		if _, err := InsertMany(b.mongo, b.config, b.colGrants, grants); err != nil {
+			return err
+		}
+	}
+	_, err := FindOneAndReplace(b.mongo, b.config, b.colRoles, id, role)
 	return err
 }
 
 func (b *MongoBackend) DeleteRole(id string) error {
-	_, err := FindOneAndDelete(b.mongo, b.config, b.colRoles, id)
+	if _, err := FindOneAndDelete(b.mongo, b.config, b.colRoles, id); err != nil {
+		return err
+	}
+	_, err := DeleteMany(b.mongo, b.config, b.colGrants, bson.M{"role": id})
 	return err
 }
 
 func (b *MongoBackend) GetAllParents() map[string]map[string]struct{} {
-	var res []Inheritance
 	result := make(map[string]map[string]struct{})
-	_, err := FindMany(b.mongo, b.config, b.colRoles, bson.M{}, &res)
-	if res == nil || len(res) == 0 || err != nil {
+	res, err := FindMany(b.mongo, b.config, b.colInher, bson.M{}, []*Inheritance{})
+	if res == nil || err != nil {
 		return result
 	}
-	for _, r := range res {
+	for _, r := range res.([]*Inheritance) {
 		if result[r.Child] == nil {
 			result[r.Child] = make(map[string]struct{})
 		}
@@ -125,8 +306,11 @@ func (b *MongoBackend) GetAllParents() map[string]map[string]struct{} {
 func (b *MongoBackend) GetParents(id string) (map[string]struct{}, bool) {
 	result := make(map[string]struct{})
 	res, err := FindMany(b.mongo, b.config, b.colInher, bson.M{"child": id}, []*Inheritance{})
+	if res == nil || err != nil {
+		return result, false
+	}
 	r := res.([]*Inheritance)
-	if res == nil || len(r) == 0 || err != nil {
+	if len(r) == 0 {
 		return result, false
 	}
 	for _, r := range r {
@@ -135,6 +319,22 @@ func (b *MongoBackend) GetParents(id string) (map[string]struct{}, bool) {
 	return result, true
 }
 
+func (b *MongoBackend) GetChildren(id string) (map[string]struct{}, bool) {
+	result := make(map[string]struct{})
+	res, err := FindMany(b.mongo, b.config, b.colInher, bson.M{"parent": id}, []*Inheritance{})
+	if res == nil || err != nil {
+		return result, false
+	}
+	r := res.([]*Inheritance)
+	if len(r) == 0 {
+		return result, false
+	}
+	for _, r := range r {
+		result[r.Child] = r.Struct
+	}
+	return result, true
+}
+
 func (b *MongoBackend) SetParent(id string, pid string, p struct{}) error {
 	replacement := &Inheritance{
 		Parent: pid,
@@ -146,6 +346,39 @@ func (b *MongoBackend) SetParent(id string, pid string, p struct{}) error {
 	return err
 }
 
+// GetParentValidities returns the validities of the parents of the role
+// `id`. Expired inheritances are removed by the TTL index created by
+// EnsureIndexes, but only about once a minute.
+func (b *MongoBackend) GetParentValidities(id string) map[string]*Validity {
+	result := make(map[string]*Validity)
+	res, err := FindMany(b.mongo, b.config, b.colInher,
+		bson.M{"child": id, "validity": bson.M{"$exists": true}}, []*Inheritance{})
+	if res == nil || err != nil {
+		return result
+	}
+	for _, r := range res.([]*Inheritance) {
+		result[r.Parent] = r.Validity
+	}
+	return result
+}
+
+// SetParentValidity stores the end of `v` as the expiry of the
+// inheritance. The TTL index created by EnsureIndexes removes expired
+// inheritances without checking the constraints.
+func (b *MongoBackend) SetParentValidity(id, pid string, v *Validity) error {
+	replacement := &Inheritance{
+		Parent:   pid,
+		Child:    id,
+		Validity: v,
+	}
+	if v != nil && !v.NotAfter.IsZero() {
+		replacement.ExpiresAt = &v.NotAfter
+	}
+	rid := fmt.Sprintf("%s:%s", id, pid)
+	_, err := FindOneAndReplace(b.mongo, b.config, b.colInher, rid, replacement)
+	return err
+}
+
 func (b *MongoBackend) SetParents(id string, p map[string]struct{}) {
 	// nothing to do
 }
@@ -155,14 +388,75 @@ func (b *MongoBackend) DeleteParents(id string) error {
 	return err
 }
 
-func (b *MongoBackend) DeleteParent(pid, id string) error {
+func (b *MongoBackend) DeleteParent(id, pid string) error {
 	rid := fmt.Sprintf("%s:%s", id, pid)
 	_, err := DeleteOne(b.mongo, b.config, b.colInher, rid)
 	return err
 }
 
+func (b *MongoBackend) GetConstraints() []Constraint {
+	var result []Constraint
+	res, err := FindMany(b.mongo, b.config, b.colConstraints, bson.M{}, []*ConstraintDocument{})
+	if res == nil || err != nil {
+		return result
+	}
+	for _, doc := range res.([]*ConstraintDocument) {
+		if c := doc.Constraint(); c != nil {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+func (b *MongoBackend) SetConstraint(c Constraint) error {
+	_, err := FindOneAndReplace(b.mongo, b.config, b.colConstraints, c.ConstraintName(), NewConstraintDocument(c))
+	return err
+}
+
+func (b *MongoBackend) DeleteConstraint(name string) error {
+	_, err := DeleteOne(b.mongo, b.config, b.colConstraints, name)
+	return err
+}
+
+// EnsureIndexes creates the indexes used by the inheritance and grant
+// queries and TTL indexes removing inheritances and grants once their
+// validity ended. EnsureSchema calls it. The TTL monitor deletes
+// documents without checking the constraints, so removing an expired
+// inheritance may leave a prerequisite unmet.
+func (b *MongoBackend) EnsureIndexes() error {
+	ctx, cancelFc := b.config.ctx()
+	defer cancelFc()
+	expiry := m.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	for _, ci := range []struct {
+		collection string
+		indexes    []m.IndexModel
+	}{
+		{b.colInher, []m.IndexModel{
+			{Keys: bson.D{{Key: "child", Value: 1}}},
+			{Keys: bson.D{{Key: "parent", Value: 1}}},
+			expiry,
+		}},
+		{b.colGrants, []m.IndexModel{
+			{Keys: bson.D{{Key: "role", Value: 1}}},
+			expiry,
+		}},
+	} {
+		col, err := Collection(b.mongo, b.config, ci.collection)
+		if err != nil {
+			return err
+		}
+		if _, err := col.Indexes().CreateMany(ctx, ci.indexes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (b *MongoBackend) DropCollections(collectionName ...string) error {
-	ctx, cancelFc := Ctx()
+	ctx, cancelFc := b.config.ctx()
 	defer cancelFc()
 	for _, colName := range collectionName {
 		col, err := Collection(b.mongo, b.config, colName)
@@ -177,12 +471,32 @@ func (b *MongoBackend) DropCollections(collectionName ...string) error {
 	return nil
 }
 
+// Clear deletes the roles, the grants, the inheritance and the constraints.
+// The collections are kept together with their indexes and the
+// schema version, so the backend can be used right away.
 func (b *MongoBackend) Clear() error {
-	return b.DropCollections(b.colInher, b.colRoles)
+	for _, col := range []string{b.colInher, b.colRoles, b.colGrants, b.colConstraints} {
+		if _, err := DeleteMany(b.mongo, b.config, col, bson.M{}); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
+// Drop drops the collections of the backend including their indexes
+// and the schema version. EnsureSchema has to be called before the
+// backend is used again.
+func (b *MongoBackend) Drop() error {
+	return b.DropCollections(b.colInher, b.colRoles, b.colGrants, b.colConstraints, b.colSchema)
+}
+
+// Close disconnects the client. Backends returned by Tenant share the
+// client of their parent, closing them does nothing.
 func (b *MongoBackend) Close() error {
-	ctx, cancelFc := Ctx()
+	if b.shared {
+		return nil
+	}
+	ctx, cancelFc := b.config.ctx()
 	defer cancelFc()
 	return b.mongo.Disconnect(ctx)
 }
@@ -3,6 +3,8 @@ package rbac
 import (
 	"context"
 	"errors"
+	"fmt"
+	"github.com/mikespook/gorbac"
 	"go.mongodb.org/mongo-driver/bson"
 	m "go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -16,6 +18,7 @@ import (
 type config struct {
 	client                   *m.Client
 	database                 string
+	timeout                  time.Duration
 	databaseOpts             *options.DatabaseOptions
 	collectionOpts           *options.CollectionOptions
 	findOptions              *options.FindOptions
@@ -31,7 +34,113 @@ type config struct {
 type Inheritance struct {
 	Parent string   `json:"parent" bson:"parent"`
 	Child  string   `json:"child" bson:"child"`
-	Struct struct{} `json:"struct", bson:"struct"`
+	Struct struct{} `json:"struct" bson:"struct"`
+	// Validity limits the inheritance in time
+	Validity *Validity `json:"validity,omitempty" bson:"validity,omitempty"`
+	// ExpiresAt is the end of the validity, used by a TTL index
+	ExpiresAt *time.Time `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"`
+}
+
+// GrantDocument stores a permission of the role `Role` whose validity
+// ends, apart from the role, so the TTL index created by EnsureIndexes
+// removes it once expired. Deny marks a denied permission.
+type GrantDocument struct {
+	Id         string          `json:"id" bson:"_id"`
+	Role       string          `json:"role" bson:"role"`
+	Deny       bool            `json:"deny,omitempty" bson:"deny,omitempty"`
+	Permission *RBACPermission `json:"permission" bson:"permission"`
+	ExpiresAt  time.Time       `json:"expiresAt" bson:"expiresAt"`
+}
+
+// roleDocument is a role joined with its grants.
+type roleDocument struct {
+	RBACRole `bson:",inline"`
+	Grants   []*GrantDocument `bson:"grants"`
+}
+
+// role returns the role including its grants.
+func (d *roleDocument) role() *RBACRole {
+	role := d.RBACRole
+	for _, g := range d.Grants {
+		if g.Deny {
+			role.AddDeny(g.Permission)
+		} else {
+			role.AddPermission(g.Permission)
+		}
+	}
+	return &role
+}
+
+// splitGrants returns `role` without the permissions whose validity
+// ends and the grant documents storing them.
+func splitGrants(id string, role gorbac.Role) (gorbac.Role, []interface{}) {
+	r, ok := role.(*RBACRole)
+	if !ok {
+		return role, nil
+	}
+	stored := *r
+	var grants []interface{}
+	split := func(permissions map[string]*RBACPermission, kind string) map[string]*RBACPermission {
+		if permissions == nil {
+			return nil
+		}
+		kept := make(map[string]*RBACPermission, len(permissions))
+		for pid, p := range permissions {
+			if p.Validity == nil || p.Validity.NotAfter.IsZero() {
+				kept[pid] = p
+				continue
+			}
+			grants = append(grants, &GrantDocument{
+				Id:         fmt.Sprintf("%s:%s:%s", id, kind, pid),
+				Role:       id,
+				Deny:       kind == "deny",
+				Permission: p,
+				ExpiresAt:  p.Validity.NotAfter,
+			})
+		}
+		return kept
+	}
+	stored.Permissions = split(r.Permissions, "permission")
+	stored.Deny = split(r.Deny, "deny")
+	return &stored, grants
+}
+
+// ConstraintDocument stores one of the constraint types.
+type ConstraintDocument struct {
+	Name     string   `json:"name" bson:"_id"`
+	Type     string   `json:"type" bson:"type"`
+	Roles    []string `json:"roles,omitempty" bson:"roles,omitempty"`
+	Role     string   `json:"role,omitempty" bson:"role,omitempty"`
+	Requires []string `json:"requires,omitempty" bson:"requires,omitempty"`
+	Max      int      `json:"max,omitempty" bson:"max,omitempty"`
+	Dynamic  bool     `json:"dynamic,omitempty" bson:"dynamic,omitempty"`
+}
+
+// NewConstraintDocument returns the document storing `c`.
+func NewConstraintDocument(c Constraint) *ConstraintDocument {
+	doc := &ConstraintDocument{Name: c.ConstraintName()}
+	switch c := c.(type) {
+	case SoDConstraint:
+		doc.Type, doc.Roles, doc.Max, doc.Dynamic = "sod", c.Roles, c.Max, c.Dynamic
+	case CardinalityConstraint:
+		doc.Type, doc.Role, doc.Max = "cardinality", c.Role, c.Max
+	case PrerequisiteConstraint:
+		doc.Type, doc.Role, doc.Requires = "prerequisite", c.Role, c.Requires
+	}
+	return doc
+}
+
+// Constraint returns the stored constraint, nil for an unknown type.
+func (d *ConstraintDocument) Constraint() Constraint {
+	switch d.Type {
+	case "sod":
+		return SoDConstraint{Name: d.Name, Roles: d.Roles, Max: d.Max, Dynamic: d.Dynamic}
+	case "cardinality":
+		return CardinalityConstraint{Name: d.Name, Role: d.Role, Max: d.Max}
+	case "prerequisite":
+		return PrerequisiteConstraint{Name: d.Name, Role: d.Role, Requires: d.Requires}
+	}
+	return nil
 }
 
 func FindOne(c *m.Client, config config, collection string, id string, out interface{}) (interface{}, error) {
@@ -39,7 +148,7 @@ func FindOne(c *m.Client, config config, collection string, id string, out inter
 }
 
 func FindMany(c *m.Client, config config, collection string, filter bson.M, out interface{}) (interface{}, error) {
-	ctx, cancelFc := Ctx()
+	ctx, cancelFc := config.ctx()
 	defer cancelFc()
 	col, err := Collection(c, config, collection)
 	if err != nil {
@@ -59,6 +168,21 @@ func FindMany(c *m.Client, config config, collection string, filter bson.M, out
 	return out, err
 }
 
+func Aggregate(c *m.Client, config config, collection string, pipeline bson.A, out interface{}) (interface{}, error) {
+	ctx, cancelFc := config.ctx()
+	defer cancelFc()
+	col, err := Collection(c, config, collection)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := col.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	err = cursor.All(ctx, &out)
+	return out, err
+}
+
 func InsertOne(c *m.Client, config config, collection string, doc interface{}) (interface{}, error) {
 	var docs []interface{}
 	docs = append(docs, doc)
@@ -66,7 +190,7 @@ func InsertOne(c *m.Client, config config, collection string, doc interface{}) (
 }
 
 func InsertMany(c *m.Client, config config, collection string, docs []interface{}) (interface{}, error) {
-	ctx, cancelFc := Ctx()
+	ctx, cancelFc := config.ctx()
 	defer cancelFc()
 	col, err := Collection(c, config, collection)
 	if err != nil {
@@ -77,7 +201,7 @@ func InsertMany(c *m.Client, config config, collection string, docs []interface{
 }
 
 func FindOneAndUpdate(c *m.Client, config config, collection string, id string, update interface{}) (interface{}, error) {
-	ctx, cancelFc := Ctx()
+	ctx, cancelFc := config.ctx()
 	defer cancelFc()
 	col, err := Collection(c, config, collection)
 	if err != nil {
@@ -95,7 +219,7 @@ func UpdateOne(c *m.Client, config config, collection string, id string, update
 }
 
 func UpdateMany(c *m.Client, config config, collection string, filter bson.M, update interface{}) (interface{}, error) {
-	ctx, cancelFc := Ctx()
+	ctx, cancelFc := config.ctx()
 	defer cancelFc()
 	col, err := Collection(c, config, collection)
 	if err != nil {
@@ -105,7 +229,7 @@ func UpdateMany(c *m.Client, config config, collection string, filter bson.M, up
 	return res, err
 }
 func FindOneAndReplace(c *m.Client, config config, collection string, id string, replacement interface{}) (interface{}, error) {
-	ctx, cancelFc := Ctx()
+	ctx, cancelFc := config.ctx()
 	defer cancelFc()
 	col, err := Collection(c, config, collection)
 	if err != nil {
@@ -124,7 +248,7 @@ func FindOneAndReplace(c *m.Client, config config, collection string, id string,
 }
 
 func ReplaceOne(c *m.Client, config config, collection string, filter bson.M, replacement interface{}) (interface{}, error) {
-	ctx, cancelFc := Ctx()
+	ctx, cancelFc := config.ctx()
 	defer cancelFc()
 	col, err := Collection(c, config, collection)
 	if err != nil {
@@ -135,7 +259,7 @@ func ReplaceOne(c *m.Client, config config, collection string, filter bson.M, re
 }
 
 func FindOneAndDelete(c *m.Client, config config, collection string, id string) (interface{}, error) {
-	ctx, cancelFc := Ctx()
+	ctx, cancelFc := config.ctx()
 	defer cancelFc()
 	col, err := Collection(c, config, collection)
 	if err != nil {
@@ -153,7 +277,7 @@ func DeleteOne(c *m.Client, config config, collection string, id string) (interf
 }
 
 func DeleteMany(c *m.Client, config config, collection string, filter bson.M) (interface{}, error) {
-	ctx, cancelFc := Ctx()
+	ctx, cancelFc := config.ctx()
 	defer cancelFc()
 	col, err := Collection(c, config, collection)
 	if err != nil {
@@ -174,8 +298,18 @@ func Collection(c *m.Client, config config, collection string) (*m.Collection, e
 	}
 	return col, nil
 }
+// Ctx returns a context with the default timeout of MongoDB operations.
 func Ctx() (context.Context, context.CancelFunc) {
-	return context.WithTimeout(context.Background(), 10*time.Second)
+	return context.WithTimeout(context.Background(), defaultTimeout)
+}
+
+// ctx returns a context with the timeout of the backend,
+// see WithTimeout.
+func (c config) ctx() (context.Context, context.CancelFunc) {
+	if c.timeout <= 0 {
+		return Ctx()
+	}
+	return context.WithTimeout(context.Background(), c.timeout)
 }
 func filterById(id string) bson.M {
 	return bson.M{"_id": id}
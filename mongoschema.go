@@ -0,0 +1,199 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	m "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sort"
+	"time"
+)
+
+const (
+	// schemaId is the id of the schema version document.
+	schemaId = "rbac"
+	// duplicateKey is the code of the duplicate key error of MongoDB
+	duplicateKey = 11000
+)
+
+var (
+	// ErrSchemaVersion occurred if the database has a schema
+	// newer than the migrations know
+	ErrSchemaVersion = errors.New("unsupported schema version")
+	// ErrSchemaLocked occurred if another process kept migrating
+	// the schema for longer than the timeout of the backend
+	ErrSchemaLocked = errors.New("schema locked by another migration")
+	// migrations are the migrations of this package, see EnsureSchema.
+	// Migration 1 uses an update pipeline, which requires MongoDB 4.2.
+	migrations = []MongoMigration{
+		{
+			Version:     1,
+			Description: "set the expiry of inheritances limited in time",
+			Migrate: func(ctx context.Context, roles, inheritance *m.Collection) error {
+				_, err := inheritance.UpdateMany(ctx,
+					bson.M{"validity.notAfter": bson.M{"$exists": true}, "expiresAt": bson.M{"$exists": false}},
+					bson.A{bson.M{"$set": bson.M{"expiresAt": "$validity.notAfter"}}})
+				return err
+			},
+		},
+	}
+)
+
+// MongoMigration changes the documents stored by a MongoBackend
+// to the schema version `Version`. As a migration interrupted before
+// its version was stored runs again, it must be idempotent.
+type MongoMigration struct {
+	Version     int
+	Description string
+	// Migrate changes the documents of the roles and the inheritance
+	// collections; `ctx` expires after the timeout of the backend.
+	Migrate func(ctx context.Context, roles, inheritance *m.Collection) error
+}
+
+// SchemaVersion is the document storing the schema version.
+// LockedUntil is set while EnsureSchema migrates the schema.
+type SchemaVersion struct {
+	Id          string     `json:"id" bson:"_id"`
+	Version     int        `json:"version" bson:"version"`
+	UpdatedAt   time.Time  `json:"updatedAt" bson:"updatedAt"`
+	LockedUntil *time.Time `json:"lockedUntil,omitempty" bson:"lockedUntil,omitempty"`
+}
+
+// SchemaVersion returns the schema version of the database, 0 if
+// EnsureSchema never ran.
+func (b *MongoBackend) SchemaVersion() (int, error) {
+	res, err := FindOne(b.mongo, b.config, b.colSchema, schemaId, []*SchemaVersion{})
+	if err != nil {
+		return 0, err
+	}
+	if versions := res.([]*SchemaVersion); len(versions) > 0 {
+		return versions[0].Version, nil
+	}
+	return 0, nil
+}
+
+// EnsureSchema creates the indexes, see EnsureIndexes, and runs the
+// migrations newer than the schema version of the database ordered by
+// version, storing the version after each one. It returns
+// ErrSchemaVersion if the database is newer than the latest migration.
+// The version document is locked while migrating, so processes starting
+// at once migrate one after the other; a lock is released after the
+// timeout of the backend if its process died. The migrations of this
+// package require MongoDB 4.2 or newer.
+func (b *MongoBackend) EnsureSchema() error {
+	ms := append([]MongoMigration{}, b.migrations...)
+	sort.SliceStable(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+	latest := 0
+	for i, mm := range ms {
+		if mm.Version < 1 || i > 0 && mm.Version == ms[i-1].Version {
+			return fmt.Errorf("invalid migration version %d of %q", mm.Version, mm.Description)
+		}
+		latest = mm.Version
+	}
+	if err := b.EnsureIndexes(); err != nil {
+		return err
+	}
+	if err := b.lockSchema(); err != nil {
+		return err
+	}
+	defer b.unlockSchema()
+	current, err := b.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > latest {
+		return fmt.Errorf("%w %d, the latest known is %d", ErrSchemaVersion, current, latest)
+	}
+	for _, mm := range ms {
+		if mm.Version <= current {
+			continue
+		}
+		if err := b.migrate(mm); err != nil {
+			return fmt.Errorf("migration %d %q: %w", mm.Version, mm.Description, err)
+		}
+		// storing the version renews the lock for the next migration
+		now := time.Now().UTC()
+		_, err := UpdateOne(b.mongo, b.config, b.colSchema, schemaId, bson.M{"$set": bson.M{
+			"version": mm.Version, "updatedAt": now, "lockedUntil": now.Add(b.timeout()),
+		}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *MongoBackend) timeout() time.Duration {
+	if b.config.timeout <= 0 {
+		return defaultTimeout
+	}
+	return b.config.timeout
+}
+
+// lockSchema locks the version document, creating it if missing. It
+// waits for the lock of another process for up to the timeout of the
+// backend.
+func (b *MongoBackend) lockSchema() error {
+	col, err := Collection(b.mongo, b.config, b.colSchema)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(b.timeout())
+	for {
+		ctx, cancelFc := b.config.ctx()
+		now := time.Now().UTC()
+		// a locked document doesn't match, so the upsert
+		// fails with a duplicate key error
+		_, err := col.UpdateOne(ctx,
+			bson.M{"_id": schemaId, "$or": bson.A{
+				bson.M{"lockedUntil": bson.M{"$exists": false}},
+				bson.M{"lockedUntil": bson.M{"$lte": now}},
+			}},
+			bson.M{
+				"$set":         bson.M{"lockedUntil": now.Add(b.timeout())},
+				"$setOnInsert": bson.M{"version": 0, "updatedAt": now},
+			},
+			options.Update().SetUpsert(true))
+		cancelFc()
+		if !isDuplicateKey(err) {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return ErrSchemaLocked
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (b *MongoBackend) unlockSchema() {
+	UpdateOne(b.mongo, b.config, b.colSchema, schemaId, bson.M{"$unset": bson.M{"lockedUntil": ""}})
+}
+
+func isDuplicateKey(err error) bool {
+	var we m.WriteException
+	if errors.As(err, &we) {
+		for _, e := range we.WriteErrors {
+			if e.Code == duplicateKey {
+				return true
+			}
+		}
+	}
+	var ce m.CommandError
+	return errors.As(err, &ce) && ce.Code == duplicateKey
+}
+
+func (b *MongoBackend) migrate(mm MongoMigration) error {
+	ctx, cancelFc := b.config.ctx()
+	defer cancelFc()
+	roles, err := Collection(b.mongo, b.config, b.colRoles)
+	if err != nil {
+		return err
+	}
+	inheritance, err := Collection(b.mongo, b.config, b.colInher)
+	if err != nil {
+		return err
+	}
+	return mm.Migrate(ctx, roles, inheritance)
+}
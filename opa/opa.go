@@ -0,0 +1,204 @@
+// Package opa exports rbac-go policies as Open Policy Agent bundles.
+//
+// A bundle consists of `data.json`, holding the roles, their permissions
+// and the inheritance below `data.rbac`, and the Rego module `rbac.rego`.
+// The module decides `data.rbac.allow` for an input
+//
+//	{"role": "<role id>", "permission": "<permission>"}
+//
+// the same way RBAC.IsGranted does without an assertion: the role or
+// any of its ancestors has a permission whose regular expression matches
+// the requested permission, and none of them denies it. Conditions,
+// attribute conditions and validities cannot be evaluated by OPA, so
+// permissions requiring any are left out and denied permissions always
+// apply. Inheritances limited in time only pass on denied permissions,
+// which are kept below `denyParents`.
+package opa
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/mikespook/gorbac"
+	rbac2 "github.com/z26100/rbac-go"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Module is the Rego module of every bundle.
+const Module = `# Code generated by rbac-go. DO NOT EDIT.
+package rbac
+
+import future.keywords.if
+import future.keywords.in
+
+default allow := false
+
+# allow is true if input.role or any of its ancestors has a permission
+# matching input.permission, and none of them denies it.
+allow if {
+	some role in graph.reachable(data.rbac.parents, {input.role})
+	some pattern in data.rbac.roles[role].permissions
+	regex.match(pattern, input.permission)
+	not deny
+}
+
+# deny follows every inheritance, including the ones limited in time.
+deny if {
+	some role in graph.reachable(data.rbac.denyParents, {input.role})
+	some pattern in data.rbac.roles[role].deny
+	regex.match(pattern, input.permission)
+}
+`
+
+// Data is the document stored below `data.rbac`. Parents holds the
+// inheritances without a validity, DenyParents all of them.
+type Data struct {
+	Roles       map[string]Role     `json:"roles"`
+	Parents     map[string][]string `json:"parents"`
+	DenyParents map[string][]string `json:"denyParents"`
+}
+
+// Role lists the permissions of a role.
+type Role struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+	Deny        []string `json:"deny,omitempty"`
+}
+
+// Bundle is an OPA bundle.
+type Bundle struct {
+	Data Data
+}
+
+// Export returns the bundle of the current state of `rbac`.
+// Parents which are no roles are left out, as RBAC.IsGranted ignores them.
+func Export(rbac *rbac2.RBAC) (*Bundle, error) {
+	d := Data{
+		Roles:       make(map[string]Role),
+		Parents:     make(map[string][]string),
+		DenyParents: make(map[string][]string),
+	}
+	parents := make(map[string][]string)
+	err := rbac2.Walk(rbac, func(r gorbac.Role, p []string) error {
+		role := Role{Name: r.ID(), Permissions: []string{}}
+		if rr, ok := r.(*rbac2.RBACRole); ok {
+			role.Name = rr.Name
+			for id, permission := range rr.Permissions {
+				if len(permission.Conditions) == 0 && len(permission.When) == 0 && permission.Expr == "" &&
+					permission.Validity == nil {
+					role.Permissions = append(role.Permissions, id)
+				}
+			}
+			for id := range rr.Deny {
+				role.Deny = append(role.Deny, id)
+			}
+			sort.Strings(role.Deny)
+		} else if rr, ok := r.(interface{ GetPermissions() []gorbac.Permission }); ok {
+			for _, permission := range rr.GetPermissions() {
+				role.Permissions = append(role.Permissions, permission.ID())
+			}
+		}
+		sort.Strings(role.Permissions)
+		d.Roles[r.ID()] = role
+		parents[r.ID()] = p
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for id, p := range parents {
+		d.Parents[id] = []string{}
+		d.DenyParents[id] = []string{}
+		for _, parent := range p {
+			if _, ok := d.Roles[parent]; !ok {
+				continue
+			}
+			v, err := rbac.GetParentValidity(id, parent)
+			if err != nil {
+				return nil, err
+			}
+			if v == nil {
+				d.Parents[id] = append(d.Parents[id], parent)
+			}
+			d.DenyParents[id] = append(d.DenyParents[id], parent)
+		}
+		sort.Strings(d.Parents[id])
+		sort.Strings(d.DenyParents[id])
+	}
+	return &Bundle{Data: d}, nil
+}
+
+// files returns the files of the bundle by their path.
+func (b *Bundle) files() (map[string][]byte, error) {
+	data, err := json.MarshalIndent(map[string]Data{"rbac": b.Data}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{
+		".manifest": []byte(`{"roots": ["rbac"]}` + "\n"),
+		"data.json": append(data, '\n'),
+		"rbac.rego": []byte(Module),
+	}, nil
+}
+
+// WriteDir writes the files of the bundle to the directory `dir`,
+// which can be loaded by `opa run --bundle dir`.
+func (b *Bundle) WriteDir(dir string) error {
+	files, err := b.files()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, name := range sortedPaths(files) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), files[name], 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTarGz writes the bundle as a gzipped tarball to `w`,
+// the format OPA downloads bundles in.
+func (b *Bundle) WriteTarGz(w io.Writer) error {
+	files, err := b.files()
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, name := range sortedPaths(files) {
+		hdr := &tar.Header{
+			Name:    "/" + name,
+			Mode:    0644,
+			Size:    int64(len(files[name])),
+			ModTime: time.Unix(0, 0),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, bytes.NewReader(files[name])); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func sortedPaths(files map[string][]byte) []string {
+	result := make([]string, 0, len(files))
+	for name := range files {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
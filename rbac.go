@@ -6,23 +6,32 @@ import (
 	"github.com/mikespook/gorbac"
 	"regexp"
 	"strings"
+	"time"
 )
 
 type RBACRole struct {
 	Name        string
 	Permissions map[string]*RBACPermission
+	// Deny holds the permissions denied to the role and its children.
+	// A denied permission overrides every granted one.
+	Deny map[string]*RBACPermission
+	// Source is the policy file the role was loaded from, if any.
+	Source string
 }
 
 func (r RBACRole) ID() string {
 	return strings.ToLower(r.Name)
 }
 
+// Permit reports whether the role has a permission matching `action`.
+// Permissions with conditions or a validity are ignored, as they can
+// only be checked by RBAC.IsGranted and RBAC.IsGrantedWithContext.
 func (r RBACRole) Permit(action gorbac.Permission) bool {
 	if r.Permissions == nil {
 		return false
 	}
 	for _, v := range r.Permissions {
-		if v.Match(action) {
+		if len(v.Conditions) == 0 && len(v.When) == 0 && v.Expr == "" && v.Validity == nil && v.Match(action) {
 			return true
 		}
 	}
@@ -37,6 +46,23 @@ func (r *RBACRole) AddPermission(permission *RBACPermission) error {
 	return nil
 }
 
+func (r *RBACRole) RemovePermission(id string) {
+	delete(r.Permissions, id)
+}
+
+// AddDeny denies the permission to the role.
+func (r *RBACRole) AddDeny(permission *RBACPermission) error {
+	if r.Deny == nil {
+		r.Deny = make(map[string]*RBACPermission)
+	}
+	r.Deny[permission.ID()] = permission
+	return nil
+}
+
+func (r *RBACRole) RemoveDeny(id string) {
+	delete(r.Deny, id)
+}
+
 func (r RBACRole) GetPermissions() []gorbac.Permission {
 	var result []gorbac.Permission
 	for _, v := range r.Permissions {
@@ -45,8 +71,27 @@ func (r RBACRole) GetPermissions() []gorbac.Permission {
 	return result
 }
 
+func (r RBACRole) GetDenies() []gorbac.Permission {
+	var result []gorbac.Permission
+	for _, v := range r.Deny {
+		result = append(result, v)
+	}
+	return result
+}
+
 type RBACPermission struct {
 	Name string
+	// Conditions names the registered conditions which must hold
+	// for the permission to match, see RegisterCondition.
+	Conditions []string
+	// When lists the attribute conditions which must hold
+	// for the permission to match, see IsGrantedWithContext.
+	When []AttributeCondition
+	// Expr is an expression which must evaluate to true
+	// for the permission to match, see CompileExpression.
+	Expr string
+	// Validity limits when the permission matches, see RBAC.SetClock.
+	Validity *Validity
 }
 
 func (p RBACPermission) ID() string {
@@ -79,6 +124,7 @@ func CloseRBAC() {
 // RBAC object, in most cases it should be used as a singleton.
 type RBAC struct {
 	backend Backend
+	clock   func() time.Time
 }
 
 var (
@@ -87,17 +133,50 @@ var (
 	ErrRoleNotExist = errors.New("role does not exist")
 	// ErrRoleExist occurred if a role shouldn't be found
 	ErrRoleExist = errors.New("role has already existed")
-	empty        = struct{}{}
+	// ErrParentNotExist occurred if a role doesn't inherit from a parent
+	ErrParentNotExist = errors.New("parent does not exist")
+	empty             = struct{}{}
 )
 
 func (rbac *RBAC) Close() error {
 	return rbac.backend.Close()
 }
 
+// Clear removes all roles, their inheritance and the constraints.
 func (rbac *RBAC) Clear() error {
+	rbac.backend.Lock()
+	defer rbac.backend.Unlock()
 	return rbac.backend.Clear()
 }
 
+// SetClock sets the function returning the time validities and
+// expressions are checked at. nil selects time.Now.
+func (rbac *RBAC) SetClock(now func() time.Time) {
+	rbac.backend.Lock()
+	defer rbac.backend.Unlock()
+	rbac.clock = now
+}
+
+// Clock returns the function set by SetClock, nil for time.Now.
+func (rbac *RBAC) Clock() func() time.Time {
+	rbac.backend.RLock()
+	defer rbac.backend.RUnlock()
+	return rbac.clock
+}
+
+// SharesBackend reports whether `rbac` and `other` store their roles
+// in the same backend, so changing one changes the other.
+func (rbac *RBAC) SharesBackend(other *RBAC) bool {
+	return rbac.backend == other.backend
+}
+
+func (rbac *RBAC) now() time.Time {
+	if rbac.clock == nil {
+		return time.Now()
+	}
+	return rbac.clock()
+}
+
 // Assign a permission to the role.
 func (rbac *RBAC) AssignRole(role *RBACRole, p *RBACPermission) error {
 	rbac.backend.Lock()
@@ -108,7 +187,13 @@ func (rbac *RBAC) AssignRole(role *RBACRole, p *RBACPermission) error {
 // SetParents bind `parents` to the role `id`.
 // If the role or any of parents is not existing,
 // an error will be returned.
+// If any of parents would close an inheritance circle,
+// a CircleError will be returned and nothing is bound.
+// If the parents would violate a constraint, a ConstraintError
+// will be returned and nothing is bound.
 func (rbac *RBAC) SetParents(id string, parents []string) error {
+	rbac.backend.Lock()
+	defer rbac.backend.Unlock()
 	if _, ok := rbac.backend.GetRole(id); !ok {
 		return ErrRoleNotExist
 	}
@@ -117,11 +202,26 @@ func (rbac *RBAC) SetParents(id string, parents []string) error {
 			return ErrRoleNotExist
 		}
 	}
+	for _, parent := range parents {
+		if err := rbac.checkCircle(id, parent); err != nil {
+			return err
+		}
+	}
+	err := rbac.checkConstraints([]string{id}, func(g *graph) {
+		for _, parent := range parents {
+			g.bind(id, parent)
+		}
+	})
+	if err != nil {
+		return err
+	}
 	if _, ok := rbac.backend.GetParents(id); !ok {
 		rbac.backend.SetParents(id, make(map[string]struct{}))
 	}
 	for _, parent := range parents {
-		rbac.backend.SetParent(id, parent, empty)
+		if err := rbac.backend.SetParent(id, parent, empty); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -150,6 +250,10 @@ func (rbac *RBAC) GetParents(id string) ([]string, error) {
 // SetParent bind the `parent` to the role `id`.
 // If the role or the parent is not existing,
 // an error will be returned.
+// If the parent would close an inheritance circle,
+// a CircleError will be returned.
+// If the parent would violate a constraint,
+// a ConstraintError will be returned.
 func (rbac *RBAC) SetParent(id string, parent string) error {
 	rbac.backend.Lock()
 	defer rbac.backend.Unlock()
@@ -159,17 +263,26 @@ func (rbac *RBAC) SetParent(id string, parent string) error {
 	if _, ok := rbac.backend.GetRole(parent); !ok {
 		return ErrRoleNotExist
 	}
+	if err := rbac.checkCircle(id, parent); err != nil {
+		return err
+	}
+	err := rbac.checkConstraints([]string{id}, func(g *graph) {
+		g.bind(id, parent)
+	})
+	if err != nil {
+		return err
+	}
 	if _, ok := rbac.backend.GetParents(id); !ok {
 		rbac.backend.SetParents(id, make(map[string]struct{}))
 	}
-	var empty struct{}
-	rbac.backend.SetParent(id, parent, empty)
-	return nil
+	return rbac.backend.SetParent(id, parent, empty)
 }
 
 // RemoveParent unbind the `parent` with the role `id`.
 // If the role or the parent is not existing,
 // an error will be returned.
+// If unbinding the parent would violate a constraint,
+// an error unwrapping to ErrConstraintViolated will be returned.
 func (rbac *RBAC) RemoveParent(id string, parent string) error {
 	rbac.backend.Lock()
 	defer rbac.backend.Unlock()
@@ -179,10 +292,55 @@ func (rbac *RBAC) RemoveParent(id string, parent string) error {
 	if _, ok := rbac.backend.GetRole(parent); !ok {
 		return ErrRoleNotExist
 	}
+	err := rbac.checkConstraints([]string{id, parent}, func(g *graph) {
+		g.unbind(id, parent)
+	})
+	if err != nil {
+		return err
+	}
 	rbac.backend.DeleteParent(id, parent)
 	return nil
 }
 
+// SetParentValidity limits when the role `id` inherits from `parent`.
+// A nil Validity removes the limit. If the role does not inherit from
+// the parent, ErrParentNotExist will be returned.
+func (rbac *RBAC) SetParentValidity(id string, parent string, v *Validity) error {
+	if v != nil {
+		if err := v.Validate(); err != nil {
+			return err
+		}
+	}
+	rbac.backend.Lock()
+	defer rbac.backend.Unlock()
+	if _, ok := rbac.backend.GetRole(id); !ok {
+		return ErrRoleNotExist
+	}
+	if parents, _ := rbac.backend.GetParents(id); !hasParent(parents, parent) {
+		return ErrParentNotExist
+	}
+	return rbac.backend.SetParentValidity(id, parent, v)
+}
+
+// GetParentValidity returns the validity of the inheritance of `parent`
+// by the role `id`, or nil if it isn't limited.
+func (rbac *RBAC) GetParentValidity(id string, parent string) (*Validity, error) {
+	rbac.backend.RLock()
+	defer rbac.backend.RUnlock()
+	if _, ok := rbac.backend.GetRole(id); !ok {
+		return nil, ErrRoleNotExist
+	}
+	if parents, _ := rbac.backend.GetParents(id); !hasParent(parents, parent) {
+		return nil, ErrParentNotExist
+	}
+	return rbac.backend.GetParentValidities(id)[parent], nil
+}
+
+func hasParent(parents map[string]struct{}, parent string) bool {
+	_, ok := parents[parent]
+	return ok
+}
+
 // Add a role `r`.
 func (rbac *RBAC) Add(r gorbac.Role) (err error) {
 	rbac.backend.Lock()
@@ -206,10 +364,23 @@ func (rbac *RBAC) Set(r gorbac.Role) (err error) {
 }
 
 // Remove the role by `id`.
+// If removing the role would violate a constraint,
+// an error unwrapping to ErrConstraintViolated will be returned.
 func (rbac *RBAC) Remove(id string) (err error) {
 	rbac.backend.Lock()
 	if _, ok := rbac.backend.GetRole(id); ok {
-		rbac.backend.DeleteRole(id)
+		parents, _ := rbac.backend.GetParents(id)
+		err = rbac.checkConstraints(append([]string{id}, sortedKeys(parents)...), func(g *graph) {
+			g.remove(id)
+		})
+		if err != nil {
+			rbac.backend.Unlock()
+			return err
+		}
+		if err = rbac.backend.DeleteRole(id); err != nil {
+			rbac.backend.Unlock()
+			return err
+		}
 		for rid, parents := range rbac.backend.GetAllParents() {
 			if rid == id {
 				rbac.backend.DeleteParents(rid)
@@ -246,9 +417,23 @@ func (rbac *RBAC) Get(id string) (r gorbac.Role, parents []string, err error) {
 }
 
 // IsGranted tests if the role `id` has Permission `p` with the condition `assert`.
+// Permissions with attribute conditions are not granted, see IsGrantedWithContext.
 func (rbac *RBAC) IsGranted(id string, p gorbac.Permission, assert AssertionFunc) (rslt bool) {
 	rbac.backend.RLock()
-	rslt = rbac.isGranted(id, p, assert)
+	rslt = rbac.isGranted(id, p, nil, assert)
+	rbac.backend.RUnlock()
+	return
+}
+
+// IsGrantedWithContext tests if the role `id` has Permission `p` with the
+// condition `assert`, evaluating the attribute conditions of permissions
+// against `attrs`. A granted permission matches only if the attributes
+// its conditions refer to exist, while a denied permission also matches
+// if they are missing.
+func (rbac *RBAC) IsGrantedWithContext(id string, p gorbac.Permission, attrs Attributes,
+	assert AssertionFunc) (rslt bool) {
+	rbac.backend.RLock()
+	rslt = rbac.isGranted(id, p, attrs, assert)
 	rbac.backend.RUnlock()
 	return
 }
@@ -256,22 +441,50 @@ func (rbac *RBAC) IsGranted(id string, p gorbac.Permission, assert AssertionFunc
 // AssertionFunc supplies more fine-grained permission controls.
 type AssertionFunc func(*RBAC, string, gorbac.Permission) bool
 
-func (rbac *RBAC) isGranted(id string, p gorbac.Permission, assert AssertionFunc) bool {
+func (rbac *RBAC) isGranted(id string, p gorbac.Permission, attrs Attributes, assert AssertionFunc) bool {
 	if assert != nil && !assert(rbac, id, p) {
 		return false
 	}
-	return rbac.recursionCheck(id, p)
+	r := request{subject: id, permission: p, attrs: attrs, now: rbac.now()}
+	if rbac.ancestorMatches(r, id, true, make(map[string]struct{})) {
+		return false
+	}
+	return rbac.ancestorMatches(r, id, false, make(map[string]struct{}))
 }
 
 func (rbac *RBAC) recursionCheck(id string, p gorbac.Permission) bool {
+	r := request{subject: id, permission: p, now: rbac.now()}
+	return rbac.ancestorMatches(r, id, false, make(map[string]struct{}))
+}
+
+// request is a permission requested for the role `subject`.
+type request struct {
+	subject    string
+	permission gorbac.Permission
+	attrs      Attributes
+	now        time.Time
+}
+
+// ancestorMatches reports whether the role `id` or any of its ancestors
+// grants, or denies if `deny` is set, the requested permission.
+// Parents are skipped while their inheritance isn't valid.
+func (rbac *RBAC) ancestorMatches(r request, id string, deny bool, visited map[string]struct{}) bool {
+	if _, ok := visited[id]; ok {
+		return false
+	}
+	visited[id] = empty
 	if role, ok := rbac.backend.GetRole(id); ok {
-		if role.Permit(p) {
+		if rbac.matches(r, role, deny) {
 			return true
 		}
 		if parents, ok := rbac.backend.GetParents(id); ok {
+			validities := rbac.backend.GetParentValidities(id)
 			for pID := range parents {
+				if !validities[pID].Active(r.now) {
+					continue
+				}
 				if _, ok := rbac.backend.GetRole(pID); ok {
-					if rbac.recursionCheck(pID, p) {
+					if rbac.ancestorMatches(r, pID, deny, visited) {
 						return true
 					}
 				}
@@ -281,6 +494,25 @@ func (rbac *RBAC) recursionCheck(id string, p gorbac.Permission) bool {
 	return false
 }
 
+// matches reports whether `role` itself grants, or denies if `deny`
+// is set, the requested permission.
+func (rbac *RBAC) matches(r request, role gorbac.Role, deny bool) bool {
+	rr, ok := role.(*RBACRole)
+	if !ok {
+		return !deny && role.Permit(r.permission)
+	}
+	permissions := rr.Permissions
+	if deny {
+		permissions = rr.Deny
+	}
+	for _, permission := range permissions {
+		if permission.Match(r.permission) && permission.holds(rbac, r, deny) {
+			return true
+		}
+	}
+	return false
+}
+
 // Walk passes each Role to WalkHandler
 func Walk(rbac *RBAC, h gorbac.WalkHandler) (err error) {
 	if h == nil {
@@ -303,42 +535,12 @@ func Walk(rbac *RBAC, h gorbac.WalkHandler) (err error) {
 }
 
 // InherCircle returns an error when detecting any circle inheritance.
+// The returned CircleError lists every circle found.
 func InherCircle(rbac *RBAC) (err error) {
-	rbac.backend.Lock()
-
-	skipped := make(map[string]struct{}, len(rbac.backend.GetRoles()))
-	var stack []string
-
-	for id := range rbac.backend.GetRoles() {
-		if err = dfs(rbac, id, skipped, stack); err != nil {
-			break
-		}
-	}
-	rbac.backend.Unlock()
-	return err
-}
-
-// https://en.wikipedia.org/wiki/Depth-first_search
-func dfs(rbac *RBAC, id string, skipped map[string]struct{}, stack []string) error {
-	if _, ok := skipped[id]; ok {
-		return nil
-	}
-	for _, item := range stack {
-		if item == id {
-			return ErrFoundCircle
-		}
-	}
-	parents, _ := rbac.backend.GetParents(id)
-	if len(parents) == 0 {
-		stack = nil
-		skipped[id] = empty
-		return nil
-	}
-	stack = append(stack, id)
-	for pid := range parents {
-		if err := dfs(rbac, pid, skipped, stack); err != nil {
-			return err
-		}
+	rbac.backend.RLock()
+	defer rbac.backend.RUnlock()
+	if paths := rbac.cycles(); len(paths) > 0 {
+		return &CircleError{Paths: paths}
 	}
 	return nil
 }
@@ -348,7 +550,7 @@ func AnyGranted(rbac *RBAC, roles []string, permission gorbac.Permission,
 	assert AssertionFunc) (rslt bool) {
 	rbac.backend.Lock()
 	for _, role := range roles {
-		if rbac.isGranted(role, permission, assert) {
+		if rbac.isGranted(role, permission, nil, assert) {
 			rslt = true
 			break
 		}
@@ -362,7 +564,7 @@ func AllGranted(rbac *RBAC, roles []string, permission gorbac.Permission,
 	assert AssertionFunc) (rslt bool) {
 	rbac.backend.Lock()
 	for _, role := range roles {
-		if !rbac.isGranted(role, permission, assert) {
+		if !rbac.isGranted(role, permission, nil, assert) {
 			rslt = true
 			break
 		}
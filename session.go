@@ -0,0 +1,242 @@
+package rbac
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mikespook/gorbac"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrRoleNotHeld occurred if a session activates a role its subject doesn't hold
+	ErrRoleNotHeld = errors.New("role is not held by the subject")
+	// ErrSessionExpired occurred if an expired or closed session is used
+	ErrSessionExpired = errors.New("session has expired")
+	// ErrReauthenticationFailed occurred if a privileged role was activated
+	// without re-authentication
+	ErrReauthenticationFailed = errors.New("re-authentication failed")
+)
+
+// SessionOptions configures a session, see RBAC.NewSession.
+type SessionOptions struct {
+	// TTL ends the session after the duration; 0 keeps it open until Close.
+	TTL time.Duration
+	// Privileged lists the roles which can only be activated after
+	// Reauthenticate succeeded. Roles inheriting from them are privileged
+	// as well.
+	Privileged []string
+	// Reauthenticate is called before a privileged role is activated,
+	// an error refuses the activation. If it's nil, privileged roles
+	// can't be activated at all.
+	Reauthenticate func(subject, role string) error
+}
+
+// Session is a subject working with a subset of its roles. Only the
+// activated roles, and the roles they inherit from, grant or deny
+// permissions, and the dynamic separation of duty constraints limit
+// which roles can be active at the same time.
+type Session struct {
+	rbac    *RBAC
+	subject string
+	opts    SessionOptions
+	expires time.Time
+	closed  bool
+	active  map[string]struct{}
+	mux     sync.Mutex
+}
+
+// NewSession returns a session of the role `subject` without active roles.
+func (rbac *RBAC) NewSession(subject string, opts SessionOptions) (*Session, error) {
+	subject = strings.ToLower(subject)
+	rbac.backend.RLock()
+	_, ok := rbac.backend.GetRole(subject)
+	rbac.backend.RUnlock()
+	if !ok {
+		return nil, ErrRoleNotExist
+	}
+	s := &Session{
+		rbac:    rbac,
+		subject: subject,
+		opts:    opts,
+		active:  make(map[string]struct{}),
+	}
+	s.opts.Privileged = lowerAll(opts.Privileged)
+	if opts.TTL > 0 {
+		s.expires = rbac.now().Add(opts.TTL)
+	}
+	return s, nil
+}
+
+// Subject returns the role the session was created for.
+func (s *Session) Subject() string {
+	return s.subject
+}
+
+// Expires returns the end of the session, zero if it has no TTL.
+func (s *Session) Expires() time.Time {
+	return s.expires
+}
+
+// Expired reports whether the session was closed or its TTL passed.
+func (s *Session) Expired() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.expired()
+}
+
+func (s *Session) expired() bool {
+	return s.closed || !s.expires.IsZero() && !s.rbac.now().Before(s.expires)
+}
+
+// Activate activates the roles `ids`, which the subject must hold,
+// itself or by inheritance. Either all roles are activated or none:
+// a role the subject doesn't hold returns ErrRoleNotHeld, a dynamic
+// separation of duty violation a ConstraintError and a refused
+// re-authentication an error wrapping ErrReauthenticationFailed.
+func (s *Session) Activate(ids ...string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.expired() {
+		return ErrSessionExpired
+	}
+	ids = lowerAll(ids)
+	s.rbac.backend.RLock()
+	held := s.rbac.heldAt(s.subject, s.rbac.now())
+	var privileged []string
+	for _, id := range ids {
+		if _, ok := held[id]; !ok {
+			s.rbac.backend.RUnlock()
+			return fmt.Errorf("%w: %q", ErrRoleNotHeld, id)
+		}
+		if _, ok := s.active[id]; !ok && s.privileged(id) {
+			privileged = append(privileged, id)
+		}
+	}
+	s.rbac.backend.RUnlock()
+	active := append(sortedKeys(s.active), ids...)
+	if err := s.rbac.CheckActiveRoles(s.subject, active); err != nil {
+		return err
+	}
+	// ask for re-authentication last, as it may involve the user
+	for _, id := range privileged {
+		if s.opts.Reauthenticate == nil {
+			return fmt.Errorf("%w: role %q is privileged", ErrReauthenticationFailed, id)
+		}
+		if err := s.opts.Reauthenticate(s.subject, id); err != nil {
+			return fmt.Errorf("%w: role %q: %v", ErrReauthenticationFailed, id, err)
+		}
+	}
+	for _, id := range ids {
+		s.active[id] = empty
+	}
+	return nil
+}
+
+// privileged reports whether the role `id` is or inherits from a
+// privileged role. The backend must be locked.
+func (s *Session) privileged(id string) bool {
+	if len(s.opts.Privileged) == 0 {
+		return false
+	}
+	held := s.rbac.graph().held(id)
+	for _, pid := range s.opts.Privileged {
+		if _, ok := held[pid]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Deactivate deactivates the roles `ids`; inactive roles are ignored.
+func (s *Session) Deactivate(ids ...string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, id := range ids {
+		delete(s.active, strings.ToLower(id))
+	}
+}
+
+// ActiveRoles returns the sorted active roles.
+func (s *Session) ActiveRoles() []string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.expired() {
+		return nil
+	}
+	return sortedKeys(s.active)
+}
+
+// Close deactivates all roles and ends the session.
+func (s *Session) Close() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.active = make(map[string]struct{})
+	s.closed = true
+}
+
+// IsGranted tests if the active roles have Permission `p` with the
+// condition `assert`, see RBAC.IsGranted. An expired session has no
+// permissions.
+func (s *Session) IsGranted(p gorbac.Permission, assert AssertionFunc) bool {
+	return s.IsGrantedWithContext(p, nil, assert)
+}
+
+// IsGrantedWithContext tests if the active roles have Permission `p`
+// with the condition `assert` and the attributes `attrs`, see
+// RBAC.IsGrantedWithContext. Active roles the subject no longer holds
+// are ignored.
+func (s *Session) IsGrantedWithContext(p gorbac.Permission, attrs Attributes, assert AssertionFunc) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.expired() {
+		return false
+	}
+	rbac := s.rbac
+	rbac.backend.RLock()
+	defer rbac.backend.RUnlock()
+	if assert != nil && !assert(rbac, s.subject, p) {
+		return false
+	}
+	r := request{subject: s.subject, permission: p, attrs: attrs, now: rbac.now()}
+	held := rbac.heldAt(s.subject, r.now)
+	var active []string
+	for _, id := range sortedKeys(s.active) {
+		if _, ok := held[id]; ok {
+			active = append(active, id)
+		}
+	}
+	for _, id := range active {
+		if rbac.ancestorMatches(r, id, true, make(map[string]struct{})) {
+			return false
+		}
+	}
+	for _, id := range active {
+		if rbac.ancestorMatches(r, id, false, make(map[string]struct{})) {
+			return true
+		}
+	}
+	return false
+}
+
+// heldAt returns the role `id` and the roles it inherits from at `t`,
+// skipping inheritance which isn't valid then. The backend must be locked.
+func (rbac *RBAC) heldAt(id string, t time.Time) map[string]struct{} {
+	result := map[string]struct{}{id: empty}
+	next := func(current string) (map[string]struct{}, bool) {
+		parents, _ := rbac.backend.GetParents(current)
+		validities := rbac.backend.GetParentValidities(current)
+		active := make(map[string]struct{}, len(parents))
+		for pid := range parents {
+			if validities[pid].Active(t) {
+				active[pid] = empty
+			}
+		}
+		return active, len(active) > 0
+	}
+	for _, hid := range rbac.traverse(id, next) {
+		result[hid] = empty
+	}
+	return result
+}
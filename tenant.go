@@ -0,0 +1,287 @@
+package rbac
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mikespook/gorbac"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// GlobalPrefix marks the ids of global roles within a tenant: a tenant
+// role inherits from the global role `auditor` by the parent
+// `global:auditor`.
+const GlobalPrefix = "global:"
+
+var (
+	// ErrInvalidTenant occurred if a tenant name can't be used
+	ErrInvalidTenant = errors.New("invalid tenant name")
+	// ErrGlobalRole occurred if a tenant changes a global role
+	ErrGlobalRole = errors.New("global roles are read-only within a tenant")
+	tenantName    = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// ValidateTenant returns ErrInvalidTenant unless `name` consists of
+// letters, digits, `_` and `-` only.
+func ValidateTenant(name string) error {
+	if !tenantName.MatchString(name) {
+		return fmt.Errorf("%w %q", ErrInvalidTenant, name)
+	}
+	return nil
+}
+
+// Tenants keeps a RBAC instance per tenant. Each tenant has its own
+// backend, so role ids, stored roles and inheritance, constraints and
+// the clock are isolated between tenants. Tenant roles can inherit
+// from the roles of the global RBAC instance by prefixing their ids
+// with GlobalPrefix.
+type Tenants struct {
+	global  *RBAC
+	backend func(tenant string) (Backend, error)
+	tenants map[string]*RBAC
+	mutex   sync.Mutex
+}
+
+// NewTenants returns tenants whose backends are created by `backend`,
+// for instance by MongoBackend.Tenant. `global` holds the roles shared
+// by all tenants and may be nil.
+func NewTenants(global *RBAC, backend func(tenant string) (Backend, error)) *Tenants {
+	return &Tenants{
+		global:  global,
+		backend: backend,
+		tenants: make(map[string]*RBAC),
+	}
+}
+
+// Global returns the RBAC instance holding the global roles, if any.
+func (t *Tenants) Global() *RBAC {
+	return t.global
+}
+
+// Tenant returns the RBAC instance of the tenant `name`,
+// creating its backend on first use.
+func (t *Tenants) Tenant(name string) (*RBAC, error) {
+	if err := ValidateTenant(name); err != nil {
+		return nil, err
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if rbac, ok := t.tenants[name]; ok {
+		return rbac, nil
+	}
+	backend, err := t.backend(name)
+	if err != nil {
+		return nil, err
+	}
+	if t.global != nil {
+		backend = &tenantBackend{local: backend, global: t.global.backend}
+	}
+	rbac := New(backend)
+	t.tenants[name] = rbac
+	return rbac, nil
+}
+
+// Names returns the sorted names of the tenants in use.
+func (t *Tenants) Names() []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	result := make([]string, 0, len(t.tenants))
+	for name := range t.tenants {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// DeleteTenant removes the roles and the inheritance of the tenant
+// `name` from its backend, see Backend.Clear. The collections of a
+// MongoBackend are kept with their indexes, so the tenant can be
+// created again. Global roles aren't touched. The backend of a tenant not in use is
+// created to be cleared.
+func (t *Tenants) DeleteTenant(name string) error {
+	rbac, err := t.Tenant(name)
+	if err != nil {
+		return err
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	rbac.backend.Lock()
+	err = rbac.backend.Clear()
+	rbac.backend.Unlock()
+	if err != nil {
+		return err
+	}
+	delete(t.tenants, name)
+	return nil
+}
+
+// tenantBackend is the backend of a tenant which resolves the ids
+// prefixed with GlobalPrefix to the read-only roles of `global`.
+type tenantBackend struct {
+	local  Backend
+	global Backend
+}
+
+func globalId(id string) (string, bool) {
+	if strings.HasPrefix(id, GlobalPrefix) {
+		return strings.TrimPrefix(id, GlobalPrefix), true
+	}
+	return id, false
+}
+
+// prefixed returns the global ids `ids` with GlobalPrefix.
+func prefixed(ids map[string]struct{}) map[string]struct{} {
+	result := make(map[string]struct{}, len(ids))
+	for id := range ids {
+		result[GlobalPrefix+id] = empty
+	}
+	return result
+}
+
+// Lock locks the global backend for reading as well,
+// always before the tenant backend.
+func (b *tenantBackend) Lock() {
+	b.global.RLock()
+	b.local.Lock()
+}
+
+func (b *tenantBackend) RLock() {
+	b.global.RLock()
+	b.local.RLock()
+}
+
+func (b *tenantBackend) Unlock() {
+	b.local.Unlock()
+	b.global.RUnlock()
+}
+
+func (b *tenantBackend) RUnlock() {
+	b.local.RUnlock()
+	b.global.RUnlock()
+}
+
+func (b *tenantBackend) Clear() error {
+	return b.local.Clear()
+}
+
+func (b *tenantBackend) Close() error {
+	return b.local.Close()
+}
+
+func (b *tenantBackend) GetRoles() map[string]gorbac.Role {
+	return b.local.GetRoles()
+}
+
+func (b *tenantBackend) GetRole(id string) (gorbac.Role, bool) {
+	if gid, ok := globalId(id); ok {
+		return b.global.GetRole(gid)
+	}
+	return b.local.GetRole(id)
+}
+
+func (b *tenantBackend) SetRole(id string, role gorbac.Role) error {
+	if _, ok := globalId(id); ok {
+		return ErrGlobalRole
+	}
+	return b.local.SetRole(id, role)
+}
+
+func (b *tenantBackend) DeleteRole(id string) error {
+	if _, ok := globalId(id); ok {
+		return ErrGlobalRole
+	}
+	return b.local.DeleteRole(id)
+}
+
+func (b *tenantBackend) GetAllParents() map[string]map[string]struct{} {
+	return b.local.GetAllParents()
+}
+
+func (b *tenantBackend) GetParents(id string) (map[string]struct{}, bool) {
+	if gid, ok := globalId(id); ok {
+		parents, ok := b.global.GetParents(gid)
+		return prefixed(parents), ok
+	}
+	return b.local.GetParents(id)
+}
+
+// GetChildren returns the tenant roles inheriting from the role `id`,
+// and the global ones for a global role.
+func (b *tenantBackend) GetChildren(id string) (map[string]struct{}, bool) {
+	gid, ok := globalId(id)
+	if !ok {
+		return b.local.GetChildren(id)
+	}
+	result := make(map[string]struct{})
+	children, _ := b.local.GetChildren(id)
+	for cid := range children {
+		result[cid] = empty
+	}
+	children, _ = b.global.GetChildren(gid)
+	for cid := range prefixed(children) {
+		result[cid] = empty
+	}
+	return result, len(result) > 0
+}
+
+func (b *tenantBackend) SetParent(id string, pid string, p struct{}) error {
+	if _, ok := globalId(id); ok {
+		return ErrGlobalRole
+	}
+	return b.local.SetParent(id, pid, p)
+}
+
+func (b *tenantBackend) SetParents(id string, p map[string]struct{}) {
+	if _, ok := globalId(id); !ok {
+		b.local.SetParents(id, p)
+	}
+}
+
+func (b *tenantBackend) DeleteParents(id string) error {
+	if _, ok := globalId(id); ok {
+		return ErrGlobalRole
+	}
+	return b.local.DeleteParents(id)
+}
+
+func (b *tenantBackend) DeleteParent(id, pid string) error {
+	if _, ok := globalId(id); ok {
+		return ErrGlobalRole
+	}
+	return b.local.DeleteParent(id, pid)
+}
+
+func (b *tenantBackend) GetParentValidities(id string) map[string]*Validity {
+	gid, ok := globalId(id)
+	if !ok {
+		return b.local.GetParentValidities(id)
+	}
+	result := make(map[string]*Validity)
+	for pid, v := range b.global.GetParentValidities(gid) {
+		result[GlobalPrefix+pid] = v
+	}
+	return result
+}
+
+func (b *tenantBackend) SetParentValidity(id, pid string, v *Validity) error {
+	if _, ok := globalId(id); ok {
+		return ErrGlobalRole
+	}
+	return b.local.SetParentValidity(id, pid, v)
+}
+
+// GetConstraints returns the constraints of the tenant; constraints
+// of the global roles don't apply to it.
+func (b *tenantBackend) GetConstraints() []Constraint {
+	return b.local.GetConstraints()
+}
+
+func (b *tenantBackend) SetConstraint(c Constraint) error {
+	return b.local.SetConstraint(c)
+}
+
+func (b *tenantBackend) DeleteConstraint(name string) error {
+	return b.local.DeleteConstraint(name)
+}
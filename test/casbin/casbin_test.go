@@ -0,0 +1,150 @@
+package casbin
+
+import (
+	"bytes"
+	rbac2 "github.com/z26100/rbac-go"
+	auth "github.com/z26100/rbac-go/auth"
+	"github.com/z26100/rbac-go/casbin"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestImport(t *testing.T) {
+	f, err := os.Open("policy.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p, warnings, err := casbin.Import(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"line 6: policy with 4 values is not supported, only `p, sub, obj, act, eft` can be represented",
+		"line 9: roles with domains are not supported",
+		`line 10: policy type "g2" is not supported`,
+	}
+	if len(warnings) != len(expected) {
+		t.Fatal("unexpected warnings", warnings)
+	}
+	for i, w := range warnings {
+		if w.String() != expected[i] {
+			t.Fatal("unexpected warning", w)
+		}
+	}
+	if len(p.Deny["alice"]) != 1 || p.Deny["alice"][0] != casbin.Permission("data3", "read") {
+		t.Fatal("unexpected deny", p.Deny)
+	}
+
+	auth.NewRBAC()
+	defer auth.CloseRBAC()
+	if err := auth.LoadPolicy(p); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		role       string
+		permission string
+		granted    bool
+	}{
+		{"alice", "write:data1", true},
+		{"alice", "read:data.2", true},
+		{"alice", "read:data22", false},
+		{"reader", "write:data1", false},
+		{"bob", "read:data1", false},
+		{"admin", "read:data3", false},
+	} {
+		if auth.IsGranted(c.role, rbac2.RBACPermission{Name: c.permission}, nil) != c.granted {
+			t.Fatal("problem with permission grant", c)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	p := &auth.Policy{
+		Roles: map[string][]string{
+			"admin": {casbin.Permission("data1", "write"), "read:data2", "read:.*",
+				"^delete:data1$", "^delete:data2$", "^delete:data3$", "^delete:data4$"},
+			"reader": {},
+		},
+		Deny: map[string][]string{
+			"admin": {"^write:data2$", "^write:data3$"},
+		},
+		Inher: map[string][]string{
+			"admin": {"reader"},
+		},
+		Grants: map[string]map[string]*auth.Grant{
+			"admin": {
+				"^delete:data1$": {Permission: "^delete:data1$", Conditions: []string{"owner"}},
+				"^delete:data2$": {Permission: "^delete:data2$", When: []rbac2.AttributeCondition{
+					{Attribute: "owner", Operator: rbac2.OpEqual, Value: "admin"}}},
+				"^delete:data3$": {Permission: "^delete:data3$", Expr: `attrs.owner == "admin"`},
+				"^delete:data4$": {Permission: "^delete:data4$", Validity: &rbac2.Validity{Schedule: "Mon-Fri 09:00-17:00"}},
+			},
+		},
+		DenyGrants: map[string]map[string]*auth.Grant{
+			"admin": {"^write:data3$": {Permission: "^write:data3$", Conditions: []string{"office-hours"}}},
+		},
+	}
+	var buf bytes.Buffer
+	warnings, err := casbin.Export(&buf, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `p,admin,data1,write,allow
+p,admin,data2,read,allow
+p,admin,data2,write,deny
+p,admin,data3,write,deny
+g,admin,reader
+`
+	if buf.String() != expected {
+		t.Fatal("unexpected output", buf.String())
+	}
+	expectedWarnings := []string{
+		`permission "^delete:data1$" of role "admin" requires conditions and cannot be represented`,
+		`permission "^delete:data2$" of role "admin" requires attribute conditions and cannot be represented`,
+		`permission "^delete:data3$" of role "admin" requires an expression and cannot be represented`,
+		`permission "^delete:data4$" of role "admin" requires a validity and cannot be represented`,
+		`permission "read:.*" of role "admin" is not a literal ` + "`act:obj`" + ` and cannot be represented`,
+		`permission "read:data2" of role "admin" matches substrings and is exported as an exact match`,
+		`denied permission "^write:data3$" of role "admin" requires conditions and is exported unconditionally`,
+	}
+	if len(warnings) != len(expectedWarnings) {
+		t.Fatal("unexpected warnings", warnings)
+	}
+	for i, w := range warnings {
+		if w.Msg != expectedWarnings[i] {
+			t.Fatal("unexpected warning", w)
+		}
+	}
+
+	imported, _, err := casbin.Import(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported.Roles["admin"]) != 2 || len(imported.Deny["admin"]) != 2 || imported.Inher["admin"][0] != "reader" {
+		t.Fatal("unexpected import", imported)
+	}
+}
+
+func TestCheckModel(t *testing.T) {
+	warnings, err := casbin.CheckModel(strings.NewReader(casbin.Model))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 0 {
+		t.Fatal("unexpected warnings", warnings)
+	}
+	f, err := os.Open("model.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	warnings, err = casbin.CheckModel(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 2 {
+		t.Fatal("unexpected warnings", warnings)
+	}
+}
@@ -0,0 +1,104 @@
+package k8s
+
+import (
+	rbac2 "github.com/z26100/rbac-go"
+	auth "github.com/z26100/rbac-go/auth"
+	"github.com/z26100/rbac-go/k8s"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestImportFiles(t *testing.T) {
+	p, warnings, err := k8s.ImportFiles("roles.yaml", "bindings.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"Role shop/deployer: rule 1: resourceNames are not supported, the rule is skipped",
+		`RoleBinding shop/view: the permissions of ClusterRole "pod-reader" are granted in all namespaces, not only in namespace "shop"`,
+		`ConfigMap unrelated: kind "ConfigMap" is not supported`,
+	}
+	if len(warnings) != len(expected) {
+		t.Fatal("unexpected warnings", warnings)
+	}
+	for i, w := range warnings {
+		if w.String() != expected[i] {
+			t.Fatal("unexpected warning", w)
+		}
+	}
+	if parents := p.Inher["monitoring"]; len(parents) != 2 || parents[0] != "metrics-reader" || parents[1] != "pod-reader" {
+		t.Fatal("unexpected aggregation", parents)
+	}
+	if parents := p.Inher["serviceaccount:shop/ci"]; len(parents) != 1 || parents[0] != "shop/deployer" {
+		t.Fatal("unexpected binding", parents)
+	}
+
+	auth.NewRBAC()
+	defer auth.CloseRBAC()
+	if err := auth.LoadPolicy(p); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		role       string
+		permission string
+		granted    bool
+	}{
+		{"group:sre", "get:core/pods", true},
+		{"group:sre", "watch:core/pods/log", true},
+		{"group:sre", "delete:core/pods", false},
+		{"group:sre", "get:metrics.k8s.io/nodes", true},
+		{"group:sre", "get:metricsxk8s.io/nodes", false},
+		{"group:sre", "get:/metrics", true},
+		{"group:sre", "get:/healthz/ready", true},
+		{"serviceaccount:monitoring/prometheus", "list:core/pods", true},
+		{"user:alice", "patch:apps/deployments", true},
+		{"user:alice", "patch:apps/statefulsets", false},
+		{"user:alice", "update:core/configmaps", false},
+		{"user:bob", "get:core/pods", true},
+		{"user:bob", "get:apps/deployments", false},
+	} {
+		if auth.IsGranted(c.role, rbac2.RBACPermission{Name: c.permission}, nil) != c.granted {
+			t.Fatal("problem with permission grant", c)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	f, err := os.Open("roles.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p, _, err := k8s.Import(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Roles) != 4 {
+		t.Fatal("unexpected roles", p.Roles)
+	}
+	if permissions := p.Roles["metrics-reader"]; len(permissions) != 3 {
+		t.Fatal("unexpected permissions", permissions)
+	}
+	if k8s.Permission("get", "", "pods") != `^get:core/pods$` {
+		t.Fatal("unexpected permission", k8s.Permission("get", "", "pods"))
+	}
+}
+
+func TestImportBindingsFirst(t *testing.T) {
+	p, warnings, err := k8s.ImportFiles("bindings.yaml", "roles.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range warnings {
+		if strings.HasSuffix(w.String(), "is not defined") {
+			t.Fatal("unexpected warning", w)
+		}
+	}
+	if permissions := p.Roles["shop/deployer"]; len(permissions) == 0 {
+		t.Fatal("role replaced by a placeholder", permissions)
+	}
+	if parents := p.Inher["serviceaccount:shop/ci"]; len(parents) != 1 || parents[0] != "shop/deployer" {
+		t.Fatal("unexpected binding", parents)
+	}
+}
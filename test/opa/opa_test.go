@@ -0,0 +1,161 @@
+package opa
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	rbac2 "github.com/z26100/rbac-go"
+	auth "github.com/z26100/rbac-go/auth"
+	"github.com/z26100/rbac-go/opa"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+var decisions = []struct {
+	role       string
+	permission string
+}{
+	{"admin", "delete:articles"},
+	{"admin", "write:articles"},
+	{"admin", "read:articles"},
+	{"editor", "write:articles/1"},
+	{"editor", "delete:articles"},
+	{"editor", "read:Articles"},
+	{"viewer", "read:comments"},
+	{"editor", "read:secrets"},
+	{"viewer", "write:articles"},
+	{"guest", "read:articles"},
+	{"unknown", "read:articles"},
+	{"Admin", "delete:articles"},
+	{"intern", "read:comments"},
+	{"intern", "read:secrets"},
+}
+
+func export(t *testing.T) *opa.Bundle {
+	auth.NewRBAC()
+	if err := auth.LoadFromFile("policy.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := opa.Export(auth.GetBackend())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestExport(t *testing.T) {
+	b := export(t)
+	defer auth.CloseRBAC()
+	if len(b.Data.Roles) != 5 {
+		t.Fatal("unexpected roles", b.Data.Roles)
+	}
+	if p := b.Data.Roles["viewer"].Permissions; len(p) != 1 || p[0] != "^read:[a-z]+$" {
+		t.Fatal("unexpected permissions", p)
+	}
+	if p := b.Data.Parents["admin"]; len(p) != 1 || p[0] != "editor" {
+		t.Fatal("unexpected parents", p)
+	}
+	if p, ok := b.Data.Parents["guest"]; !ok || len(p) != 0 {
+		t.Fatal("unexpected parents", p)
+	}
+	// inheritances limited in time only pass on denied permissions
+	if p := b.Data.Parents["intern"]; len(p) != 0 {
+		t.Fatal("unexpected parents", p)
+	}
+	if p := b.Data.DenyParents["intern"]; len(p) != 1 || p[0] != "viewer" {
+		t.Fatal("unexpected deny parents", p)
+	}
+}
+
+func TestWriteTarGz(t *testing.T) {
+	b := export(t)
+	defer auth.CloseRBAC()
+	var buf bytes.Buffer
+	if err := b.WriteTarGz(&buf); err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	files := make(map[string][]byte)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = data
+	}
+	if len(files) != 3 || string(files["/rbac.rego"]) != opa.Module {
+		t.Fatal("unexpected files", files)
+	}
+	var data map[string]opa.Data
+	if err := json.Unmarshal(files["/data.json"], &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data["rbac"].Roles) != 5 {
+		t.Fatal("unexpected data", data)
+	}
+}
+
+// allow evaluates `data.rbac.allow` of the bundle `b` in-process.
+func allow(t *testing.T, b *opa.Bundle, role, permission string) bool {
+	var data map[string]interface{}
+	raw, err := json.Marshal(map[string]opa.Data{"rbac": b.Data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		t.Fatal(err)
+	}
+	rs, err := rego.New(
+		rego.Query("data.rbac.allow"),
+		rego.Module("rbac.rego", opa.Module),
+		rego.Store(inmem.NewFromObject(data)),
+		rego.Input(map[string]interface{}{"role": role, "permission": permission}),
+	).Eval(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 1 || len(rs[0].Expressions) != 1 {
+		t.Fatal("unexpected result", rs)
+	}
+	allowed, ok := rs[0].Expressions[0].Value.(bool)
+	if !ok {
+		t.Fatal("unexpected result", rs)
+	}
+	return allowed
+}
+
+// TestDecisions compares the decisions of RBAC.IsGranted with the ones
+// of the module of the exported bundle.
+func TestDecisions(t *testing.T) {
+	b := export(t)
+	defer auth.CloseRBAC()
+	granted := 0
+	for _, d := range decisions {
+		expected := auth.IsGranted(d.role, rbac2.RBACPermission{Name: d.permission}, nil)
+		if allow(t, b, d.role, d.permission) != expected {
+			t.Fatal("decisions differ", d, expected)
+		}
+		if expected {
+			granted++
+		}
+	}
+	if granted != 6 {
+		t.Fatal("unexpected number of granted decisions", granted)
+	}
+}
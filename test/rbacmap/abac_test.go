@@ -0,0 +1,137 @@
+package rbacmap
+
+import (
+	"bytes"
+	rbac2 "github.com/z26100/rbac-go"
+	auth "github.com/z26100/rbac-go/auth"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+)
+
+func TestIsGrantedWithContext(t *testing.T) {
+	auth.NewRBAC()
+	defer auth.CloseRBAC()
+	if err := auth.LoadFromFile("test-abac.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	alice := map[string]interface{}{"id": "alice"}
+	for i, c := range []struct {
+		role       string
+		permission string
+		attrs      rbac2.Attributes
+		granted    bool
+	}{
+		{"editor", "update:documents", rbac2.Attributes{"subject": alice, "resource": map[string]interface{}{"owner": "alice"}}, true},
+		{"editor", "update:documents", rbac2.Attributes{"subject": alice, "resource": map[string]interface{}{"owner": "bob"}}, false},
+		{"editor", "update:documents", rbac2.Attributes{"subject": alice}, false},
+		{"editor", "update:documents", nil, false},
+		{"editor", "approve:documents", rbac2.Attributes{
+			"resource":    map[string]interface{}{"amount": 999.5},
+			"environment": map[string]interface{}{"region": "eu"},
+		}, true},
+		{"editor", "approve:documents", rbac2.Attributes{
+			"resource":    map[string]interface{}{"amount": 1000},
+			"environment": map[string]interface{}{"region": "eu"},
+		}, false},
+		{"editor", "approve:documents", rbac2.Attributes{
+			"resource":    map[string]interface{}{"amount": 10},
+			"environment": map[string]interface{}{"region": "apac"},
+		}, false},
+		{"reader", "read:documents", rbac2.Attributes{"resource": map[string]interface{}{"classification": "public"}}, true},
+		{"editor", "read:documents", rbac2.Attributes{"resource": map[string]interface{}{"classification": "secret"}}, false},
+		{"reader", "read:documents", nil, false},
+	} {
+		if auth.IsGrantedWithContext(c.role, rbac2.RBACPermission{Name: c.permission}, c.attrs, nil) != c.granted {
+			t.Fatal("problem with permission grant", i, c)
+		}
+	}
+	if auth.IsGranted("editor", rbac2.RBACPermission{Name: "update:documents"}, nil) {
+		t.Fatal("IsGranted must not grant permissions with attribute conditions")
+	}
+}
+
+func TestAttributeOperands(t *testing.T) {
+	r := rbac2.Default()
+	defer r.Close()
+	for i, c := range []struct {
+		condition rbac2.AttributeCondition
+		value     interface{}
+		granted   bool
+	}{
+		{rbac2.AttributeCondition{Attribute: "a", Operator: rbac2.OpEqual, Value: int32(3)}, uint8(3), true},
+		{rbac2.AttributeCondition{Attribute: "a", Operator: rbac2.OpEqual, Value: 3}, "3", false},
+		{rbac2.AttributeCondition{Attribute: "a", Operator: rbac2.OpEqual, Value: []string{"x", "y"}},
+			[]interface{}{"x", "y"}, true},
+		{rbac2.AttributeCondition{Attribute: "a", Operator: rbac2.OpIn, Value: []string{"eu", "us"}}, "eu", true},
+		{rbac2.AttributeCondition{Attribute: "a", Operator: rbac2.OpIn, Value: primitive.A{int64(1), "x"}}, 1.0, true},
+		{rbac2.AttributeCondition{Attribute: "a", Operator: rbac2.OpNotIn, Value: []interface{}{2, 3}}, 1, true},
+		{rbac2.AttributeCondition{Attribute: "a", Operator: rbac2.OpLess, Value: float32(1.5)}, int64(1), true},
+		{rbac2.AttributeCondition{Attribute: "a", Operator: rbac2.OpGreaterEqual, Ref: "b"}, uint(2), true},
+	} {
+		role := &rbac2.RBACRole{Name: "role"}
+		role.AddPermission(&rbac2.RBACPermission{Name: "p", When: []rbac2.AttributeCondition{c.condition}})
+		if err := r.Set(role); err != nil {
+			t.Fatal(err)
+		}
+		attrs := rbac2.Attributes{"a": c.value, "b": 2.0}
+		if r.IsGrantedWithContext("role", rbac2.RBACPermission{Name: "p"}, attrs, nil) != c.granted {
+			t.Fatal("problem with attribute condition", i, c)
+		}
+	}
+}
+
+func TestSaveAttributeConditions(t *testing.T) {
+	for _, ft := range []auth.FileType{auth.YAML, auth.JSON, auth.TOML, auth.HCL} {
+		auth.NewRBAC()
+		if err := auth.LoadFromFile("test-abac.yaml"); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := auth.Save(&buf, ft); err != nil {
+			t.Fatal(err)
+		}
+		auth.CloseRBAC()
+		auth.NewRBAC()
+		if err := auth.Load(&buf, ft); err != nil {
+			t.Fatal(ft, err)
+		}
+		attrs := rbac2.Attributes{
+			"resource":    map[string]interface{}{"amount": 10},
+			"environment": map[string]interface{}{"region": "us"},
+		}
+		if !auth.IsGrantedWithContext("editor", rbac2.RBACPermission{Name: "approve:documents"}, attrs, nil) {
+			t.Fatal("attribute conditions not restored", ft)
+		}
+		auth.CloseRBAC()
+	}
+}
+
+func TestValidateAttributeConditions(t *testing.T) {
+	data := `roles:
+    editor:
+        - permission: ^update:documents$
+          when:
+            - attribute: resource.owner
+              operator: matches
+              value: alice
+            - attribute: resource.amount
+              operator: lt
+              value: many
+            - operator: exists
+`
+	_, err := auth.ParsePolicy("abac.yaml", []byte(data))
+	expected := []string{
+		`abac.yaml:5:15: invalid condition: unknown operator "matches"`,
+		`abac.yaml:8:15: invalid condition: operator "lt" requires a number`,
+		`abac.yaml:11:15: invalid condition: missing attribute`,
+	}
+	v, ok := err.(*auth.ValidationError)
+	if !ok || len(v.Errors) != len(expected) {
+		t.Fatal("unexpected errors", err)
+	}
+	for i, e := range v.Errors {
+		if e.Error() != expected[i] {
+			t.Fatal("unexpected error", e)
+		}
+	}
+}
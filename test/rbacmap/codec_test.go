@@ -0,0 +1,61 @@
+package rbacmap
+
+import (
+	"errors"
+	auth "github.com/z26100/rbac-go/auth"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rbac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, ext := range []string{".toml", ".hcl", ".json", ".yaml"} {
+		filename := filepath.Join(dir, "policy"+ext)
+		auth.NewRBAC()
+		if err := auth.LoadFromFile("test.yaml"); err != nil {
+			t.Fatal(err)
+		}
+		if err := auth.SaveAsFilename(filename); err != nil {
+			t.Fatal(ext, err)
+		}
+		auth.NewRBAC()
+		if err := auth.LoadFromFile(filename); err != nil {
+			t.Fatal(ext, err)
+		}
+		cs, err := auth.DiffFile("test.yaml")
+		if err != nil {
+			t.Fatal(ext, err)
+		}
+		if !cs.Empty() {
+			t.Fatal(ext, "unexpected diff", cs.String())
+		}
+		auth.CloseRBAC()
+	}
+}
+
+func TestLoadHCL(t *testing.T) {
+	cs, err := auth.DiffFiles("test.yaml", "test.hcl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cs.Empty() {
+		t.Fatal("unexpected diff", cs.String())
+	}
+}
+
+func TestUnknownFileType(t *testing.T) {
+	auth.NewRBAC()
+	defer auth.CloseRBAC()
+	auth.SetFileType("xml")
+	defer auth.SetFileType(auth.AUTO)
+	if err := auth.SaveAsFilename("policy.xml"); !errors.Is(err, auth.ErrUnknownFileType) {
+		t.Fatal("expected ErrUnknownFileType")
+	}
+}
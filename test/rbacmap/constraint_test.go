@@ -0,0 +1,220 @@
+package rbacmap
+
+import (
+	"bytes"
+	"errors"
+	rbac2 "github.com/z26100/rbac-go"
+	auth "github.com/z26100/rbac-go/auth"
+	"testing"
+)
+
+func TestCardinality(t *testing.T) {
+	auth.NewRBAC()
+	defer auth.CloseRBAC()
+	if err := auth.LoadFromFile("test-constraints.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	r := auth.GetBackend()
+	if err := r.SetParent("bob", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	err := r.SetParent("carol", "admin")
+	var ce *rbac2.CardinalityError
+	if !errors.As(err, &ce) || ce.Constraint != "admins" || ce.Role != "admin" || len(ce.Subjects) != 3 || ce.Max != 2 {
+		t.Fatal("unexpected error", err)
+	}
+	if errors.Is(err, rbac2.ErrRoleNotExist) || errors.Is(err, rbac2.ErrRoleExist) {
+		t.Fatal("constraint error not distinguishable", err)
+	}
+	if parents, _ := r.GetParents("carol"); len(parents) != 0 {
+		t.Fatal("parent bound despite violation", parents)
+	}
+	if err := r.RemoveParent("bob", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetParent("carol", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.AddConstraint(rbac2.CardinalityConstraint{Name: "single-admin", Role: "admin", Max: 1}); !errors.As(err, &ce) {
+		t.Fatal("unexpected error", err)
+	}
+	if err := r.AddConstraint(rbac2.CardinalityConstraint{Name: "auditors", Role: "auditor", Max: 1}); !errors.Is(err, rbac2.ErrRoleNotExist) {
+		t.Fatal("unexpected error", err)
+	}
+	if err := r.AddConstraint(rbac2.CardinalityConstraint{Name: "admins", Role: "developer"}); !errors.Is(err, rbac2.ErrInvalidConstraint) {
+		t.Fatal("unexpected error", err)
+	}
+}
+
+func TestPrerequisite(t *testing.T) {
+	auth.NewRBAC()
+	defer auth.CloseRBAC()
+	if err := auth.LoadFromFile("test-constraints.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	r := auth.GetBackend()
+	err := r.SetParent("carol", "release-manager")
+	var pe *rbac2.PrerequisiteError
+	if !errors.As(err, &pe) || pe.Constraint != "release-managers" || pe.Subject != "carol" ||
+		len(pe.Missing) != 1 || pe.Missing[0] != "developer" {
+		t.Fatal("unexpected error", err)
+	}
+	expected := `constraint violated: "release-managers": role "carol" holds "release-manager" without "developer"`
+	if err.Error() != expected {
+		t.Fatal("unexpected message", err)
+	}
+	if err := r.SetParents("carol", []string{"release-manager", "developer"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RemoveParent("alice", "developer"); !errors.As(err, &pe) || pe.Subject != "alice" {
+		t.Fatal("unexpected error", err)
+	}
+	if err := r.Remove("developer"); !errors.As(err, &pe) || errors.Is(err, rbac2.ErrRoleNotExist) {
+		t.Fatal("unexpected error", err)
+	}
+	if _, _, err := r.Get("developer"); err != nil {
+		t.Fatal("role removed despite violation", err)
+	}
+	if len(r.Violations()) != 0 {
+		t.Fatal("unexpected violations", r.Violations())
+	}
+	r.RemoveConstraint("release-managers")
+	if err := r.Remove("developer"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSaveCardinalityAndPrerequisite(t *testing.T) {
+	for _, ft := range []auth.FileType{auth.YAML, auth.JSON, auth.TOML, auth.HCL} {
+		auth.NewRBAC()
+		if err := auth.LoadFromFile("test-constraints.yaml"); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := auth.Save(&buf, ft); err != nil {
+			t.Fatal(err)
+		}
+		auth.CloseRBAC()
+		auth.NewRBAC()
+		if err := auth.Load(&buf, ft); err != nil {
+			t.Fatal(ft, err)
+		}
+		constraints := auth.GetBackend().Constraints()
+		if len(constraints) != 2 {
+			t.Fatal("constraints not restored", ft, constraints)
+		}
+		if c, ok := constraints[0].(rbac2.CardinalityConstraint); !ok || c.Role != "admin" || c.Max != 2 {
+			t.Fatal("constraints not restored", ft, constraints)
+		}
+		if c, ok := constraints[1].(rbac2.PrerequisiteConstraint); !ok || c.Role != "release-manager" ||
+			len(c.Requires) != 1 || c.Requires[0] != "developer" {
+			t.Fatal("constraints not restored", ft, constraints)
+		}
+		auth.CloseRBAC()
+	}
+}
+
+func TestValidateCardinalityAndPrerequisite(t *testing.T) {
+	data := `roles:
+    admin: []
+    developer: []
+    release-manager: []
+    alice: []
+    bob: []
+inher:
+    alice: [admin, release-manager]
+    bob: [admin]
+constraints:
+    admins:
+        role: admin
+        max: 1
+    release-managers:
+        role: release-manager
+        requires: [developer, tester]
+    mixed:
+        role: admin
+        roles: [admin, developer]
+    partial:
+        role: admin
+    empty: {}
+    dynamic:
+        role: admin
+        max: 1
+        dynamic: true
+`
+	_, err := auth.ParsePolicy("constraints.yaml", []byte(data))
+	expected := []string{
+		`constraints.yaml:18:9: key "role" is not allowed by constraint "mixed"`,
+		`constraints.yaml:21:9: invalid constraint "partial": max must be at least 1`,
+		`constraints.yaml:22:12: constraint "empty" must have roles or a role`,
+		`constraints.yaml:26:9: key "dynamic" is not allowed by constraint "dynamic"`,
+		`constraints.yaml:16:31: unknown role "tester" in constraint "release-managers"`,
+	}
+	v, ok := err.(*auth.ValidationError)
+	if !ok || len(v.Errors) != len(expected) {
+		t.Fatal("unexpected errors", err)
+	}
+	for i, e := range v.Errors {
+		if e.Error() != expected[i] {
+			t.Fatal("unexpected error", e)
+		}
+	}
+
+	data = `roles:
+    admin: []
+    developer: []
+    release-manager: []
+    alice: []
+    bob: []
+inher:
+    alice: [admin, release-manager]
+    bob: [admin]
+constraints:
+    admins:
+        role: admin
+        max: 1
+    release-managers:
+        role: release-manager
+        requires: [developer]
+`
+	_, err = auth.ParsePolicy("constraints.yaml", []byte(data))
+	expected = []string{
+		`constraints.yaml:11:5: constraint violated: "admins": role "admin" is held by "alice", "bob", at most 1 allowed`,
+		`constraints.yaml:8:5: constraint violated: "release-managers": role "alice" holds "release-manager" without "developer"`,
+	}
+	v, ok = err.(*auth.ValidationError)
+	if !ok || len(v.Errors) != len(expected) {
+		t.Fatal("unexpected errors", err)
+	}
+	for i, e := range v.Errors {
+		if e.Error() != expected[i] {
+			t.Fatal("unexpected error", e)
+		}
+	}
+}
+
+func TestSharedConstraints(t *testing.T) {
+	b := rbac2.NewMapBackend()
+	r1, r2 := rbac2.New(b), rbac2.New(b)
+	for _, name := range []string{"admin", "alice", "bob"} {
+		if err := r1.Add(&rbac2.RBACRole{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r1.AddConstraint(rbac2.CardinalityConstraint{Name: "admins", Role: "admin", Max: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r2.SetParent("alice", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	// the constraint is stored by the backend, not by the instance
+	if err := r2.SetParent("bob", "admin"); !errors.Is(err, rbac2.ErrConstraintViolated) {
+		t.Fatal("unexpected error", err)
+	}
+	if err := r2.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if len(r1.Constraints()) != 0 {
+		t.Fatal("constraints not cleared", r1.Constraints())
+	}
+}
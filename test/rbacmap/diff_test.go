@@ -0,0 +1,55 @@
+package rbacmap
+
+import (
+	"bytes"
+	"encoding/json"
+	rbac2 "github.com/z26100/rbac-go"
+	auth "github.com/z26100/rbac-go/auth"
+	"reflect"
+	"testing"
+)
+
+func TestDiffFiles(t *testing.T) {
+	cs, err := auth.DiffFiles("test.yaml", "test-diff.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `+ role role-2
+    + permission p-0
+- role role-0
+~ role role-1
+    + permission p-2
++ parent role-1 -> role-2
+- parent role-1 -> role-0
+`
+	if cs.String() != expected {
+		t.Fatal("unexpected diff", cs.String())
+	}
+	var buf bytes.Buffer
+	if err := cs.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded rbac2.Changeset
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&decoded, cs) {
+		t.Fatal("json round trip failed", buf.String())
+	}
+}
+
+func TestDiffFile(t *testing.T) {
+	auth.NewRBAC()
+	defer auth.CloseRBAC()
+	err := auth.LoadFromFile("test.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err := auth.DiffFile("test.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cs.Empty() {
+		t.Fatal("unexpected diff", cs.String())
+	}
+}
@@ -0,0 +1,161 @@
+package rbacmap
+
+import (
+	"bytes"
+	"errors"
+	rbac2 "github.com/z26100/rbac-go"
+	auth "github.com/z26100/rbac-go/auth"
+	"strings"
+	"testing"
+)
+
+func TestCompileExpression(t *testing.T) {
+	for _, c := range []struct {
+		src string
+		err string
+	}{
+		{`subject.id == "alice"`, ""},
+		{`a.b < 3 && (c || !d) && e in [1, "x", true]`, ""},
+		{`lower(subject.name) == "alice" && endsWith(resource.path, ".md")`, ""},
+		{`now() > time("2024-01-01T00:00:00Z")`, ""},
+		{`subject.id`, ""},
+		{`"alice"`, `"\"alice\"" is a string, not a boolean`},
+		{`a == "x" &&`, "column 12: unexpected end of expression"},
+		{`a == 1 == 2`, `column 8: unexpected "=="`},
+		{`"a" < 3`, "column 5: can't order a string and a number"},
+		{`a in "x"`, "column 3: right operand of in must be a list, not a string"},
+		{`1 && a`, "column 3: operands of && must be booleans, not a number"},
+		{`startsWith(a, 1)`, "column 1: argument 2 of startsWith must be a string, not a number"},
+		{`hour()`, "column 1: hour takes 1 arguments, not 0"},
+		{`exec("rm")`, `column 1: unknown function "exec"`},
+		{`time("tomorrow") < now()`, `column 1: parsing time "tomorrow"`},
+		{`a == 'x`, "column 6: unterminated string"},
+		{`a # b`, `column 3: unexpected character '#'`},
+		{strings.Repeat("(", 40) + "a" + strings.Repeat(")", 40), "nested deeper than 32"},
+		{"a in [" + strings.Repeat("1, ", 300) + "1]", "more than 256 nodes"},
+		{"a == \"" + strings.Repeat("x", 1100) + "\"", "longer than 1024 bytes"},
+	} {
+		_, err := rbac2.CompileExpression(c.src)
+		if c.err == "" {
+			if err != nil {
+				t.Fatal("unexpected error", c.src, err)
+			}
+			continue
+		}
+		if !errors.Is(err, rbac2.ErrInvalidExpression) || !strings.Contains(err.Error(), c.err) {
+			t.Fatal("unexpected error", c.src, err)
+		}
+	}
+}
+
+func TestEvaluateExpression(t *testing.T) {
+	attrs := rbac2.Attributes{
+		"subject":  map[string]interface{}{"id": "alice", "level": 3, "groups": []string{"dev", "ops"}},
+		"resource": map[string]interface{}{"owner": "alice", "size": 2.5, "public": false},
+	}
+	for _, c := range []struct {
+		src    string
+		result bool
+		err    bool
+	}{
+		{`subject.id == resource.owner`, true, false},
+		{`subject.level >= 3 && resource.size < 3`, true, false},
+		{`"ops" in subject.groups`, true, false},
+		{`subject.id in ["bob", "carol"]`, false, false},
+		{`subject.groups == ["dev", "ops"]`, true, false},
+		{`subject.groups != ["dev"]`, true, false},
+		{`subject.groups == ["ops", "dev"]`, false, false},
+		{`resource.public || subject.level > 5`, false, false},
+		{`!resource.public`, true, false},
+		{`subject.id == "bob" && subject.missing`, false, false},
+		{`subject.id == "alice" || subject.missing`, true, false},
+		{`subject.missing == 1`, false, true},
+		{`subject.id < 3`, false, true},
+		{`subject.level`, false, true},
+	} {
+		e, err := rbac2.CompileExpression(c.src)
+		if err != nil {
+			t.Fatal(c.src, err)
+		}
+		result, err := e.Evaluate(attrs)
+		if (err != nil) != c.err || result != c.result {
+			t.Fatal("unexpected result", c.src, result, err)
+		}
+	}
+}
+
+func TestExpressionGrants(t *testing.T) {
+	for _, ft := range []auth.FileType{auth.YAML, auth.JSON, auth.TOML, auth.HCL} {
+		auth.NewRBAC()
+		if err := auth.LoadFromFile("test-expr.yaml"); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := auth.Save(&buf, ft); err != nil {
+			t.Fatal(err)
+		}
+		auth.CloseRBAC()
+		auth.NewRBAC()
+		if err := auth.Load(&buf, ft); err != nil {
+			t.Fatal(ft, err)
+		}
+		for _, c := range []struct {
+			role       string
+			permission string
+			attrs      rbac2.Attributes
+			granted    bool
+		}{
+			{"author", "update:documents", rbac2.Attributes{
+				"subject":  map[string]interface{}{"id": "alice"},
+				"resource": map[string]interface{}{"owner": "alice", "state": "draft", "locked": false},
+			}, true},
+			{"author", "update:documents", rbac2.Attributes{
+				"subject":  map[string]interface{}{"id": "alice"},
+				"resource": map[string]interface{}{"owner": "alice", "state": "published", "locked": false},
+			}, false},
+			{"author", "update:documents", rbac2.Attributes{
+				"subject":  map[string]interface{}{"id": "alice"},
+				"resource": map[string]interface{}{"owner": "alice", "state": "draft", "locked": true},
+			}, false},
+			{"author", "update:documents", rbac2.Attributes{
+				"subject":  map[string]interface{}{"id": "alice"},
+				"resource": map[string]interface{}{"owner": "alice", "state": "draft"},
+			}, false},
+			{"author", "read:documents", rbac2.Attributes{
+				"resource": map[string]interface{}{"path": "/public/a.md"},
+			}, true},
+			{"author", "read:documents", rbac2.Attributes{
+				"subject":  map[string]interface{}{"id": "bob"},
+				"resource": map[string]interface{}{"path": "/private/a.md", "readers": []interface{}{"bob"}},
+			}, true},
+			{"operator", "restart:servers", rbac2.Attributes{
+				"environment": map[string]interface{}{"time": "2024-03-05T10:30:00Z"},
+			}, true},
+			{"operator", "restart:servers", rbac2.Attributes{
+				"environment": map[string]interface{}{"time": "2024-03-09T10:30:00Z"},
+			}, false},
+			{"operator", "restart:servers", rbac2.Attributes{
+				"environment": map[string]interface{}{"time": "2024-03-05T20:00:00Z"},
+			}, false},
+		} {
+			if auth.IsGrantedWithContext(c.role, rbac2.RBACPermission{Name: c.permission}, c.attrs, nil) != c.granted {
+				t.Fatal("problem with permission grant", ft, c)
+			}
+		}
+		auth.CloseRBAC()
+	}
+}
+
+func TestValidateExpression(t *testing.T) {
+	data := `roles:
+    author:
+        - permission: ^update:documents$
+          expr: resource.owner == 
+`
+	_, err := auth.ParsePolicy("expr.yaml", []byte(data))
+	expected := `expr.yaml:4:17: invalid expression: column 18: unexpected end of expression`
+	v, ok := err.(*auth.ValidationError)
+	if !ok || len(v.Errors) != 1 || v.Errors[0].Error() != expected {
+		t.Fatal("unexpected errors", err)
+	}
+}
@@ -0,0 +1,64 @@
+package rbacmap
+
+import (
+	"bytes"
+	rbac2 "github.com/z26100/rbac-go"
+	"testing"
+)
+
+func TestExportDOT(t *testing.T) {
+	r := newHierarchy(t)
+	defer r.Close()
+	role, _, _ := r.Get("editor")
+	role.(*rbac2.RBACRole).AddPermission(&rbac2.RBACPermission{Name: "get:doc"})
+	role.(*rbac2.RBACRole).AddPermission(&rbac2.RBACPermission{Name: "put:doc"})
+
+	var buf bytes.Buffer
+	err := rbac2.ExportDOT(r, &buf, rbac2.GraphOptions{Root: "admin", PermissionPrefix: "get:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `digraph rbac {
+	rankdir=BT;
+	node [shape=box];
+	"admin";
+	"editor";
+	"viewer";
+	"editor" -> "admin";
+	"viewer" -> "editor";
+	"permission:get:doc" [label="get:doc", shape=ellipse];
+	"editor" -> "permission:get:doc" [style=dashed];
+}
+`
+	if buf.String() != expected {
+		t.Fatal("unexpected output", buf.String())
+	}
+}
+
+func TestExportMermaid(t *testing.T) {
+	r := newHierarchy(t)
+	defer r.Close()
+
+	var buf bytes.Buffer
+	err := rbac2.ExportMermaid(r, &buf, rbac2.GraphOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `flowchart BT
+	r0["admin"]
+	r1["editor"]
+	r2["guest"]
+	r3["root"]
+	r4["viewer"]
+	r0 --> r3
+	r1 --> r0
+	r4 --> r1
+	r4 --> r3
+`
+	if buf.String() != expected {
+		t.Fatal("unexpected output", buf.String())
+	}
+	if err := rbac2.ExportMermaid(r, &buf, rbac2.GraphOptions{Root: "missing"}); err != rbac2.ErrRoleNotExist {
+		t.Fatal("expected ErrRoleNotExist", err)
+	}
+}
@@ -0,0 +1,149 @@
+package rbacmap
+
+import (
+	"errors"
+	rbac2 "github.com/z26100/rbac-go"
+	"reflect"
+	"testing"
+)
+
+func newHierarchy(t *testing.T) *rbac2.RBAC {
+	r := rbac2.Default()
+	for _, id := range []string{"root", "admin", "editor", "viewer", "guest"} {
+		if err := r.Add(&rbac2.RBACRole{Name: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// root <- admin <- editor <- viewer, root <- viewer
+	if err := r.SetParent("admin", "root"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetParent("editor", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetParents("viewer", []string{"editor", "root"}); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestHierarchy(t *testing.T) {
+	r := newHierarchy(t)
+	defer r.Close()
+
+	children, err := r.Children("root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(children, []string{"admin", "viewer"}) {
+		t.Fatal("unexpected children", children)
+	}
+	ancestors, err := r.Ancestors("viewer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ancestors, []string{"admin", "editor", "root"}) {
+		t.Fatal("unexpected ancestors", ancestors)
+	}
+	descendants, err := r.Descendants("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(descendants, []string{"editor", "viewer"}) {
+		t.Fatal("unexpected descendants", descendants)
+	}
+	if roots := r.Roots(); !reflect.DeepEqual(roots, []string{"guest", "root"}) {
+		t.Fatal("unexpected roots", roots)
+	}
+	if leaves := r.Leaves(); !reflect.DeepEqual(leaves, []string{"guest", "viewer"}) {
+		t.Fatal("unexpected leaves", leaves)
+	}
+	depth, err := r.Depth("viewer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if depth != 3 {
+		t.Fatal("unexpected depth", depth)
+	}
+	if _, err := r.Depth("missing"); err != rbac2.ErrRoleNotExist {
+		t.Fatal("expected ErrRoleNotExist")
+	}
+}
+
+func TestHierarchyRemove(t *testing.T) {
+	r := newHierarchy(t)
+	defer r.Close()
+
+	if err := r.RemoveParent("viewer", "editor"); err != nil {
+		t.Fatal(err)
+	}
+	children, _ := r.Children("editor")
+	if len(children) != 0 {
+		t.Fatal("unexpected children", children)
+	}
+	if err := r.Remove("admin"); err != nil {
+		t.Fatal(err)
+	}
+	descendants, _ := r.Descendants("root")
+	if !reflect.DeepEqual(descendants, []string{"viewer"}) {
+		t.Fatal("unexpected descendants", descendants)
+	}
+}
+
+func TestCirclePrevention(t *testing.T) {
+	r := newHierarchy(t)
+	defer r.Close()
+
+	err := r.SetParent("root", "viewer")
+	if !errors.Is(err, rbac2.ErrFoundCircle) {
+		t.Fatal("expected ErrFoundCircle", err)
+	}
+	circle, ok := err.(*rbac2.CircleError)
+	if !ok {
+		t.Fatal("expected CircleError", err)
+	}
+	if !reflect.DeepEqual(circle.Paths, [][]string{{"root", "viewer", "root"}}) {
+		t.Fatal("unexpected path", circle.Paths)
+	}
+	err = r.SetParents("admin", []string{"guest", "editor"})
+	if !errors.Is(err, rbac2.ErrFoundCircle) {
+		t.Fatal("expected ErrFoundCircle", err)
+	}
+	if parents, _ := r.GetParents("admin"); !reflect.DeepEqual(parents, []string{"root"}) {
+		t.Fatal("parents must not change", parents)
+	}
+	if err := r.SetParent("guest", "guest"); !errors.Is(err, rbac2.ErrFoundCircle) {
+		t.Fatal("expected ErrFoundCircle", err)
+	}
+	if err := rbac2.InherCircle(r); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInherCircle(t *testing.T) {
+	b := rbac2.NewMapBackend()
+	r := rbac2.New(b)
+	defer r.Close()
+	for _, id := range []string{"a", "b", "c", "d"} {
+		if err := r.Add(&rbac2.RBACRole{Name: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// circles written straight to the backend, bypassing the checks
+	b.SetParent("a", "b", struct{}{})
+	b.SetParent("b", "a", struct{}{})
+	b.SetParent("c", "d", struct{}{})
+	b.SetParent("d", "c", struct{}{})
+
+	err := rbac2.InherCircle(r)
+	circle, ok := err.(*rbac2.CircleError)
+	if !ok {
+		t.Fatal("expected CircleError", err)
+	}
+	if !reflect.DeepEqual(circle.Paths, [][]string{{"a", "b", "a"}, {"c", "d", "c"}}) {
+		t.Fatal("unexpected paths", circle.Paths)
+	}
+	if r.IsGranted("a", rbac2.RBACPermission{Name: "p"}, nil) {
+		t.Fatal("problem with permission grant")
+	}
+}
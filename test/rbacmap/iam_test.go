@@ -0,0 +1,115 @@
+package rbacmap
+
+import (
+	"bytes"
+	"github.com/mikespook/gorbac"
+	rbac2 "github.com/z26100/rbac-go"
+	auth "github.com/z26100/rbac-go/auth"
+	"strings"
+	"testing"
+)
+
+var mfa bool
+
+func init() {
+	rbac2.RegisterCondition("mfa", func(*rbac2.RBAC, string, gorbac.Permission) bool {
+		return mfa
+	})
+}
+
+func TestLoadIAM(t *testing.T) {
+	auth.NewRBAC()
+	defer auth.CloseRBAC()
+	if err := auth.LoadFromFile("test-iam.json"); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		role       string
+		permission string
+		mfa        bool
+		granted    bool
+	}{
+		{"viewer", "articles:Read:articles/42", false, true},
+		{"viewer", "ARTICLES:read:articles/42", false, true},
+		{"viewer", "articles:Read:Articles/42", false, false},
+		{"viewer", "articles:Read:articles/drafts/1", false, false},
+		{"editor", "articles:Read:articles/42", false, true},
+		{"editor", "articles:Read:articles/drafts/1", true, false},
+		{"editor", "articles:Write:articles/42", false, false},
+		{"editor", "articles:Write:articles/42", true, true},
+		{"editor", "articles:Publish:articles/drafts/1", true, false},
+		{"auditor", "logs:GetX:system", false, true},
+		{"auditor", "logs:GetX:system", true, false},
+		{"auditor", "logs:GetXY:system", false, false},
+	} {
+		mfa = c.mfa
+		if auth.IsGranted(c.role, rbac2.RBACPermission{Name: c.permission}, nil) != c.granted {
+			t.Fatal("problem with permission grant", c)
+		}
+	}
+	mfa = true
+	role, _, err := auth.GetRole("editor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role.Permit(rbac2.RBACPermission{Name: "articles:Write:articles/42"}) {
+		t.Fatal("Permit must ignore permissions with conditions")
+	}
+}
+
+func TestSaveIAM(t *testing.T) {
+	auth.NewRBAC()
+	defer auth.CloseRBAC()
+	if err := auth.LoadFromFile("test-iam.json"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := auth.Save(&buf, auth.YAML); err != nil {
+		t.Fatal(err)
+	}
+	saved := buf.String()
+	if !strings.Contains(saved, "deny:\n    viewer:\n") || !strings.Contains(saved, "- '!mfa'") {
+		t.Fatal("unexpected output", saved)
+	}
+	p, err := auth.ParsePolicyReader(&buf, auth.YAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Deny["viewer"]) != 1 || len(p.Grants["editor"]) != 2 {
+		t.Fatal("unexpected policy", p)
+	}
+}
+
+func TestValidateIAM(t *testing.T) {
+	data := `{
+  "Version": "2008-10-17",
+  "Roles": {
+    "viewer": {
+      "Statement": [
+        {"Effect": "Permit", "Action": "a:b"},
+        {"Effect": "Allow", "NotAction": "a:b"},
+        {"Effect": "Allow", "Action": "a:b", "Condition": {"StringEquals": {"x": "y"}}},
+        {"Effect": "Allow", "Action": "a:b", "Condition": {"Bool": {"unknown": "true"}}}
+      ]
+    }
+  }
+}`
+	_, err := auth.ParsePolicy("iam.json", []byte(data))
+	expected := []string{
+		`iam.json:2:14: unsupported version "2008-10-17", expected "2012-10-17"`,
+		`iam.json:6:20: Effect must be Allow or Deny`,
+		`iam.json:7:29: NotAction is not supported`,
+		`iam.json:7:9: statement without Action`,
+		`iam.json:8:60: condition operator "StringEquals" is not supported, only Bool`,
+		`iam.json:9:69: unknown condition "unknown"`,
+	}
+	v, ok := err.(*auth.ValidationError)
+	if !ok || len(v.Errors) != len(expected) {
+		t.Fatal("unexpected errors", err)
+	}
+	for i, e := range v.Errors {
+		if e.Error() != expected[i] {
+			t.Fatal("unexpected error", e)
+		}
+	}
+}
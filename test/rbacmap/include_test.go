@@ -0,0 +1,77 @@
+package rbacmap
+
+import (
+	auth "github.com/z26100/rbac-go/auth"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestLoadDirectory(t *testing.T) {
+	auth.NewRBAC()
+	defer auth.CloseRBAC()
+	err := auth.LoadFromFile("policy.d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	role, parents, err := auth.GetRole("team-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(parents)
+	if !reflect.DeepEqual(parents, []string{"admin", "editor"}) {
+		t.Fatal("unexpected parents", parents)
+	}
+	if role.Source != "policy.d/10-team.yaml" {
+		t.Fatal("unexpected source", role.Source)
+	}
+	role, _, err = auth.GetRole("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role.Source != "shared/admin.yaml" {
+		t.Fatal("unexpected source", role.Source)
+	}
+	if _, _, err := auth.GetRole("editor"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIncludeCycle(t *testing.T) {
+	err := auth.ValidateFile("cycle/a.yaml")
+	if err == nil || !strings.Contains(err.Error(), `cycle/b.yaml:1:11: include cycle: "cycle/a.yaml" includes itself`) {
+		t.Fatal("expected include cycle", err)
+	}
+}
+
+func TestLoadGlob(t *testing.T) {
+	p, err := auth.ParsePolicyFiles("conflict/*.yaml")
+	if err == nil || !strings.Contains(err.Error(), `conflict/b.yaml:3:5: duplicate role "Viewer", already defined at conflict/a.yaml:2:5`) {
+		t.Fatal("expected duplicate role", err)
+	}
+
+	auth.SetMergeStrategy(auth.MergeOverride)
+	defer auth.SetMergeStrategy(auth.MergeError)
+	p, err = auth.ParsePolicyFiles("conflict/*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.Roles["viewer"], []string{"get:b"}) || p.Sources["viewer"] != "conflict/b.yaml" {
+		t.Fatal("unexpected override", p.Roles, p.Sources)
+	}
+	if !reflect.DeepEqual(p.Inher["viewer"], []string{"base"}) {
+		t.Fatal("unexpected inheritance", p.Inher)
+	}
+
+	auth.SetMergeStrategy(auth.MergeUnion)
+	p, err = auth.ParsePolicyFiles("conflict/*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	permissions := p.Roles["viewer"]
+	sort.Strings(permissions)
+	if !reflect.DeepEqual(permissions, []string{"get:a", "get:b"}) || p.Sources["viewer"] != "conflict/a.yaml" {
+		t.Fatal("unexpected union", p.Roles, p.Sources)
+	}
+}
@@ -0,0 +1,51 @@
+package rbacmap
+
+import (
+	auth "github.com/z26100/rbac-go/auth"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestInterpolation(t *testing.T) {
+	defer auth.SetVariables(nil)
+	_, err := auth.ParsePolicyFiles("test-vars.yaml")
+	if err == nil || !strings.Contains(err.Error(), `test-vars.yaml:2:5: variable "TENANT" is not set`) {
+		t.Fatal("expected missing variable", err)
+	}
+
+	auth.SetVariables(map[string]string{"TENANT": "tenant-a"})
+	p, err := auth.ParsePolicyFiles("test-vars.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	permissions := p.Roles["tenant-a-admin"]
+	sort.Strings(permissions)
+	if !reflect.DeepEqual(permissions, []string{"price:$", "prod:deploy", "tenant-a:orders:.*"}) {
+		t.Fatal("unexpected permissions", p.Roles)
+	}
+}
+
+func TestEnvInterpolation(t *testing.T) {
+	os.Setenv("TENANT", "tenant-b")
+	os.Setenv("ENV", "dev")
+	defer os.Unsetenv("TENANT")
+	defer os.Unsetenv("ENV")
+	if _, err := auth.ParsePolicyFiles("test-vars.yaml"); err == nil {
+		t.Fatal("env lookup must be disabled by default")
+	}
+
+	auth.SetEnvLookup(true)
+	defer auth.SetEnvLookup(false)
+	p, err := auth.ParsePolicyFiles("test-vars.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	permissions := p.Roles["tenant-b-admin"]
+	sort.Strings(permissions)
+	if !reflect.DeepEqual(permissions, []string{"dev:deploy", "price:$", "tenant-b:orders:.*"}) {
+		t.Fatal("unexpected permissions", p.Roles)
+	}
+}
@@ -0,0 +1,157 @@
+package rbacmap
+
+import (
+	auth "github.com/z26100/rbac-go/auth"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rbac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "policy.yaml")
+
+	auth.NewRBAC()
+	defer auth.CloseRBAC()
+	if err := auth.LoadFromFile("policy.d"); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.SaveAsFilename(filename); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := ioutil.ReadFile(filename)
+
+	auth.NewRBAC()
+	if err := auth.LoadFromFile("test.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.SaveAsFilename(filename); err != nil {
+		t.Fatal(err)
+	}
+	expected := `roles:
+    role-0:
+        - p-0
+    role-1:
+        - p-1
+inher:
+    role-0: []
+    role-1:
+        - role-0
+`
+	data, _ := ioutil.ReadFile(filename)
+	if string(data) == string(first) || string(data) != expected {
+		t.Fatal("unexpected content", string(data))
+	}
+	entries, _ := ioutil.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fatal("temporary files left behind", len(entries))
+	}
+}
+
+func TestSaveModeAndSymlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rbac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "policy.yaml")
+	link := filepath.Join(dir, "current.yaml")
+	if err := ioutil.WriteFile(target, []byte("roles: {}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	auth.NewRBAC()
+	defer auth.CloseRBAC()
+	if err := auth.LoadFromFile("test.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.SaveAsFilename(link); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatal("symlink replaced", err)
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatal("mode not kept", info.Mode())
+	}
+	if data, _ := ioutil.ReadFile(target); string(data) == "roles: {}\n" {
+		t.Fatal("target not written")
+	}
+
+	created := filepath.Join(dir, "new.yaml")
+	if err := auth.SaveAsFilename(created); err != nil {
+		t.Fatal(err)
+	}
+	if info, err = os.Stat(created); err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Fatal("unexpected mode", info.Mode())
+	}
+}
+
+func TestSaveOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rbac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	auth.NewRBAC()
+	defer auth.CloseRBAC()
+	if err := auth.LoadFromFile("conflict/b.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	auth.SetSaveOptions(auth.SaveOptions{Indent: 2, Comment: "generated", SourceComments: true})
+	defer auth.SetSaveOptions(auth.SaveOptions{})
+	if err := auth.SaveAsFilename(filepath.Join(dir, "policy.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# generated
+roles:
+  # source: conflict/b.yaml
+  base: []
+  # source: conflict/b.yaml
+  viewer:
+    - get:b
+inher:
+  base: []
+  viewer: []
+`
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "policy.yaml"))
+	if string(data) != expected {
+		t.Fatal("unexpected content", string(data))
+	}
+	if err := auth.SaveAsFilename(filepath.Join(dir, "policy.json")); err != nil {
+		t.Fatal(err)
+	}
+	expected = `{
+  "roles": {
+    "base": [],
+    "viewer": [
+      "get:b"
+    ]
+  },
+  "inher": {
+    "base": [],
+    "viewer": []
+  }
+}
+`
+	data, _ = ioutil.ReadFile(filepath.Join(dir, "policy.json"))
+	if string(data) != expected {
+		t.Fatal("unexpected content", string(data))
+	}
+}
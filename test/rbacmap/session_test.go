@@ -0,0 +1,178 @@
+package rbacmap
+
+import (
+	"errors"
+	rbac2 "github.com/z26100/rbac-go"
+	auth "github.com/z26100/rbac-go/auth"
+	"testing"
+	"time"
+)
+
+func TestSession(t *testing.T) {
+	auth.NewRBAC()
+	defer auth.CloseRBAC()
+	if err := auth.LoadFromFile("test-sod.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.NewSession("carol", rbac2.SessionOptions{}); !errors.Is(err, rbac2.ErrRoleNotExist) {
+		t.Fatal("unexpected error", err)
+	}
+	s, err := auth.NewSession("Alice", rbac2.SessionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	approve := rbac2.RBACPermission{Name: "approve:payments"}
+	read := rbac2.RBACPermission{Name: "read:payments"}
+	if s.IsGranted(approve, nil) || s.IsGranted(read, nil) {
+		t.Fatal("permission granted without active roles")
+	}
+	if err := s.Activate("payments-submitter"); !errors.Is(err, rbac2.ErrRoleNotHeld) {
+		t.Fatal("unexpected error", err)
+	}
+	// roles inherited by way of other roles can be activated as well
+	if err := s.Activate("payments-auditor"); err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsGranted(read, nil) || s.IsGranted(approve, nil) {
+		t.Fatal("problem with session permissions", s.ActiveRoles())
+	}
+	if err := s.Activate("finance", "payments-approver"); err != nil {
+		t.Fatal(err)
+	}
+	if roles := s.ActiveRoles(); len(roles) != 3 || !s.IsGranted(approve, nil) {
+		t.Fatal("problem with session permissions", roles)
+	}
+	s.Deactivate("PAYMENTS-APPROVER", "unknown")
+	if s.IsGranted(approve, nil) || !s.IsGranted(read, nil) {
+		t.Fatal("problem with session permissions", s.ActiveRoles())
+	}
+	// roles no longer held don't grant permissions
+	r := auth.GetBackend()
+	if err := r.RemoveParent("alice", "finance"); err != nil {
+		t.Fatal(err)
+	}
+	if s.IsGranted(read, nil) {
+		t.Fatal("permission of a role no longer held granted")
+	}
+	s.Close()
+	if !s.Expired() || s.ActiveRoles() != nil {
+		t.Fatal("session not closed")
+	}
+	if err := s.Activate("payments-approver"); !errors.Is(err, rbac2.ErrSessionExpired) {
+		t.Fatal("unexpected error", err)
+	}
+}
+
+func TestSessionDynamicSoD(t *testing.T) {
+	auth.NewRBAC()
+	defer auth.CloseRBAC()
+	if err := auth.LoadFromFile("test-sod.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	r := auth.GetBackend()
+	r.RemoveConstraint("payments")
+	if err := r.SetParent("alice", "payments-submitter"); err != nil {
+		t.Fatal(err)
+	}
+	s, err := r.NewSession("alice", rbac2.SessionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Activate("payments-approver", "payments-submitter"); err != nil {
+		t.Fatal(err)
+	}
+	err = s.Activate("finance")
+	var ce *rbac2.ConstraintError
+	if !errors.As(err, &ce) || ce.Constraint != "payments-session" {
+		t.Fatal("unexpected error", err)
+	}
+	if len(s.ActiveRoles()) != 2 {
+		t.Fatal("roles activated despite violation", s.ActiveRoles())
+	}
+	s.Deactivate("payments-submitter")
+	if err := s.Activate("finance"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSessionTTL(t *testing.T) {
+	auth.NewRBAC()
+	defer auth.CloseRBAC()
+	if err := auth.LoadFromFile("test-sod.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	r := auth.GetBackend()
+	now := time.Date(2026, 2, 1, 12, 0, 0, 0, time.UTC)
+	r.SetClock(func() time.Time { return now })
+	s, err := r.NewSession("bob", rbac2.SessionOptions{TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Expires().Equal(now.Add(time.Hour)) {
+		t.Fatal("unexpected expiry", s.Expires())
+	}
+	if err := s.Activate("payments-submitter"); err != nil {
+		t.Fatal(err)
+	}
+	submit := rbac2.RBACPermission{Name: "submit:payments"}
+	if !s.IsGranted(submit, nil) {
+		t.Fatal("problem with session permissions")
+	}
+	now = now.Add(time.Hour)
+	if !s.Expired() || s.IsGranted(submit, nil) {
+		t.Fatal("session not expired")
+	}
+	if err := s.Activate("bob"); !errors.Is(err, rbac2.ErrSessionExpired) {
+		t.Fatal("unexpected error", err)
+	}
+}
+
+func TestSessionReauthentication(t *testing.T) {
+	auth.NewRBAC()
+	defer auth.CloseRBAC()
+	if err := auth.LoadFromFile("test-sod.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	r := auth.GetBackend()
+	s, err := r.NewSession("alice", rbac2.SessionOptions{Privileged: []string{"payments-auditor"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Activate("payments-approver"); err != nil {
+		t.Fatal(err)
+	}
+	// finance inherits from the privileged role
+	if err := s.Activate("finance"); !errors.Is(err, rbac2.ErrReauthenticationFailed) {
+		t.Fatal("unexpected error", err)
+	}
+
+	var asked []string
+	password := "wrong"
+	s, err = r.NewSession("alice", rbac2.SessionOptions{
+		Privileged: []string{"Payments-Auditor"},
+		Reauthenticate: func(subject, role string) error {
+			asked = append(asked, subject+":"+role)
+			if password != "secret" {
+				return errors.New("invalid password")
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Activate("finance"); !errors.Is(err, rbac2.ErrReauthenticationFailed) || len(s.ActiveRoles()) != 0 {
+		t.Fatal("unexpected error", err)
+	}
+	password = "secret"
+	if err := s.Activate("finance"); err != nil {
+		t.Fatal(err)
+	}
+	// active roles aren't re-authenticated again
+	if err := s.Activate("finance", "payments-approver"); err != nil {
+		t.Fatal(err)
+	}
+	if len(asked) != 2 || asked[1] != "alice:finance" {
+		t.Fatal("unexpected re-authentications", asked)
+	}
+}
@@ -0,0 +1,171 @@
+package rbacmap
+
+import (
+	"bytes"
+	"errors"
+	rbac2 "github.com/z26100/rbac-go"
+	auth "github.com/z26100/rbac-go/auth"
+	"testing"
+)
+
+func TestSoD(t *testing.T) {
+	auth.NewRBAC()
+	defer auth.CloseRBAC()
+	if err := auth.LoadFromFile("test-sod.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	r := auth.GetBackend()
+	err := r.SetParent("alice", "payments-submitter")
+	var ce *rbac2.ConstraintError
+	if !errors.As(err, &ce) || ce.Constraint != "payments" || ce.Role != "alice" {
+		t.Fatal("unexpected error", err)
+	}
+	expected := `constraint violated: "payments": role "alice" holds "payments-approver", "payments-submitter", at most 1 allowed`
+	if err.Error() != expected {
+		t.Fatal("unexpected message", err)
+	}
+	// inherited conflicts are found for every descendant
+	if err := r.SetParents("finance", []string{"payments-submitter"}); !errors.Is(err, rbac2.ErrConstraintViolated) {
+		t.Fatal("unexpected error", err)
+	}
+	if parents, _ := r.GetParents("finance"); len(parents) != 1 {
+		t.Fatal("parents bound despite violation", parents)
+	}
+	if err := r.SetParent("bob", "finance"); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Violations()) != 0 {
+		t.Fatal("unexpected violations", r.Violations())
+	}
+
+	err = r.CheckActiveRoles("bob", []string{"payments-submitter", "finance"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.CheckActiveRoles("carol", []string{"payments-approver", "finance", "payments-submitter"})
+	if !errors.As(err, &ce) || ce.Constraint != "payments" || ce.Role != "carol" {
+		t.Fatal("unexpected error", err)
+	}
+
+	if err := r.AddConstraint(rbac2.SoDConstraint{Name: "payments", Roles: []string{"alice", "bob"}}); !errors.Is(err, rbac2.ErrConstraintExist) {
+		t.Fatal("unexpected error", err)
+	}
+	if err := r.AddConstraint(rbac2.SoDConstraint{Name: "audit", Roles: []string{"payments-auditor", "payments-submitter"}}); !errors.Is(err, rbac2.ErrConstraintViolated) {
+		t.Fatal("unexpected error", err)
+	}
+	if err := r.AddConstraint(rbac2.SoDConstraint{Name: "audit", Roles: []string{"payments-auditor"}}); !errors.Is(err, rbac2.ErrInvalidConstraint) {
+		t.Fatal("unexpected error", err)
+	}
+	r.RemoveConstraint("payments")
+	if err := r.SetParent("alice", "payments-submitter"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDynamicSoD(t *testing.T) {
+	auth.NewRBAC()
+	defer auth.CloseRBAC()
+	if err := auth.LoadFromFile("test-sod.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	r := auth.GetBackend()
+	r.RemoveConstraint("payments")
+	if err := r.SetParent("alice", "payments-submitter"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.CheckActiveRoles("alice", []string{"payments-approver", "payments-submitter"}); err != nil {
+		t.Fatal(err)
+	}
+	err := r.CheckActiveRoles("alice", []string{"alice"})
+	var ce *rbac2.ConstraintError
+	if !errors.As(err, &ce) || ce.Constraint != "payments-session" || len(ce.Roles) != 3 || ce.Max != 2 {
+		t.Fatal("unexpected error", err)
+	}
+}
+
+func TestSaveConstraints(t *testing.T) {
+	for _, ft := range []auth.FileType{auth.YAML, auth.JSON, auth.TOML, auth.HCL} {
+		auth.NewRBAC()
+		if err := auth.LoadFromFile("test-sod.yaml"); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := auth.Save(&buf, ft); err != nil {
+			t.Fatal(err)
+		}
+		auth.CloseRBAC()
+		auth.NewRBAC()
+		if err := auth.Load(&buf, ft); err != nil {
+			t.Fatal(ft, err)
+		}
+		constraints := auth.GetBackend().Constraints()
+		if len(constraints) != 2 || constraints[0].ConstraintName() != "payments" {
+			t.Fatal("constraints not restored", ft, constraints)
+		}
+		if c, ok := constraints[1].(rbac2.SoDConstraint); !ok || c.Max != 2 || !c.Dynamic || len(c.Roles) != 3 {
+			t.Fatal("constraints not restored", ft, constraints)
+		}
+		auth.CloseRBAC()
+	}
+}
+
+func TestValidateConstraints(t *testing.T) {
+	data := `roles:
+    approver: []
+    submitter: []
+    alice: []
+inher:
+    alice:
+        - approver
+        - submitter
+constraints:
+    payments:
+        roles: [approver, submitter]
+    unknown:
+        roles: [approver, auditor]
+    single:
+        roles: [approver]
+    wide:
+        roles: [approver, submitter]
+        max: 2
+    audit:
+        roles: [approver, reviewer]
+`
+	_, err := auth.ParsePolicy("sod.yaml", []byte(data))
+	expected := []string{
+		`sod.yaml:15:9: invalid constraint "single": at least 2 roles are required`,
+		`sod.yaml:17:9: invalid constraint "wide": max must be between 1 and 1`,
+		`sod.yaml:20:27: unknown role "reviewer" in constraint "audit"`,
+		`sod.yaml:13:27: unknown role "auditor" in constraint "unknown"`,
+	}
+	v, ok := err.(*auth.ValidationError)
+	if !ok || len(v.Errors) != len(expected) {
+		t.Fatal("unexpected errors", err)
+	}
+	for i, e := range v.Errors {
+		if e.Error() != expected[i] {
+			t.Fatal("unexpected error", e)
+		}
+	}
+
+	data = `roles:
+    approver: []
+    submitter: []
+    alice: []
+inher:
+    alice:
+        - approver
+        - submitter
+constraints:
+    payments:
+        roles: [approver, submitter]
+`
+	_, err = auth.ParsePolicy("sod.yaml", []byte(data))
+	expected = []string{
+		`sod.yaml:6:5: constraint violated: "payments": role "alice" holds "approver", "submitter", at most 1 allowed`,
+	}
+	v, ok = err.(*auth.ValidationError)
+	if !ok || len(v.Errors) != len(expected) || v.Errors[0].Error() != expected[0] {
+		t.Fatal("unexpected errors", err)
+	}
+}
@@ -0,0 +1,65 @@
+package rbacmap
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	auth "github.com/z26100/rbac-go/auth"
+	"strings"
+	"testing"
+)
+
+//go:embed policy.d shared
+var bundle embed.FS
+
+func TestLoadSaveStream(t *testing.T) {
+	auth.NewRBAC()
+	defer auth.CloseRBAC()
+	err := auth.Load(strings.NewReader(`{"roles": {"role-1": ["p-1"]}}`), auth.JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := auth.Save(&buf, auth.TOML); err != nil {
+		t.Fatal(err)
+	}
+	expected := `[roles]
+role-1 = ["p-1"]
+
+[inher]
+role-1 = []
+`
+	if buf.String() != expected {
+		t.Fatal("unexpected output", buf.String())
+	}
+
+	auth.NewRBAC()
+	if err := auth.Load(&buf, auth.TOML); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := auth.GetRole("role-1"); err != nil {
+		t.Fatal(err)
+	}
+	err = auth.Load(strings.NewReader("include: [test.yaml]\n"), auth.YAML)
+	if !errors.Is(err, auth.ErrInvalidPolicy) || !strings.Contains(err.Error(), auth.ErrIncludeNotSupported.Error()) {
+		t.Fatal("expected ErrIncludeNotSupported", err)
+	}
+}
+
+func TestLoadFS(t *testing.T) {
+	auth.NewRBAC()
+	defer auth.CloseRBAC()
+	if err := auth.LoadFS(bundle, "policy.d"); err != nil {
+		t.Fatal(err)
+	}
+	role, _, err := auth.GetRole("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role.Source != "shared/admin.yaml" {
+		t.Fatal("unexpected source", role.Source)
+	}
+	if _, err := auth.ParsePolicyFS(bundle, "*.yaml"); err == nil {
+		t.Fatal("expected no policy files found")
+	}
+}
@@ -0,0 +1,82 @@
+package rbacmap
+
+import (
+	rbac2 "github.com/z26100/rbac-go"
+	auth "github.com/z26100/rbac-go/auth"
+	"reflect"
+	"testing"
+)
+
+func TestSync(t *testing.T) {
+	auth.NewRBAC()
+	defer auth.CloseRBAC()
+	err := auth.LoadFromFile("test.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err := auth.Sync("test-diff.yaml", auth.SyncOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs.RemovedRoles) != 0 || len(cs.RemovedParents) != 0 {
+		t.Fatal("unexpected removals", cs.String())
+	}
+	if _, _, err := auth.GetRole("role-2"); err == nil {
+		t.Fatal("dry run must not apply changes")
+	}
+
+	_, err = auth.Sync("test-diff.yaml", auth.SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	role, parents, err := auth.GetRole("role-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(role.Permissions) != 2 {
+		t.Fatal("unexpected permissions", role.Permissions)
+	}
+	if len(parents) != 2 {
+		t.Fatal("unexpected parents", parents)
+	}
+	if _, _, err := auth.GetRole("role-0"); err != nil {
+		t.Fatal("role-0 must be kept without prune")
+	}
+
+	_, err = auth.Sync("test-diff.yaml", auth.SyncOptions{Prune: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := auth.GetRole("role-0"); err == nil {
+		t.Fatal("role-0 must be pruned")
+	}
+	_, parents, _ = auth.GetRole("role-1")
+	if !reflect.DeepEqual(parents, []string{"role-2"}) {
+		t.Fatal("unexpected parents", parents)
+	}
+	cs, err = auth.DiffFile("test-diff.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cs.Empty() {
+		t.Fatal("policy not in sync", cs.String())
+	}
+}
+
+func TestApplyPartially(t *testing.T) {
+	r := rbac2.Default()
+	cs := &rbac2.Changeset{
+		AddedRoles:   []rbac2.RoleChange{{ID: "role-1", Name: "role-1", AddedPermissions: []string{"read"}}},
+		AddedParents: []rbac2.Edge{{Child: "role-1", Parent: "role-2"}},
+	}
+	applied, err := r.Apply(cs)
+	if err != rbac2.ErrRoleNotExist {
+		t.Fatal("unexpected error", err)
+	}
+	if len(applied.AddedRoles) != 1 || len(applied.AddedParents) != 0 {
+		t.Fatal("unexpected applied changes", applied.String())
+	}
+	if _, _, err := r.Get("role-1"); err != nil {
+		t.Fatal("applied changes must be kept", err)
+	}
+}
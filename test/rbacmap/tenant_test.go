@@ -0,0 +1,163 @@
+package rbacmap
+
+import (
+	"errors"
+	rbac2 "github.com/z26100/rbac-go"
+	"testing"
+)
+
+func newTenants(t *testing.T) *rbac2.Tenants {
+	global := rbac2.Default()
+	reader := &rbac2.RBACRole{Name: "reader"}
+	reader.AddPermission(&rbac2.RBACPermission{Name: "^read:.*$"})
+	auditor := &rbac2.RBACRole{Name: "auditor"}
+	auditor.AddPermission(&rbac2.RBACPermission{Name: "^audit:.*$"})
+	for _, role := range []*rbac2.RBACRole{reader, auditor} {
+		if err := global.Add(role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := global.SetParent("auditor", "reader"); err != nil {
+		t.Fatal(err)
+	}
+	return rbac2.NewTenants(global, func(tenant string) (rbac2.Backend, error) {
+		return rbac2.NewMapBackend(), nil
+	})
+}
+
+func addTenantRoles(t *testing.T, r *rbac2.RBAC, permission string) {
+	admin := &rbac2.RBACRole{Name: "admin"}
+	admin.AddPermission(&rbac2.RBACPermission{Name: permission})
+	for _, role := range []*rbac2.RBACRole{admin, {Name: "alice"}} {
+		if err := r.Add(role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.SetParent("alice", "admin"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTenants(t *testing.T) {
+	tenants := newTenants(t)
+	acme, err := tenants.Tenant("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	globex, err := tenants.Tenant("globex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := tenants.Tenant("acme"); again != acme {
+		t.Fatal("tenant not cached")
+	}
+	if _, err := tenants.Tenant("acme/roles"); !errors.Is(err, rbac2.ErrInvalidTenant) {
+		t.Fatal("unexpected error", err)
+	}
+	addTenantRoles(t, acme, "^deploy:acme$")
+	addTenantRoles(t, globex, "^deploy:globex$")
+	for _, c := range []struct {
+		tenant     *rbac2.RBAC
+		permission string
+		granted    bool
+	}{
+		{acme, "deploy:acme", true},
+		{acme, "deploy:globex", false},
+		{globex, "deploy:globex", true},
+		{globex, "deploy:acme", false},
+		{acme, "read:docs", false},
+	} {
+		if c.tenant.IsGranted("alice", rbac2.RBACPermission{Name: c.permission}, nil) != c.granted {
+			t.Fatal("problem with permission grant", c)
+		}
+	}
+
+	// tenant roles inherit from global roles and their parents
+	if err := acme.SetParent("alice", "reader"); !errors.Is(err, rbac2.ErrRoleNotExist) {
+		t.Fatal("unexpected error", err)
+	}
+	if err := acme.SetParent("alice", rbac2.GlobalPrefix+"auditor"); err != nil {
+		t.Fatal(err)
+	}
+	if !acme.IsGranted("alice", rbac2.RBACPermission{Name: "read:docs"}, nil) ||
+		globex.IsGranted("alice", rbac2.RBACPermission{Name: "read:docs"}, nil) {
+		t.Fatal("problem with global role")
+	}
+	s, err := acme.NewSession("alice", rbac2.SessionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Activate(rbac2.GlobalPrefix + "reader"); err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsGranted(rbac2.RBACPermission{Name: "read:docs"}, nil) || s.IsGranted(rbac2.RBACPermission{Name: "audit:docs"}, nil) {
+		t.Fatal("problem with session permissions", s.ActiveRoles())
+	}
+
+	// global roles are read-only within tenants
+	if err := acme.Add(&rbac2.RBACRole{Name: rbac2.GlobalPrefix + "admin"}); !errors.Is(err, rbac2.ErrGlobalRole) {
+		t.Fatal("unexpected error", err)
+	}
+	if err := acme.SetParent(rbac2.GlobalPrefix+"auditor", "admin"); !errors.Is(err, rbac2.ErrGlobalRole) {
+		t.Fatal("unexpected error", err)
+	}
+	if err := acme.Remove(rbac2.GlobalPrefix + "auditor"); !errors.Is(err, rbac2.ErrGlobalRole) {
+		t.Fatal("unexpected error", err)
+	}
+	if _, _, err := tenants.Global().Get("auditor"); err != nil {
+		t.Fatal("global role removed", err)
+	}
+
+	// constraints are limited to the tenant
+	err = acme.AddConstraint(rbac2.CardinalityConstraint{Name: "auditors", Role: rbac2.GlobalPrefix + "auditor", Max: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := globex.SetParent("alice", rbac2.GlobalPrefix+"auditor"); err != nil {
+		t.Fatal(err)
+	}
+	if err := acme.Add(&rbac2.RBACRole{Name: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	var ce *rbac2.CardinalityError
+	if err := acme.SetParent("bob", rbac2.GlobalPrefix+"auditor"); !errors.As(err, &ce) {
+		t.Fatal("unexpected error", err)
+	}
+}
+
+func TestDeleteTenant(t *testing.T) {
+	tenants := newTenants(t)
+	acme, err := tenants.Tenant("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTenantRoles(t, acme, "^deploy:acme$")
+	if err := acme.SetParent("alice", rbac2.GlobalPrefix+"auditor"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tenants.Tenant("globex"); err != nil {
+		t.Fatal(err)
+	}
+	if names := tenants.Names(); len(names) != 2 || names[0] != "acme" {
+		t.Fatal("unexpected tenants", names)
+	}
+	if err := tenants.DeleteTenant("acme"); err != nil {
+		t.Fatal(err)
+	}
+	if names := tenants.Names(); len(names) != 1 || names[0] != "globex" {
+		t.Fatal("unexpected tenants", names)
+	}
+	if _, _, err := acme.Get("alice"); !errors.Is(err, rbac2.ErrRoleNotExist) {
+		t.Fatal("tenant roles not removed", err)
+	}
+	if _, _, err := tenants.Global().Get("auditor"); err != nil {
+		t.Fatal("global role removed", err)
+	}
+	acme, err = tenants.Tenant("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acme.IsGranted("alice", rbac2.RBACPermission{Name: "deploy:acme"}, nil) {
+		t.Fatal("tenant recreated with old roles")
+	}
+}
@@ -0,0 +1,9 @@
+# the same policy as test.yaml
+roles {
+  "role-0" = ["p-0"]
+  "role-1" = ["p-1"]
+}
+
+inher = {
+  "role-1" = ["role-0"]
+}
@@ -0,0 +1,52 @@
+package rbacmap
+
+import (
+	"errors"
+	auth "github.com/z26100/rbac-go/auth"
+	"testing"
+)
+
+func TestValidateFile(t *testing.T) {
+	if err := auth.ValidateFile("test.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	err := auth.ValidateFile("test-invalid.yaml")
+	if !errors.Is(err, auth.ErrInvalidPolicy) {
+		t.Fatal("expected ErrInvalidPolicy", err)
+	}
+	expected := []string{
+		`test-invalid.yaml:6:11: invalid permission "p-(": error parsing regexp: missing closing ): ` + "`p-(`",
+		`test-invalid.yaml:7:13: permissions of role role-1 must be a list of strings`,
+		`test-invalid.yaml:8:5: duplicate role "Role-0", already defined as "role-0"`,
+		`test-invalid.yaml:9:1: unknown key "extra"`,
+		`test-invalid.yaml:2:22: unknown parent role "role-9"`,
+		`test-invalid.yaml:3:5: found circle: role-0 -> role-1 -> role-0`,
+	}
+	v := err.(*auth.ValidationError)
+	if len(v.Errors) != len(expected) {
+		t.Fatal("unexpected errors", err)
+	}
+	for i, e := range v.Errors {
+		if e.Error() != expected[i] {
+			t.Fatal("unexpected error", e)
+		}
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	auth.NewRBAC()
+	defer auth.CloseRBAC()
+	if err := auth.LoadFromFile("test-invalid.yaml"); err == nil {
+		t.Fatal("no exception")
+	}
+	if _, _, err := auth.GetRole("role-0"); err == nil {
+		t.Fatal("invalid policy must not be loaded")
+	}
+	if err := auth.LoadFromFile("test.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	var loadErr *auth.LoadError
+	if err := auth.LoadFromFile("test.yaml"); !errors.As(err, &loadErr) || len(loadErr.Errors) != 2 {
+		t.Fatal("expected an error for every existing role", err)
+	}
+}
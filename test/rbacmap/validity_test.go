@@ -0,0 +1,156 @@
+package rbacmap
+
+import (
+	"bytes"
+	"errors"
+	rbac2 "github.com/z26100/rbac-go"
+	auth "github.com/z26100/rbac-go/auth"
+	"testing"
+	"time"
+)
+
+type validityCase struct {
+	role       string
+	permission string
+	at         string
+	granted    bool
+}
+
+var validityCases = []validityCase{
+	{"alice", "read:docs", "2025-12-31T12:00:00Z", true},
+	{"alice", "write:repo", "2025-12-31T12:00:00Z", false},
+	{"alice", "write:repo", "2026-02-03T12:00:00Z", true},
+	{"alice", "write:repo", "2026-03-02T12:00:00Z", false},
+	{"contractor", "write:repo", "2026-03-02T12:00:00Z", true},
+	{"contractor", "write:repo", "2026-07-01T00:00:00Z", false},
+	// 2026-02-03 is a Tuesday, Berlin is at UTC+1
+	{"oncall", "restart:service", "2026-02-03T12:00:00Z", false},
+	{"oncall", "restart:service", "2026-02-03T19:00:00Z", true},
+	{"oncall", "restart:service", "2026-02-04T06:30:00Z", true},
+	{"oncall", "restart:service", "2026-02-04T03:30:00Z", false},
+	{"oncall", "restart:service", "2026-02-02T06:30:00Z", false},
+	{"oncall", "restart:service", "2026-02-01T12:00:00Z", true},
+	{"bob", "restart:service", "2026-02-01T12:00:00Z", false},
+	{"bob", "restart:service", "2026-02-03T19:00:00Z", true},
+}
+
+func checkValidityCases(t *testing.T, what interface{}) {
+	for i, c := range validityCases {
+		at, _ := time.Parse(time.RFC3339, c.at)
+		auth.GetBackend().SetClock(func() time.Time { return at })
+		if auth.IsGranted(c.role, rbac2.RBACPermission{Name: c.permission}, nil) != c.granted {
+			t.Fatal("problem with permission grant", what, i, c)
+		}
+	}
+}
+
+func TestValidity(t *testing.T) {
+	auth.NewRBAC()
+	defer auth.CloseRBAC()
+	if err := auth.LoadFromFile("test-validity.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	checkValidityCases(t, "loaded")
+
+	r := auth.GetBackend()
+	if err := r.SetParentValidity("bob", "reader", nil); !errors.Is(err, rbac2.ErrParentNotExist) {
+		t.Fatal("unexpected error", err)
+	}
+	if err := r.SetParentValidity("bob", "oncall", &rbac2.Validity{Schedule: "Mon-Fri 9:00"}); !errors.Is(err, rbac2.ErrInvalidSchedule) {
+		t.Fatal("unexpected error", err)
+	}
+	if err := r.SetParentValidity("bob", "oncall", nil); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := r.GetParentValidity("bob", "oncall"); v != nil || err != nil {
+		t.Fatal("validity not removed", v, err)
+	}
+	r.SetClock(func() time.Time { return time.Date(2026, 2, 1, 12, 0, 0, 0, time.UTC) })
+	if !auth.IsGranted("bob", rbac2.RBACPermission{Name: "restart:service"}, nil) {
+		t.Fatal("problem with permission grant")
+	}
+}
+
+func TestSaveValidity(t *testing.T) {
+	for _, ft := range []auth.FileType{auth.YAML, auth.JSON, auth.TOML, auth.HCL} {
+		auth.NewRBAC()
+		if err := auth.LoadFromFile("test-validity.yaml"); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := auth.Save(&buf, ft); err != nil {
+			t.Fatal(err)
+		}
+		auth.CloseRBAC()
+		auth.NewRBAC()
+		if err := auth.Load(&buf, ft); err != nil {
+			t.Fatal(ft, err)
+		}
+		checkValidityCases(t, ft)
+		auth.CloseRBAC()
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	for _, c := range []struct {
+		schedule string
+		at       string
+		active   bool
+	}{
+		{"*", "2026-02-01T00:00:00Z", true},
+		{"Mon", "2026-02-01T23:59:00Z", false},
+		{"Fri-Mon 22:00-06:00", "2026-02-03T05:00:00Z", true},
+		{"Fri-Mon 22:00-06:00", "2026-02-03T06:00:00Z", false},
+		{"Fri-Mon 22:00-06:00", "2026-02-04T05:00:00Z", false},
+		{"Tue 09:00-17:00 America/New_York", "2026-02-03T15:00:00Z", true},
+		{"Tue 09:00-17:00 America/New_York", "2026-02-03T22:00:00Z", false},
+		{"sat,SUN 00:00-24:00", "2026-02-01T23:59:59Z", true},
+	} {
+		s, err := rbac2.ParseSchedule(c.schedule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		at, _ := time.Parse(time.RFC3339, c.at)
+		if s.Active(at) != c.active {
+			t.Fatal("unexpected schedule result", c)
+		}
+	}
+	for _, src := range []string{"", "Mon;", "Someday", "Mon 09:00-09:00", "Mon 25:00-26:00", "Mon 09:00-17:00 Nowhere/Land", "Mon 1-2 UTC x"} {
+		if _, err := rbac2.ParseSchedule(src); !errors.Is(err, rbac2.ErrInvalidSchedule) {
+			t.Fatal("invalid schedule accepted", src, err)
+		}
+	}
+}
+
+func TestValidateValidity(t *testing.T) {
+	data := `roles:
+    contractor:
+        - permission: ^write:repo$
+          notBefore: 2026-07-01
+          notAfter: 2026-01-01
+        - permission: ^read:repo$
+          notAfter: tomorrow
+    alice: []
+inher:
+    alice:
+        - role: contractor
+          schedule: weekdays
+        - notAfter: 2026-01-01
+`
+	_, err := auth.ParsePolicy("validity.yaml", []byte(data))
+	expected := []string{
+		`validity.yaml:3:11: notBefore 2026-07-01T00:00:00Z is not before notAfter 2026-01-01T00:00:00Z`,
+		`validity.yaml:7:21: notAfter "tomorrow" is no RFC 3339 timestamp`,
+		`validity.yaml:12:21: invalid schedule "weekdays": unknown day "weekdays"`,
+		`validity.yaml:13:11: parents of role alice: parent without role`,
+	}
+	v, ok := err.(*auth.ValidationError)
+	if !ok || len(v.Errors) != len(expected) {
+		t.Fatal("unexpected errors", err)
+	}
+	for i, e := range v.Errors {
+		if e.Error() != expected[i] {
+			t.Fatal("unexpected error", e)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package rbacmap
+
+import (
+	"errors"
+	rbac2 "github.com/z26100/rbac-go"
+	auth "github.com/z26100/rbac-go/auth"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rbac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "policy.yaml")
+	if err := ioutil.WriteFile(filename, []byte("roles:\n    role-1: [p-1]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	auth.NewRBAC()
+	defer auth.CloseRBAC()
+
+	events := make(chan auth.ReloadEvent, 10)
+	w, err := auth.Watch(filename, 10*time.Millisecond, func(e auth.ReloadEvent) {
+		events <- e
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	<-events
+	if _, _, err := auth.GetRole("role-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filename, []byte("roles:\n    role-2: [p-2]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e := waitForEvent(t, events)
+	if e.Err != nil {
+		t.Fatal(e.Err)
+	}
+	if len(e.Changes.AddedRoles) != 1 || len(e.Changes.RemovedRoles) != 1 {
+		t.Fatal("unexpected changes", e.Changes)
+	}
+	if _, _, err := auth.GetRole("role-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filename, []byte("roles:\n    role-3: p-3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e = waitForEvent(t, events)
+	if e.Err == nil {
+		t.Fatal("expected a validation error")
+	}
+	if _, _, err := auth.GetRole("role-2"); err != nil {
+		t.Fatal("the previous policy must be kept", err)
+	}
+}
+
+func TestWatchWith(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rbac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "policy.yaml")
+	if err := ioutil.WriteFile(filename, []byte("roles:\n    admin: [p-1]\n    role-1: [p-1]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	auth.NewRBAC()
+	defer auth.CloseRBAC()
+	if err := auth.LoadFromFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	auth.GetBackend().SetClock(func() time.Time { return now })
+	if err := auth.GetBackend().AddConstraint(rbac2.CardinalityConstraint{Name: "admins", Role: "admin", Max: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	var built, shared int32
+	events := make(chan auth.ReloadEvent, 10)
+	w, err := auth.WatchWith(filename, 10*time.Millisecond, func() (*rbac2.RBAC, error) {
+		atomic.AddInt32(&built, 1)
+		if atomic.LoadInt32(&shared) == 1 {
+			return auth.GetBackend(), nil
+		}
+		return rbac2.Default(), nil
+	}, func(e auth.ReloadEvent) {
+		events <- e
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	<-events
+	if atomic.LoadInt32(&built) != 1 {
+		t.Fatal("instance not built by the factory")
+	}
+
+	// reloading into a fresh backend keeps clock and constraints
+	if err := ioutil.WriteFile(filename, []byte("roles:\n    admin: [p-2]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e := waitForEvent(t, events)
+	if e.Err != nil {
+		t.Fatal(e.Err)
+	}
+	r := auth.GetBackend()
+	if _, _, err := r.Get("role-1"); err == nil {
+		t.Fatal("removed role kept")
+	}
+	if clock := r.Clock(); clock == nil || !clock().Equal(now) {
+		t.Fatal("clock not kept")
+	}
+	if c := r.Constraints(); len(c) != 1 || c[0].ConstraintName() != "admins" {
+		t.Fatal("constraints not kept", c)
+	}
+
+	// the current backend can't be replaced atomically
+	atomic.StoreInt32(&shared, 1)
+	if err := ioutil.WriteFile(filename, []byte("roles:\n    admin: [p-3]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if e := waitForEvent(t, events); !errors.Is(e.Err, auth.ErrSharedBackend) {
+		t.Fatal("unexpected error", e.Err)
+	}
+	if auth.GetBackend() != r || !auth.IsGranted("admin", rbac2.RBACPermission{Name: "p-2"}, nil) {
+		t.Fatal("policy replaced")
+	}
+}
+
+func waitForEvent(t *testing.T, events chan auth.ReloadEvent) auth.ReloadEvent {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reload")
+	}
+	return auth.ReloadEvent{}
+}
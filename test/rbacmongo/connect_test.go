@@ -0,0 +1,22 @@
+package rbacmongo
+
+import (
+	rbac2 "github.com/z26100/rbac-go"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"testing"
+)
+
+// connect returns a client connected to the test server.
+func connect(t *testing.T) *mongo.Client {
+	client, err := mongo.NewClient(options.Client().ApplyURI(opts.GetURI()).SetAuth(*opts.Auth))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancelFc := rbac2.Ctx()
+	defer cancelFc()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
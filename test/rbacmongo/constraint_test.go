@@ -0,0 +1,43 @@
+package rbacmongo
+
+import (
+	"errors"
+	rbac2 "github.com/z26100/rbac-go"
+	"testing"
+)
+
+func TestSharedConstraints(t *testing.T) {
+	client := connect(t)
+	b1, err := rbac2.NewMongoBackend(client, "rbactest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b1.Close()
+	b2, err := rbac2.NewMongoBackend(client, "rbactest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r1, r2 := rbac2.New(b1), rbac2.New(b2)
+	r1.Clear()
+	for _, name := range []string{"admin", "alice", "bob"} {
+		if err := r1.Add(&rbac2.RBACRole{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r1.AddConstraint(rbac2.CardinalityConstraint{Name: "admins", Role: "admin", Max: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r2.SetParent("alice", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r2.SetParent("bob", "admin"); !errors.Is(err, rbac2.ErrConstraintViolated) {
+		t.Fatal("unexpected error", err)
+	}
+	constraints := r2.Constraints()
+	if len(constraints) != 1 {
+		t.Fatal("constraint not stored", constraints)
+	}
+	if c, ok := constraints[0].(rbac2.CardinalityConstraint); !ok || c.Role != "admin" || c.Max != 1 {
+		t.Fatal("constraint not restored", constraints)
+	}
+}
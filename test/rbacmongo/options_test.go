@@ -0,0 +1,58 @@
+package rbacmongo
+
+import (
+	rbac2 "github.com/z26100/rbac-go"
+	auth "github.com/z26100/rbac-go/auth"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"testing"
+	"time"
+)
+
+func TestMongoOptions(t *testing.T) {
+	_, err := rbac2.NewMongoBackend(nil, "rbactest",
+		rbac2.WithCollections("acl_roles", "acl_inheritance"),
+		rbac2.WithTimeout(time.Second),
+		rbac2.WithReadConcern(readconcern.Majority()),
+		rbac2.WithWriteConcern(writeconcern.New(writeconcern.WMajority())),
+		rbac2.WithReadPreference(readpref.SecondaryPreferred()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opt := range []rbac2.MongoOption{
+		rbac2.WithCollections("roles", "roles"),
+		rbac2.WithCollections("", "inheritance"),
+		rbac2.WithTimeout(0),
+	} {
+		if _, err := rbac2.NewMongoBackend(nil, "rbactest", opt); err == nil {
+			t.Fatal("invalid option accepted")
+		}
+	}
+}
+
+func TestNewMongoTimeout(t *testing.T) {
+	unreachable := *opts
+	unreachable.ApplyURI("mongodb://localhost:1")
+	start := time.Now()
+	err := auth.NewMongo(&unreachable, "rbactest", rbac2.WithTimeout(200*time.Millisecond))
+	if err == nil {
+		t.Fatal("connected to an unreachable server")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatal("timeout ignored", d)
+	}
+}
+
+func TestCustomCollections(t *testing.T) {
+	err := auth.NewMongo(opts, "rbactest", rbac2.WithCollections("acl_roles", "acl_inheritance"),
+		rbac2.WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.Clear()
+	defer auth.CloseRBAC()
+	if err := auth.LoadFromFile("test.yaml"); err != nil {
+		t.Fatal(err)
+	}
+}
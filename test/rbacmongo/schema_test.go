@@ -0,0 +1,135 @@
+package rbacmongo
+
+import (
+	"context"
+	"errors"
+	rbac2 "github.com/z26100/rbac-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEnsureSchema(t *testing.T) {
+	client := connect(t)
+	runs := 0
+	rename := rbac2.MongoMigration{
+		Version:     2,
+		Description: "rename struct to edge",
+		Migrate: func(ctx context.Context, roles, inheritance *mongo.Collection) error {
+			runs++
+			_, err := inheritance.UpdateMany(ctx, bson.M{"struct": bson.M{"$exists": true}},
+				bson.M{"$rename": bson.M{"struct": "edge"}})
+			return err
+		},
+	}
+	b, err := rbac2.NewMongoBackend(client, "rbactest", rbac2.WithMigrations(rename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if err := b.Drop(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := b.EnsureSchema(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v, err := b.SchemaVersion(); err != nil || v != 2 || runs != 1 {
+		t.Fatal("unexpected schema version", v, runs, err)
+	}
+
+	old, err := rbac2.NewMongoBackend(client, "rbactest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := old.EnsureSchema(); !errors.Is(err, rbac2.ErrSchemaVersion) {
+		t.Fatal("unexpected error", err)
+	}
+	rename.Version = 1
+	dup, err := rbac2.NewMongoBackend(client, "rbactest", rbac2.WithMigrations(rename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dup.EnsureSchema(); err == nil {
+		t.Fatal("duplicate migration version accepted")
+	}
+}
+
+func TestEnsureSchemaLock(t *testing.T) {
+	client := connect(t)
+	var runs int32
+	slow := rbac2.MongoMigration{
+		Version:     2,
+		Description: "slow",
+		Migrate: func(ctx context.Context, roles, inheritance *mongo.Collection) error {
+			atomic.AddInt32(&runs, 1)
+			time.Sleep(200 * time.Millisecond)
+			return nil
+		},
+	}
+	b, err := rbac2.NewMongoBackend(client, "rbactest", rbac2.WithMigrations(slow))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if err := b.Drop(); err != nil {
+		t.Fatal(err)
+	}
+	// processes starting at once migrate one after the other
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			other, err := rbac2.NewMongoBackend(client, "rbactest", rbac2.WithMigrations(slow))
+			if err == nil {
+				err = other.EnsureSchema()
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v, err := b.SchemaVersion(); err != nil || v != 2 || atomic.LoadInt32(&runs) != 1 {
+		t.Fatal("unexpected schema version", v, runs, err)
+	}
+}
+
+func TestClearKeepsSchema(t *testing.T) {
+	client := connect(t)
+	b, err := rbac2.NewMongoBackend(client, "rbactest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if err := b.Drop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.EnsureSchema(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := b.SchemaVersion(); err != nil || v == 0 {
+		t.Fatal("schema version cleared", v, err)
+	}
+	ctx, cancelFc := b.Ctx()
+	defer cancelFc()
+	cursor, err := client.Database("rbactest").Collection("inheritance").Indexes().List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var indexes []bson.M
+	if err := cursor.All(ctx, &indexes); err != nil {
+		t.Fatal(err)
+	}
+	// the _id index and the child, parent and expiresAt indexes
+	if len(indexes) != 4 {
+		t.Fatal("indexes dropped", indexes)
+	}
+}
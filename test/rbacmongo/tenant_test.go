@@ -0,0 +1,59 @@
+package rbacmongo
+
+import (
+	rbac2 "github.com/z26100/rbac-go"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"testing"
+)
+
+func TestTenant(t *testing.T) {
+	client, err := mongo.NewClient(options.Client().ApplyURI(opts.GetURI()).SetAuth(*opts.Auth))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancelFc := rbac2.Ctx()
+	defer cancelFc()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	b, err := rbac2.NewMongoBackend(client, "rbactest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	tenants := rbac2.NewTenants(nil, func(tenant string) (rbac2.Backend, error) {
+		return b.Tenant(tenant)
+	})
+	for _, name := range []string{"acme", "globex"} {
+		r, err := tenants.Tenant(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Clear()
+		role := &rbac2.RBACRole{Name: "admin"}
+		role.AddPermission(&rbac2.RBACPermission{Name: "^deploy:" + name + "$"})
+		if err := r.Add(role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	acme, _ := tenants.Tenant("acme")
+	if !acme.IsGranted("admin", rbac2.RBACPermission{Name: "deploy:acme"}, nil) ||
+		acme.IsGranted("admin", rbac2.RBACPermission{Name: "deploy:globex"}, nil) {
+		t.Fatal("tenants not isolated")
+	}
+	// closing a tenant leaves the shared client connected
+	if err := acme.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tenants.DeleteTenant("acme"); err != nil {
+		t.Fatal(err)
+	}
+	globex, _ := tenants.Tenant("globex")
+	if _, _, err := globex.Get("admin"); err != nil {
+		t.Fatal("other tenant removed", err)
+	}
+	if err := tenants.DeleteTenant("globex"); err != nil {
+		t.Fatal(err)
+	}
+}
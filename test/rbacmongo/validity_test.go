@@ -0,0 +1,63 @@
+package rbacmongo
+
+import (
+	rbac2 "github.com/z26100/rbac-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"testing"
+	"time"
+)
+
+func TestExpiringGrants(t *testing.T) {
+	client := connect(t)
+	b, err := rbac2.NewMongoBackend(client, "rbactest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if err := b.EnsureSchema(); err != nil {
+		t.Fatal(err)
+	}
+	r := rbac2.New(b)
+	if err := r.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	end := time.Now().Add(time.Hour).UTC().Truncate(time.Millisecond)
+	role := &rbac2.RBACRole{Name: "contractor"}
+	role.AddPermission(&rbac2.RBACPermission{Name: "read"})
+	role.AddPermission(&rbac2.RBACPermission{Name: "write", Validity: &rbac2.Validity{NotAfter: end}})
+	role.AddDeny(&rbac2.RBACPermission{Name: "delete", Validity: &rbac2.Validity{NotAfter: end}})
+	if err := r.Add(role); err != nil {
+		t.Fatal(err)
+	}
+
+	// grants which expire are stored apart, so the TTL index removes them
+	ctx, cancelFc := b.Ctx()
+	defer cancelFc()
+	cursor, err := client.Database("rbactest").Collection("grants").Find(ctx, bson.M{"role": "contractor"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var grants []rbac2.GrantDocument
+	if err := cursor.All(ctx, &grants); err != nil {
+		t.Fatal(err)
+	}
+	if len(grants) != 2 || !grants[0].ExpiresAt.Equal(end) || !grants[1].ExpiresAt.Equal(end) {
+		t.Fatal("unexpected grants", grants)
+	}
+
+	stored, _, err := r.Get("contractor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := stored.(*rbac2.RBACRole)
+	if len(rr.Permissions) != 2 || len(rr.Deny) != 1 || !rr.Permissions["write"].Validity.NotAfter.Equal(end) {
+		t.Fatal("grants not restored", rr.Permissions, rr.Deny)
+	}
+
+	if err := r.Remove("contractor"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := client.Database("rbactest").Collection("grants").CountDocuments(ctx, bson.M{}); err != nil || n != 0 {
+		t.Fatal("grants of removed role kept", n, err)
+	}
+}
@@ -0,0 +1,74 @@
+package rbacmongo
+
+import (
+	rbac2 "github.com/z26100/rbac-go"
+	auth "github.com/z26100/rbac-go/auth"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rbac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "policy.yaml")
+	if err := ioutil.WriteFile(filename, []byte("roles:\n    admin: [p-1]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.NewMongo(opts, "rbactest"); err != nil {
+		t.Fatal(err)
+	}
+	auth.Clear()
+	defer auth.CloseRBAC()
+	events := make(chan auth.ReloadEvent, 10)
+	w, err := auth.Watch(filename, 10*time.Millisecond, func(e auth.ReloadEvent) {
+		events <- e
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	<-events
+	loaded := auth.GetBackend()
+	if !auth.IsGranted("admin", rbac2.RBACPermission{Name: "p-1"}, nil) {
+		t.Fatal("policy not loaded")
+	}
+
+	// an invalid policy leaves the current one untouched
+	if err := ioutil.WriteFile(filename, []byte("roles:\n    admin: p-2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if e := waitForEvent(t, events); e.Err == nil {
+		t.Fatal("invalid policy loaded")
+	}
+	if auth.GetBackend() != loaded || !auth.IsGranted("admin", rbac2.RBACPermission{Name: "p-1"}, nil) {
+		t.Fatal("policy replaced")
+	}
+
+	if err := ioutil.WriteFile(filename, []byte("roles:\n    admin: [p-2]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if e := waitForEvent(t, events); e.Err != nil {
+		t.Fatal(e.Err)
+	}
+	if auth.GetBackend().SharesBackend(loaded) ||
+		!auth.IsGranted("admin", rbac2.RBACPermission{Name: "p-2"}, nil) ||
+		auth.IsGranted("admin", rbac2.RBACPermission{Name: "p-1"}, nil) {
+		t.Fatal("policy not replaced")
+	}
+}
+
+func waitForEvent(t *testing.T, events chan auth.ReloadEvent) auth.ReloadEvent {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reload")
+	}
+	return auth.ReloadEvent{}
+}
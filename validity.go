@@ -0,0 +1,208 @@
+package rbac
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidSchedule occurred if a schedule can't be parsed
+	ErrInvalidSchedule = errors.New("invalid schedule")
+	schedules          = make(map[string]*Schedule)
+	schedulesMux       sync.RWMutex
+)
+
+// Validity limits when a permission or an inheritance applies.
+// A zero NotBefore or NotAfter leaves the period open on that side,
+// and an empty Schedule applies at any time of the period.
+type Validity struct {
+	NotBefore time.Time `json:"notBefore,omitempty" bson:"notBefore,omitempty"`
+	NotAfter  time.Time `json:"notAfter,omitempty" bson:"notAfter,omitempty"`
+	Schedule  string    `json:"schedule,omitempty" bson:"schedule,omitempty"`
+}
+
+// Validate reports an empty period or an invalid schedule.
+func (v *Validity) Validate() error {
+	if !v.NotBefore.IsZero() && !v.NotAfter.IsZero() && !v.NotBefore.Before(v.NotAfter) {
+		return fmt.Errorf("notBefore %s is not before notAfter %s",
+			v.NotBefore.Format(time.RFC3339), v.NotAfter.Format(time.RFC3339))
+	}
+	if v.Schedule != "" {
+		if _, err := ParseSchedule(v.Schedule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Active reports whether `v` applies at `t`. A nil Validity always
+// applies, one with an invalid schedule never does.
+func (v *Validity) Active(t time.Time) bool {
+	if v == nil {
+		return true
+	}
+	if !v.NotBefore.IsZero() && t.Before(v.NotBefore) {
+		return false
+	}
+	if !v.NotAfter.IsZero() && !t.Before(v.NotAfter) {
+		return false
+	}
+	if v.Schedule == "" {
+		return true
+	}
+	s, err := compiledSchedule(v.Schedule)
+	return err == nil && s.Active(t)
+}
+
+// Schedule is a recurring set of weekly windows like
+//
+//	Mon-Fri 09:00-17:00 Europe/Berlin; Sat,Sun 10:00-12:00
+//
+// Each window lists days, single or as ranges, or `*` for every day,
+// optionally followed by a time range and a time zone, which defaults
+// to UTC. A window without a time range covers the whole day; a time
+// range ending before it starts ends on the next day.
+type Schedule struct {
+	windows []window
+}
+
+type window struct {
+	days     [7]bool
+	from, to int // minutes since midnight
+	loc      *time.Location
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseSchedule parses a schedule.
+func ParseSchedule(s string) (*Schedule, error) {
+	result := &Schedule{}
+	for _, part := range strings.Split(s, ";") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 || len(fields) > 3 {
+			return nil, fmt.Errorf("%w %q: expected days, a time range and a time zone", ErrInvalidSchedule, part)
+		}
+		w := window{from: 0, to: 24 * 60, loc: time.UTC}
+		if err := w.parseDays(fields[0]); err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidSchedule, part, err)
+		}
+		for _, field := range fields[1:] {
+			var err error
+			if strings.Contains(field, ":") && strings.Contains(field, "-") {
+				err = w.parseHours(field)
+			} else {
+				w.loc, err = time.LoadLocation(field)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%w %q: %v", ErrInvalidSchedule, part, err)
+			}
+		}
+		result.windows = append(result.windows, w)
+	}
+	return result, nil
+}
+
+func compiledSchedule(src string) (*Schedule, error) {
+	schedulesMux.RLock()
+	s, ok := schedules[src]
+	schedulesMux.RUnlock()
+	if ok {
+		return s, nil
+	}
+	s, err := ParseSchedule(src)
+	if err != nil {
+		return nil, err
+	}
+	schedulesMux.Lock()
+	schedules[src] = s
+	schedulesMux.Unlock()
+	return s, nil
+}
+
+func (w *window) parseDays(s string) error {
+	if s == "*" {
+		for i := range w.days {
+			w.days[i] = true
+		}
+		return nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		bounds := strings.SplitN(item, "-", 2)
+		from, ok := weekdays[strings.ToLower(bounds[0])]
+		if !ok {
+			return fmt.Errorf("unknown day %q", bounds[0])
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, ok = weekdays[strings.ToLower(bounds[1])]; !ok {
+				return fmt.Errorf("unknown day %q", bounds[1])
+			}
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (w *window) parseHours(s string) error {
+	bounds := strings.SplitN(s, "-", 2)
+	from, err := minutes(bounds[0])
+	if err != nil {
+		return err
+	}
+	to, err := minutes(bounds[1])
+	if err != nil {
+		return err
+	}
+	if from == to {
+		return fmt.Errorf("empty time range %q", s)
+	}
+	w.from, w.to = from, to
+	return nil
+}
+
+// minutes parses `HH:MM` into minutes since midnight; 24:00 is allowed.
+func minutes(s string) (int, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	h, err1 := strconv.Atoi(parts[0])
+	m, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return h*60 + m, nil
+}
+
+// Active reports whether `t` lies in a window of the schedule.
+func (s *Schedule) Active(t time.Time) bool {
+	for _, w := range s.windows {
+		local := t.In(w.loc)
+		m := local.Hour()*60 + local.Minute()
+		day := local.Weekday()
+		if w.from < w.to {
+			if w.days[day] && m >= w.from && m < w.to {
+				return true
+			}
+			continue
+		}
+		// the window wraps midnight: it starts on a listed day
+		// and ends on the following one
+		if w.days[day] && m >= w.from || w.days[(day+6)%7] && m < w.to {
+			return true
+		}
+	}
+	return false
+}